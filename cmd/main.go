@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/apex/log"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -10,7 +15,6 @@ import (
 
 func main() {
 	log.SetHandler(pkg.NewLogHandler())
-	// go startHTTPServer()
 
 	bot, err := tgbotapi.NewBotAPI(os.Getenv("TOKEN"))
 	if err != nil {
@@ -21,6 +25,87 @@ func main() {
 
 	log.Infof("Authorized on account %s", bot.Self.UserName)
 
+	dbPath := os.Getenv("DB_PATH")
+	if len(dbPath) == 0 {
+		dbPath = "./data/lotovn.db"
+	}
+	storage, err := pkg.NewBoltStorage(dbPath)
+	if err != nil {
+		log.Fatalf("open storage %s error: %s", dbPath, err.Error())
+	}
+	defer storage.Close()
+
+	rhymesPath := os.Getenv("RHYMES_PATH")
+	if len(rhymesPath) == 0 {
+		rhymesPath = "./config/rhymes.json"
+	}
+	rhymes, err := pkg.LoadRhymeCatalog(rhymesPath)
+	if err != nil {
+		log.Errorf("load rhymes %s error: %s, numbers are called without rhymes", rhymesPath, err.Error())
+	}
+
+	var voices *pkg.VoicePack
+	if voiceDir := os.Getenv("VOICE_PACK_DIR"); len(voiceDir) > 0 {
+		voices, err = pkg.LoadVoicePack(voiceDir)
+		if err != nil {
+			log.Fatalf("load voice pack %s error: %s", voiceDir, err.Error())
+		}
+		log.Infof("Loaded voice pack %s", voices.Name)
+	}
+
+	// TEMPLATES_DIR overrides the embedded templates, edits are picked up
+	// without a restart
+	templates, err := pkg.LoadTemplates(os.Getenv("TEMPLATES_DIR"))
+	if err != nil {
+		log.Fatalf("load templates error: %s", err.Error())
+	}
+	quit := make(chan struct{})
+	defer close(quit)
+	go templates.Watch(pkg.TEMPLATE_WATCH_INTERVAL, quit)
+
+	// handler := pkg.NewHandler(bot, pkg.GetSheet())
+	// every request to Telegram goes through the rate limited queues
+	dispatcher := pkg.NewDispatcher(bot, pkg.DefaultRateLimits())
+	defer dispatcher.Close()
+
+	handler := pkg.NewHandler(dispatcher, storage, rhymes, voices, templates)
+	if err := handler.Restore(); err != nil {
+		log.Errorf("restore games error: %s", err.Error())
+	}
+	go handler.RunSchedules(pkg.SCHEDULE_CHECK_INTERVAL, quit)
+
+	// METRICS_ADDR serves the queue stats in both modes on a listener which
+	// should stay private, e.g. 127.0.0.1:9090; the webhook also serves them
+	// under its secret path
+	if metricsAddr := os.Getenv("METRICS_ADDR"); len(metricsAddr) > 0 {
+		go serveMetrics(metricsAddr, dispatcher)
+	}
+
+	if webhookURL := os.Getenv("WEBHOOK_URL"); len(webhookURL) > 0 {
+		runWebhook(bot, dispatcher, handler, webhookURL)
+	} else {
+		runPolling(bot, handler)
+	}
+}
+
+func serveMetrics(addr string, dispatcher *pkg.Dispatcher) {
+	mux := http.NewServeMux()
+	mux.Handle(pkg.QUEUE_PATH, dispatcher)
+
+	log.Infof("Queue stats on %s%s", addr, pkg.QUEUE_PATH)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: pkg.WEBHOOK_READ_LIMIT}
+	if err := server.ListenAndServe(); err != nil {
+		log.Errorf("metrics server error: %s", err.Error())
+	}
+}
+
+func runPolling(bot *tgbotapi.BotAPI, handler pkg.Handler) {
+	// Telegram refuses getUpdates while a webhook is registered, so drop any
+	// webhook left over from a previous deployment.
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		log.Errorf("delete webhook error: %s", err.Error())
+	}
+
 	// Create a new UpdateConfig struct with an offset of 0. Offsets are used
 	// to make sure Telegram knows we've handled previous values and we don't
 	// need them repeated.
@@ -34,20 +119,54 @@ func main() {
 	// Start polling Telegram for updates.
 	updates := bot.GetUpdatesChan(u)
 
-	// handler := pkg.NewHandler(bot, pkg.GetSheet())
-	handler := pkg.NewHandler(bot)
-
+	// the chats are handled concurrently, the updates of a chat in order
+	queue := pkg.NewUpdateQueue(handler)
 	for update := range updates {
-		if update.Message != nil { // If we got a message
-			log.Infof("[%s] %s", update.Message.From.UserName, update.Message.Text)
-
-			if update.Message.IsCommand() {
-				handler.Command(&update)
-			} else {
-				handler.Keyboard(&update)
-			}
-		} else if update.CallbackQuery != nil {
-			handler.InlineKeyboard(&update)
+		update := update
+		queue.Push(&update)
+	}
+}
+
+// runWebhook serves updates over HTTP. WEBHOOK_URL is the public base URL
+// (usually the reverse proxy), the secret path is appended to it.
+func runWebhook(bot *tgbotapi.BotAPI, dispatcher *pkg.Dispatcher, handler pkg.Handler, webhookURL string) {
+	config := pkg.WebhookConfig{
+		ListenAddr: os.Getenv("LISTEN_ADDR"),
+		Secret:     os.Getenv("WEBHOOK_SECRET"),
+		CertFile:   os.Getenv("TLS_CERT_FILE"),
+		KeyFile:    os.Getenv("TLS_KEY_FILE"),
+	}
+	if len(config.ListenAddr) == 0 {
+		config.ListenAddr = ":8080"
+	}
+
+	server, err := pkg.NewWebhookServer(handler, config)
+	if err != nil {
+		log.Fatalf("webhook config error: %s", err.Error())
+	}
+	server.Handle(pkg.QUEUE_PATH, dispatcher)
+
+	webhook, err := tgbotapi.NewWebhook(webhookURL + config.Path())
+	if err != nil {
+		log.Fatalf("webhook url %s error: %s", webhookURL, err.Error())
+	}
+	if _, err := bot.Request(webhook); err != nil {
+		log.Fatalf("set webhook error: %s", err.Error())
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Errorf("shutdown webhook error: %s", err.Error())
 		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil {
+		log.Errorf("webhook server error: %s", err.Error())
 	}
 }
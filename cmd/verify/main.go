@@ -0,0 +1,55 @@
+// Command verify checks a lô tô draw without the bot: the revealed seed
+// must hash to the commitment published when the lobby opened, and the
+// called numbers must follow the order derived from the seed.
+//
+//	go run ./cmd/verify -commitment <hex> -seed <hex> [-max 90] [-draws 5,17,88]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ted-vo/lotovn-telegram-bot/pkg"
+)
+
+func main() {
+	commitment := flag.String("commitment", "", "SHA-256 commitment published in the lobby message")
+	seedText := flag.String("seed", "", "draw seed revealed when the game finished")
+	maxNumber := flag.Int("max", pkg.CLASSIC_NUMBER_SPACE, "highest number of the game")
+	drawsText := flag.String("draws", "", "called numbers in order, comma separated")
+	flag.Parse()
+
+	seed, err := pkg.ParseDrawSeed(*seedText)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	var draws []int
+	for _, field := range strings.FieldsFunc(*drawsText, func(r rune) bool { return r == ',' || r == ' ' }) {
+		number, err := strconv.Atoi(field)
+		if err != nil {
+			fail(fmt.Sprintf("draw %q is not a number", field))
+		}
+		draws = append(draws, number)
+	}
+
+	space := pkg.NumberSpace{Max: *maxNumber}
+	fmt.Printf("commitment: %s\n", pkg.Commitment(seed))
+	fmt.Printf("draw order: %s\n", strings.Trim(fmt.Sprint(pkg.DrawOrder(seed, space)), "[]"))
+
+	if len(*commitment) == 0 {
+		return
+	}
+	if err := pkg.VerifyDraw(strings.ToLower(*commitment), seed, space, draws); err != nil {
+		fail(err.Error())
+	}
+	fmt.Printf("OK: the seed matches the commitment and %d draws follow its order\n", len(draws))
+}
+
+func fail(message string) {
+	fmt.Fprintln(os.Stderr, message)
+	os.Exit(1)
+}
@@ -0,0 +1,15 @@
+// Package config embeds the files the bot ships with, so the binary runs
+// from any working directory.
+package config
+
+import "embed"
+
+// Locales holds the message catalogs, one <language>.json per locale.
+//
+//go:embed locales/*.json
+var Locales embed.FS
+
+// Templates holds the Telegram HTML templates of the messages.
+//
+//go:embed *.html
+var Templates embed.FS
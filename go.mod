@@ -7,6 +7,7 @@ require (
 	github.com/aquasecurity/table v1.8.0
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/google/uuid v1.1.1
+	go.etcd.io/bbolt v1.3.7
 	gopkg.in/Iwark/spreadsheet.v2 v2.0.0-20220412131121-41eea1483964
 )
 
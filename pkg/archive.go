@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GameArchive is what is left of a game once it is finished.
+type GameArchive struct {
+	ChatId     int64
+	GameId     int
+	FinishedAt time.Time
+	// Draws is the draw order of the numbers
+	Draws []int
+	// MaxNumber, Commitment and DrawSeed let anyone verify the draw
+	MaxNumber  int
+	Commitment string
+	DrawSeed   string
+	Players    []ArchivedPlayer
+	// Winners are the ids of the validated winners in the order of their
+	// claims
+	Winners []int64
+}
+
+type ArchivedPlayer struct {
+	Id       int64
+	Username string
+	// Tickets is how many tickets the player had, Boards are the tickets
+	// themselves; archives written before the boards were kept only have
+	// the count
+	Tickets    int
+	Boards     []ArchivedTicket
+	Wait       int
+	FalseBingo int
+	Won        bool
+	// WonAt is how many numbers were drawn when the Kinh was validated
+	WonAt int
+}
+
+// ArchivedTicket is a ticket of a finished game with the numbers the
+// player daubed, so the game can be replayed and the Kinh checked.
+type ArchivedTicket struct {
+	Id     uuid.UUID
+	Board  [][]int
+	Daubed []int
+}
+
+func (lobby *Lobby) archive(finishedAt time.Time) GameArchive {
+	archive := GameArchive{
+		ChatId:     lobby.ChatId,
+		GameId:     lobby.GameId,
+		FinishedAt: finishedAt,
+		Draws:      lobby.lifecycle.result(),
+		MaxNumber:  lobby.lifecycle.ticketConfig().MaxNumer,
+		Commitment: lobby.lifecycle.commitment(),
+		DrawSeed:   hex.EncodeToString(lobby.lifecycle.drawSeed()),
+	}
+
+	for _, player := range lobby.players {
+		archived := ArchivedPlayer{
+			Id:         player.Id,
+			Username:   player.Username,
+			Tickets:    len(player.Tickets),
+			Wait:       player.Wait,
+			FalseBingo: player.FalseBingo,
+			Won:        lobby.isWinner(player),
+			WonAt:      player.WonAt,
+		}
+		for _, ticket := range player.Tickets {
+			record := ticket.record()
+			archived.Boards = append(archived.Boards, ArchivedTicket{Id: record.Id, Board: record.Board, Daubed: record.Daubed})
+		}
+		archive.Players = append(archive.Players, archived)
+	}
+	// map order is random, keep the archive stable
+	sort.Slice(archive.Players, func(i, j int) bool {
+		return archive.Players[i].Id < archive.Players[j].Id
+	})
+	for _, winner := range lobby.winners {
+		archive.Winners = append(archive.Winners, winner.Id)
+	}
+
+	return archive
+}
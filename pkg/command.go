@@ -12,22 +12,114 @@ func (handler *MessageHandler) Command(update *tgbotapi.Update) error {
 	// Create a new MessageConfig. We don't have text yet,
 	// so we leave it empty.
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
+	// answers are read by the whole chat, the private chat speaks the
+	// language of the user
+	language := handler.chatLanguage(update.Message.Chat.ID)
+	if update.Message.Chat.IsPrivate() {
+		language = handler.userLanguage(update.Message.From, update.Message.Chat.ID)
+	}
 
 	switch update.Message.Command() {
 	case CMD_OPEN_MENU:
-		msg.Text = " 📜 Menu đã được thêm vào"
+		msg.Text = T(language, "menu.opened")
 		if update.Message.Chat.IsPrivate() {
-			msg.ReplyMarkup = PrivateKeyboard
+			msg.ReplyMarkup = privateKeyboard(language)
 		} else {
-			msg.ReplyMarkup = LobbyKeyboard
+			msg.ReplyMarkup = lobbyKeyboard(language)
+		}
+	case CMD_NEW_GAME:
+		settings, err := ParseGameSettings(update.Message.CommandArguments())
+		if err != nil {
+			msg.Text = T(language, "settings.usage", errorMessage(language, err), CMD_NEW_GAME)
+			break
+		}
+		return handler.newGame(update, settings)
+	case CMD_BOARD:
+		if err := handler.board(update); err != nil {
+			msg.Text = errorMessage(language, err)
+		}
+	case CMD_HOST:
+		if err := handler.handOver(update); err != nil {
+			msg.Text = errorMessage(language, err)
+		}
+	case CMD_BALANCE:
+		text, err := handler.balance(update)
+		if err != nil {
+			text = errorMessage(language, err)
+		}
+		msg.Text = text
+		msg.ParseMode = HTML
+		msg.ReplyToMessageID = update.Message.MessageID
+	case CMD_TOPUP:
+		text, err := handler.topup(update)
+		if err != nil {
+			text = errorMessage(language, err)
+		}
+		msg.Text = text
+		msg.ReplyToMessageID = update.Message.MessageID
+	case CMD_STATS:
+		text, err := handler.stats(update)
+		if err != nil {
+			text = errorMessage(language, err)
+		}
+		msg.Text = text
+		msg.ParseMode = HTML
+	case CMD_TOP:
+		text, err := handler.top(update)
+		if err != nil {
+			text = errorMessage(language, err)
+		}
+		msg.Text = text
+		msg.ParseMode = HTML
+	case CMD_VERIFY:
+		text, err := handler.verify(update)
+		if err != nil {
+			text = errorMessage(language, err)
+		}
+		msg.Text = text
+		msg.ReplyToMessageID = update.Message.MessageID
+	case CMD_ADD_RHYME:
+		text, err := handler.addRhyme(update)
+		if err != nil {
+			text = errorMessage(language, err)
+		}
+		msg.Text = text
+		msg.ReplyToMessageID = update.Message.MessageID
+	case CMD_LANG:
+		text, err := handler.setLanguage(update)
+		if err != nil {
+			text = errorMessage(language, err)
+		}
+		msg.Text = text
+		msg.ParseMode = HTML
+		msg.ReplyToMessageID = update.Message.MessageID
+	case CMD_REPLAY:
+		if err := handler.replay(update); err != nil {
+			msg.Text = errorMessage(language, err)
+			msg.ReplyToMessageID = update.Message.MessageID
+		}
+	case CMD_SCHEDULE:
+		text, err := handler.schedule(update)
+		if err != nil {
+			text = errorMessage(language, err)
+		}
+		msg.Text = text
+		msg.ReplyToMessageID = update.Message.MessageID
+	case CMD_SCHEDULES:
+		text, keyboard, err := handler.listSchedules(update.Message.Chat.ID)
+		if err != nil {
+			text = errorMessage(language, err)
+		} else if keyboard != nil {
+			msg.ReplyMarkup = keyboard
 		}
+		msg.Text = text
 	case CMD_CLOSE_MENU:
-		msg.Text = " ❌  Loại bỏ Menu"
+		msg.Text = T(language, "menu.closed")
 		msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
 
 		handler.removeMessage(update.Message.Chat.ID, update.Message.MessageID)
 	default:
-		msg.Text = "Tạm thời em không hiểu. Để em cập nhật thêm sau nhé!"
+		msg.Text = T(language, "command.unknown")
 	}
 
 	handler.sendMessage(msg)
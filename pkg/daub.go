@@ -0,0 +1,250 @@
+package pkg
+
+import (
+	"time"
+
+	"github.com/apex/log"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// DAUB_MANUAL leaves the daubing to the player, DAUB_HYBRID highlights
+	// the called numbers which still need a tap and DAUB_AUTO daubs them.
+	DAUB_MANUAL = "manual"
+	DAUB_HYBRID = "hybrid"
+	DAUB_AUTO   = "auto"
+
+	// The refreshed tickets of a lobby are edited every DAUB_FLUSH_INTERVAL,
+	// at most MAX_DAUB_EDITS at a time so a release never floods the Bot
+	// API with one edit per player.
+	DAUB_FLUSH_INTERVAL = time.Second
+	MAX_DAUB_EDITS      = 20
+)
+
+var daubModes = []string{DAUB_MANUAL, DAUB_HYBRID, DAUB_AUTO}
+
+// TicketCells tells how the numbers of a ticket are shown on its keyboard.
+type TicketCells struct {
+	Board  [][]int
+	Daubed map[int]bool
+	// Callable are the called numbers the player has not daubed yet
+	Callable map[int]bool
+}
+
+// ticketRef points to a ticket of a player by its index, which is what the
+// callback data of the ticket keyboard uses.
+type ticketRef struct {
+	player *Player
+	index  int
+}
+
+func daubModeLabel(language string, mode string) string {
+	switch mode {
+	case DAUB_HYBRID:
+		return T(language, "daub.hybrid")
+	case DAUB_AUTO:
+		return T(language, "daub.auto")
+	default:
+		return T(language, "daub.manual")
+	}
+}
+
+func nextDaubMode(mode string) string {
+	for i, m := range daubModes {
+		if m == mode {
+			return daubModes[(i+1)%len(daubModes)]
+		}
+	}
+	return DAUB_MANUAL
+}
+
+func (ticket *Ticket) has(number int) bool {
+	ticket.lock.RLock()
+	defer ticket.lock.RUnlock()
+
+	for _, row := range ticket.board {
+		for _, v := range row {
+			if v == number {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// toggleDaub daubs the number or removes its daub and tells if it is
+// daubed now.
+func (ticket *Ticket) toggleDaub(number int) bool {
+	ticket.lock.Lock()
+	defer ticket.lock.Unlock()
+
+	if ticket.daubed == nil {
+		ticket.daubed = make(map[int]bool)
+	}
+	if ticket.daubed[number] {
+		delete(ticket.daubed, number)
+		return false
+	}
+	ticket.daubed[number] = true
+	return true
+}
+
+// daubAll daubs the given numbers which are on the ticket.
+func (ticket *Ticket) daubAll(numbers []int) {
+	ticket.lock.Lock()
+	defer ticket.lock.Unlock()
+
+	onTicket := map[int]bool{}
+	for _, row := range ticket.board {
+		for _, v := range row {
+			if v > 0 {
+				onTicket[v] = true
+			}
+		}
+	}
+	if ticket.daubed == nil {
+		ticket.daubed = make(map[int]bool)
+	}
+	for _, number := range numbers {
+		if onTicket[number] {
+			ticket.daubed[number] = true
+		}
+	}
+}
+
+func (ticket *Ticket) cells(result []int, mode string) TicketCells {
+	ticket.lock.RLock()
+	defer ticket.lock.RUnlock()
+
+	cells := TicketCells{Daubed: make(map[int]bool), Callable: make(map[int]bool)}
+	for _, row := range ticket.board {
+		cells.Board = append(cells.Board, append([]int{}, row...))
+	}
+	for number := range ticket.daubed {
+		cells.Daubed[number] = true
+	}
+	if mode == DAUB_HYBRID {
+		for _, number := range result {
+			if !cells.Daubed[number] {
+				cells.Callable[number] = true
+			}
+		}
+	}
+
+	return cells
+}
+
+// queueRefresh asks for the keyboard of the ticket to be edited with the
+// next flush. A ticket is only queued once however many numbers hit it.
+func (lobby *Lobby) queueRefresh(player *Player, index int) {
+	for _, ref := range lobby.refresh {
+		if ref.player == player && ref.index == index {
+			return
+		}
+	}
+	lobby.refresh = append(lobby.refresh, ticketRef{player: player, index: index})
+}
+
+// daubReleased marks the released number on the tickets of the players who
+// do not daub by hand and queues the tickets to be refreshed.
+func (handler *MessageHandler) daubReleased(game *Lobby, number int) {
+	for _, player := range game.players {
+		if player.Daub != DAUB_AUTO && player.Daub != DAUB_HYBRID {
+			continue
+		}
+		for i, ticket := range player.Tickets {
+			if !ticket.has(number) {
+				continue
+			}
+			if player.Daub == DAUB_AUTO {
+				ticket.daubAll([]int{number})
+			}
+			game.queueRefresh(player, i)
+		}
+	}
+}
+
+// flushTicketRefresh edits the queued tickets of the lobby, the ones above
+// MAX_DAUB_EDITS wait for the next flush.
+func (handler *MessageHandler) flushTicketRefresh(game *Lobby) {
+	game.lock.Lock()
+	defer game.lock.Unlock()
+
+	if game.lifecycle.status() == STOPPED {
+		game.refresh = nil
+		return
+	}
+
+	batch := game.refresh
+	if len(batch) > MAX_DAUB_EDITS {
+		batch = batch[:MAX_DAUB_EDITS]
+	}
+	game.refresh = append([]ticketRef{}, game.refresh[len(batch):]...)
+
+	for _, ref := range batch {
+		handler.editTicket(game, ref.player, ref.index)
+	}
+}
+
+// editTicket renders the keyboard of the ticket again.
+func (handler *MessageHandler) editTicket(game *Lobby, player *Player, index int) {
+	ticket := player.Tickets[index]
+	if ticket.MessageId == 0 {
+		// still on its way to the player
+		return
+	}
+	language := handler.playerLanguage(player, game.ChatId)
+	text, err := handler.ticketText(language, ticket, index)
+	if err != nil {
+		log.Errorf("render ticket %d of %d error: %s", index, player.Id, err.Error())
+		return
+	}
+	editMsg := tgbotapi.NewEditMessageTextAndMarkup(
+		player.Id,
+		ticket.MessageId,
+		text,
+		GenerateTicketKeyboard(language, game.ChatId, game.GameId, index, player.Daub, ticket.cells(game.lifecycle.result(), player.Daub)),
+	)
+	editMsg.ParseMode = HTML
+
+	handler.editMessage(editMsg)
+}
+
+// toggleDaubMode switches the daub mode of the player, for all tickets.
+// Turning auto on daubs the numbers called so far right away. Before the
+// draw no flush runs, every ticket is edited at once.
+func (handler *MessageHandler) toggleDaubMode(update *tgbotapi.Update) error {
+	query, err := parseTicketQuery(update.CallbackQuery.Data)
+	if err != nil {
+		return err
+	}
+
+	currentGame, err := handler.lockTicketGame(query)
+	if err != nil {
+		return err
+	}
+	defer currentGame.lock.Unlock()
+
+	player, _, err := currentGame.playerTicket(update.CallbackQuery.From.ID, query.Ticket)
+	if err != nil {
+		return err
+	}
+
+	player.Daub = nextDaubMode(player.Daub)
+	result := currentGame.lifecycle.result()
+	lobby := currentGame.lifecycle.status() == LOBBY
+	for i, ticket := range player.Tickets {
+		if player.Daub == DAUB_AUTO {
+			ticket.daubAll(result)
+		}
+		// the pressed ticket answers at once, the others with the next flush
+		if i == query.Ticket || lobby {
+			handler.editTicket(currentGame, player, i)
+		} else {
+			currentGame.queueRefresh(player, i)
+		}
+	}
+	handler.saveGame(currentGame)
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+// firstNumber returns the first number of the ticket and its cell.
+func firstNumber(ticket *Ticket) (int, int, int) {
+	for i, row := range ticket.board {
+		for j, v := range row {
+			if v > 0 {
+				return v, i, j
+			}
+		}
+	}
+	return 0, 0, 0
+}
+
+func TestDaubModes(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1007)
+	host := telegramtest.User(10, "lotovn_host")
+	manual := telegramtest.User(11, "player_teo")
+	auto := telegramtest.User(12, "player_ti")
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame"))
+	game := handler.lobbies.Get(group.ID)
+	Dispatch(handler, telegramtest.CallbackUpdate(group, manual, game.GameId, QUERY_DATA_REGISTER))
+	Dispatch(handler, telegramtest.CallbackUpdate(group, auto, game.GameId, QUERY_DATA_REGISTER))
+	game.lifecycle.start()
+	game.lifecycle.pause()
+	defer game.lifecycle.stop()
+
+	// tapping a number which was not called yet is rejected
+	ticket := game.players[manual.ID].Tickets[0]
+	number, x, y := firstNumber(ticket)
+	tap := fmt.Sprintf("%s;%d;%d;0;%d-%d", QUERY_DATA_CHECKED, group.ID, game.GameId, x, y)
+	Dispatch(handler, telegramtest.CallbackUpdate(telegramtest.Private(manual), manual, ticket.MessageId, tap))
+	if lastAnswer(t, server).Params.Get("show_alert") != "true" || ticket.cells(nil, DAUB_MANUAL).Daubed[number] {
+		t.Fatal("a number which was not called got daubed")
+	}
+
+	// switch the second player to auto: manual -> hybrid -> auto
+	toggle := fmt.Sprintf("%s;%d;%d;0", QUERY_DATA_DAUB, group.ID, game.GameId)
+	for i := 0; i < 2; i++ {
+		Dispatch(handler, telegramtest.CallbackUpdate(telegramtest.Private(auto), auto, 1, toggle))
+	}
+	if mode := game.players[auto.ID].Daub; mode != DAUB_AUTO {
+		t.Fatalf("expected auto daub, got %s", mode)
+	}
+
+	// releases only daub the auto tickets and are not edited right away
+	autoTicket := game.players[auto.ID].Tickets[0]
+	autoNumber, _, _ := firstNumber(autoTicket)
+	server.Reset()
+	for _, v := range []int{number, autoNumber} {
+		game.lock.Lock()
+		game.lifecycle.(*Game).resultSeed.push(v)
+		handler.daubReleased(game, v)
+		game.lock.Unlock()
+	}
+	if !autoTicket.cells(nil, DAUB_AUTO).Daubed[autoNumber] {
+		t.Fatal("auto ticket was not daubed")
+	}
+	if ticket.cells(nil, DAUB_MANUAL).Daubed[number] {
+		t.Fatal("manual ticket was daubed")
+	}
+	if edits := len(server.Requests("editMessageText")); edits != 0 {
+		t.Fatalf("expected the refresh to wait for the flush, got %d edits", edits)
+	}
+
+	// the manual player can daub the called number now
+	Dispatch(handler, telegramtest.CallbackUpdate(telegramtest.Private(manual), manual, ticket.MessageId, tap))
+	if !ticket.cells(nil, DAUB_MANUAL).Daubed[number] {
+		t.Fatal("called number could not be daubed")
+	}
+}
+
+func TestDaubModeInLobby(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1065)
+	host := telegramtest.User(10, "lotovn_host")
+	player := telegramtest.User(11, "player_teo")
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame tickets=3"))
+	game := handler.lobbies.Get(group.ID)
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	for i := 0; i < 2; i++ {
+		Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_BUY))
+	}
+	if len(game.players[player.ID].Tickets) != 3 {
+		t.Fatal("player could not buy 3 tickets")
+	}
+
+	// no flush runs before the draw, every ticket shows the new mode at once
+	server.Reset()
+	toggle := fmt.Sprintf("%s;%d;%d;1", QUERY_DATA_DAUB, group.ID, game.GameId)
+	Dispatch(handler, telegramtest.CallbackUpdate(telegramtest.Private(player), player, 1, toggle))
+	edited := map[int]bool{}
+	for _, edit := range server.Requests("editMessageText") {
+		edited[edit.MessageId()] = true
+	}
+	for i, ticket := range game.players[player.ID].Tickets {
+		if !edited[ticket.MessageId] {
+			t.Fatalf("ticket %d was not edited", i+1)
+		}
+	}
+	if len(game.refresh) != 0 {
+		t.Fatalf("expected nothing left for the flush, got %d tickets", len(game.refresh))
+	}
+}
+
+func TestHybridDaubKeyboard(t *testing.T) {
+	ticket := &Ticket{board: [][]int{{1, 0, 23}, {0, 15, 0}}}
+	ticket.daubAll([]int{23})
+
+	keyboard := GenerateTicketKeyboard(DEFAULT_LANGUAGE, -100, 1, 0, DAUB_HYBRID, ticket.cells([]int{1, 15, 23, 40}, DAUB_HYBRID))
+	var labels []string
+	for _, row := range keyboard.InlineKeyboard[:2] {
+		for _, button := range row {
+			labels = append(labels, button.Text)
+		}
+	}
+	if got := strings.Join(labels, "|"); got != "🔔1| |✅| |🔔15| " {
+		t.Fatalf("unexpected ticket labels %q", got)
+	}
+	if data := *keyboard.InlineKeyboard[0][1].CallbackData; data != " " {
+		t.Fatalf("empty cell can be tapped: %q", data)
+	}
+}
+
+func TestTicketRefreshIsBatched(t *testing.T) {
+	handler, server := newTestHandler(t)
+	game := &Lobby{ChatId: -1008, GameId: 1, players: map[int64]*Player{}, lifecycle: NewGame(MIN_INTERVAL, DefaultGameSettings().Ticket)}
+
+	for i := 0; i < MAX_DAUB_EDITS+5; i++ {
+		ticket := NewTicket(1, DefaultGameSettings().Ticket)
+		ticket.MessageId = 1000 + i
+		player := &Player{Id: int64(100 + i), Daub: DAUB_AUTO, Tickets: []*Ticket{ticket}}
+		game.players[player.Id] = player
+		// several numbers on the same ticket only refresh it once
+		game.queueRefresh(player, 0)
+		game.queueRefresh(player, 0)
+	}
+
+	handler.flushTicketRefresh(game)
+	if edits := len(server.Requests("editMessageText")); edits != MAX_DAUB_EDITS {
+		t.Fatalf("expected %d edits in the first flush, got %d", MAX_DAUB_EDITS, edits)
+	}
+	handler.flushTicketRefresh(game)
+	if edits := len(server.Requests("editMessageText")); edits != MAX_DAUB_EDITS+5 {
+		t.Fatalf("expected the rest in the second flush, got %d edits", edits)
+	}
+	handler.flushTicketRefresh(game)
+	if edits := len(server.Requests("editMessageText")); edits != MAX_DAUB_EDITS+5 {
+		t.Fatal("a flushed ticket was edited again")
+	}
+}
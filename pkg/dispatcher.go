@@ -0,0 +1,484 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	QUEUE_PATH = "/queue"
+
+	// MAX_SEND_RETRIES is how many times a request answered with 429 Too
+	// Many Requests is tried again after its retry_after.
+	MAX_SEND_RETRIES = 3
+	// QUEUE_WARN_DEPTH logs a warning once the queue grows that long.
+	QUEUE_WARN_DEPTH = 100
+	// MAX_CALLBACK_RETRY_AFTER is the longest a callback answer waits for a
+	// retry, Telegram drops answers which come too late anyway.
+	MAX_CALLBACK_RETRY_AFTER = 5 * time.Second
+)
+
+var ErrDispatcherClosed = errors.New("dispatcher closed")
+
+// Rate allows Count requests in any window of Per.
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+// RateLimits are the limits of the Bot API: every chat takes a message per
+// second, groups 20 per minute and the bot 30 per second overall.
+type RateLimits struct {
+	Global Rate
+	Chat   Rate
+	Group  Rate
+}
+
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		Global: Rate{Count: 30, Per: time.Second},
+		Chat:   Rate{Count: 1, Per: time.Second},
+		Group:  Rate{Count: 20, Per: time.Minute},
+	}
+}
+
+// Outbox is implemented by bot clients which can queue a request without
+// waiting for it. Errors of queued requests are only logged.
+type Outbox interface {
+	Enqueue(c tgbotapi.Chattable)
+	// EnqueueThen queues the request too and hands its result to done, on
+	// a goroutine of its own.
+	EnqueueThen(c tgbotapi.Chattable, done func(tgbotapi.Message, error))
+}
+
+// window is a sliding window log of the requests sent under a Rate.
+type window struct {
+	rate Rate
+	sent []time.Time
+}
+
+// wait tells how long to wait before the next request fits in the window.
+func (w *window) wait(now time.Time) time.Duration {
+	for len(w.sent) > 0 && !w.sent[0].Add(w.rate.Per).After(now) {
+		w.sent = w.sent[1:]
+	}
+	if w.rate.Count <= 0 || len(w.sent) < w.rate.Count {
+		return 0
+	}
+	return w.sent[0].Add(w.rate.Per).Sub(now)
+}
+
+func (w *window) record(now time.Time) {
+	w.sent = append(w.sent, now)
+}
+
+type sendResult struct {
+	message tgbotapi.Message
+	err     error
+}
+
+// sendJob is a queued request. Edits of the same message share a job, the
+// latest edit replaces the request and every caller gets its result.
+type sendJob struct {
+	request tgbotapi.Chattable
+	edit    editKey
+	retries int
+	waiters []chan sendResult
+}
+
+type editKey struct {
+	chatId    int64
+	messageId int
+}
+
+type chatQueue struct {
+	chatId  int64
+	jobs    []*sendJob
+	windows []*window
+	// blockedUntil is set by the retry_after of a 429 answer
+	blockedUntil time.Time
+}
+
+// Dispatcher sends the requests of the bot through per chat queues so the
+// Bot API limits are never exceeded. It is a BotClient itself: Send waits
+// for its turn and the result, Enqueue returns at once.
+type Dispatcher struct {
+	bot    BotClient
+	limits RateLimits
+	global *window
+	chats  map[int64]*chatQueue
+	// order keeps the chats with queued requests, first come first served
+	order  []int64
+	depth  int
+	warned bool
+	closed bool
+
+	wake chan bool
+	quit chan bool
+	lock sync.Mutex
+}
+
+func NewDispatcher(bot BotClient, limits RateLimits) *Dispatcher {
+	dispatcher := &Dispatcher{
+		bot:    bot,
+		limits: limits,
+		global: &window{rate: limits.Global},
+		chats:  make(map[int64]*chatQueue),
+		wake:   make(chan bool, 1),
+		quit:   make(chan bool),
+	}
+	go dispatcher.run()
+
+	return dispatcher
+}
+
+func (dispatcher *Dispatcher) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	done := make(chan sendResult, 1)
+	if err := dispatcher.push(c, done); err != nil {
+		return tgbotapi.Message{}, err
+	}
+	result := <-done
+
+	return result.message, result.err
+}
+
+func (dispatcher *Dispatcher) Enqueue(c tgbotapi.Chattable) {
+	if err := dispatcher.push(c, nil); err != nil {
+		log.Errorf("enqueue request error: %s", err.Error())
+	}
+}
+
+func (dispatcher *Dispatcher) EnqueueThen(c tgbotapi.Chattable, done func(tgbotapi.Message, error)) {
+	result := make(chan sendResult, 1)
+	if err := dispatcher.push(c, result); err != nil {
+		go done(tgbotapi.Message{}, err)
+		return
+	}
+	go func() {
+		sent := <-result
+		done(sent.message, sent.err)
+	}()
+}
+
+// Request passes requests which are not chat messages, e.g. callback
+// answers, straight to the bot. A short retry_after is waited for once.
+func (dispatcher *Dispatcher) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	resp, err := dispatcher.bot.Request(c)
+	if retryAfter, ok := retryAfter(err); ok && retryAfter <= MAX_CALLBACK_RETRY_AFTER {
+		time.Sleep(retryAfter)
+		return dispatcher.bot.Request(c)
+	}
+
+	return resp, err
+}
+
+// Depth is the number of queued requests.
+func (dispatcher *Dispatcher) Depth() int {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	return dispatcher.depth
+}
+
+// ChatDepth is the number of queued requests of the chat.
+func (dispatcher *Dispatcher) ChatDepth(chatId int64) int {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	if queue := dispatcher.chats[chatId]; queue != nil {
+		return len(queue.jobs)
+	}
+	return 0
+}
+
+// ServeHTTP reports the queue depth as JSON, for monitoring.
+func (dispatcher *Dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dispatcher.lock.Lock()
+	stats := struct {
+		Depth int `json:"depth"`
+		Chats int `json:"chats"`
+	}{
+		Depth: dispatcher.depth,
+		Chats: len(dispatcher.order),
+	}
+	dispatcher.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// Close stops the dispatcher, queued requests fail with
+// ErrDispatcherClosed.
+func (dispatcher *Dispatcher) Close() {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	if dispatcher.closed {
+		return
+	}
+	dispatcher.closed = true
+	close(dispatcher.quit)
+
+	for _, queue := range dispatcher.chats {
+		for _, job := range queue.jobs {
+			job.finish(tgbotapi.Message{}, ErrDispatcherClosed)
+		}
+	}
+	dispatcher.chats = make(map[int64]*chatQueue)
+	dispatcher.order = nil
+	dispatcher.depth = 0
+}
+
+func (dispatcher *Dispatcher) push(c tgbotapi.Chattable, done chan sendResult) error {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	if dispatcher.closed {
+		return ErrDispatcherClosed
+	}
+
+	chatId, edit := requestTarget(c)
+	queue := dispatcher.chats[chatId]
+	if queue == nil {
+		queue = dispatcher.newChatQueue(chatId)
+		dispatcher.chats[chatId] = queue
+	}
+	if len(queue.jobs) == 0 {
+		dispatcher.order = append(dispatcher.order, chatId)
+	}
+
+	job := queue.pendingEdit(c, edit)
+	if job == nil {
+		job = &sendJob{request: c, edit: edit}
+		queue.jobs = append(queue.jobs, job)
+		dispatcher.depth++
+	} else {
+		// only the latest edit of a message matters
+		job.request = c
+	}
+	if done != nil {
+		job.waiters = append(job.waiters, done)
+	}
+
+	if dispatcher.depth >= QUEUE_WARN_DEPTH && !dispatcher.warned {
+		log.Warnf("outbound queue has %d requests", dispatcher.depth)
+		dispatcher.warned = true
+	} else if dispatcher.depth < QUEUE_WARN_DEPTH/2 {
+		dispatcher.warned = false
+	}
+
+	select {
+	case dispatcher.wake <- true:
+	default:
+	}
+
+	return nil
+}
+
+func (dispatcher *Dispatcher) newChatQueue(chatId int64) *chatQueue {
+	queue := &chatQueue{
+		chatId:  chatId,
+		windows: []*window{{rate: dispatcher.limits.Chat}},
+	}
+	// group and channel ids are negative
+	if chatId < 0 {
+		queue.windows = append(queue.windows, &window{rate: dispatcher.limits.Group})
+	}
+
+	return queue
+}
+
+// pendingEdit finds the queued edit of the same message with the same kind
+// of request.
+func (queue *chatQueue) pendingEdit(c tgbotapi.Chattable, edit editKey) *sendJob {
+	if edit.messageId == 0 {
+		return nil
+	}
+	for _, job := range queue.jobs {
+		if job.edit == edit && sameKind(job.request, c) {
+			return job
+		}
+	}
+	return nil
+}
+
+// wait tells how long the chat has to wait for its next request.
+func (queue *chatQueue) wait(now time.Time) time.Duration {
+	wait := queue.blockedUntil.Sub(now)
+	for _, w := range queue.windows {
+		if d := w.wait(now); d > wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// run sends the queued requests in turn. A chat which has to wait does not
+// hold back the other chats.
+func (dispatcher *Dispatcher) run() {
+	for {
+		job, queue, wait := dispatcher.next()
+		if job == nil {
+			timer := time.NewTimer(wait)
+			select {
+			case <-dispatcher.quit:
+				timer.Stop()
+				return
+			case <-dispatcher.wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+
+		message, err := dispatcher.send(job.request)
+		dispatcher.done(job, queue, message, err)
+	}
+}
+
+// send posts the request, deletions are answered with true instead of a
+// message.
+func (dispatcher *Dispatcher) send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if _, ok := c.(tgbotapi.DeleteMessageConfig); ok {
+		_, err := dispatcher.bot.Request(c)
+		return tgbotapi.Message{}, err
+	}
+
+	return dispatcher.bot.Send(c)
+}
+
+// next takes the first request which may be sent now, otherwise it tells
+// how long to wait for one.
+func (dispatcher *Dispatcher) next() (*sendJob, *chatQueue, time.Duration) {
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	// an idle dispatcher sleeps until something is queued
+	wait := time.Hour
+	now := time.Now()
+	if global := dispatcher.global.wait(now); global > 0 {
+		return nil, nil, global
+	}
+
+	for i, chatId := range dispatcher.order {
+		queue := dispatcher.chats[chatId]
+		if d := queue.wait(now); d > 0 {
+			if d < wait {
+				wait = d
+			}
+			continue
+		}
+
+		job := queue.jobs[0]
+		queue.jobs = queue.jobs[1:]
+		dispatcher.depth--
+		// the chat goes to the back of the line
+		dispatcher.order = append(dispatcher.order[:i:i], dispatcher.order[i+1:]...)
+		if len(queue.jobs) > 0 {
+			dispatcher.order = append(dispatcher.order, chatId)
+		}
+		dispatcher.global.record(now)
+		for _, w := range queue.windows {
+			w.record(now)
+		}
+		return job, queue, 0
+	}
+
+	return nil, nil, wait
+}
+
+// done hands out the result of a request, or queues it again in front of
+// its chat when Telegram asked to retry later.
+func (dispatcher *Dispatcher) done(job *sendJob, queue *chatQueue, message tgbotapi.Message, err error) {
+	retryAfter, limited := retryAfter(err)
+	if !limited || job.retries >= MAX_SEND_RETRIES {
+		if err != nil && len(job.waiters) == 0 {
+			log.Errorf("queued request to chat %d error: %s", queue.chatId, err.Error())
+		}
+		job.finish(message, err)
+		return
+	}
+
+	dispatcher.lock.Lock()
+	defer dispatcher.lock.Unlock()
+
+	if dispatcher.closed {
+		job.finish(message, ErrDispatcherClosed)
+		return
+	}
+	log.Warnf("chat %d is rate limited, retry after %s", queue.chatId, retryAfter)
+	job.retries++
+	queue.blockedUntil = time.Now().Add(retryAfter)
+	if len(queue.jobs) == 0 {
+		dispatcher.order = append(dispatcher.order, queue.chatId)
+	}
+	if pending := queue.pendingEdit(job.request, job.edit); pending != nil {
+		// a newer edit of the message was queued meanwhile
+		pending.waiters = append(pending.waiters, job.waiters...)
+		return
+	}
+	queue.jobs = append([]*sendJob{job}, queue.jobs...)
+	dispatcher.depth++
+}
+
+func (job *sendJob) finish(message tgbotapi.Message, err error) {
+	for _, done := range job.waiters {
+		done <- sendResult{message: message, err: err}
+	}
+	job.waiters = nil
+}
+
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusTooManyRequests {
+		return time.Duration(apiErr.RetryAfter) * time.Second, true
+	}
+	return 0, false
+}
+
+// requestTarget finds the chat of a request and, for edits, the message.
+func requestTarget(c tgbotapi.Chattable) (int64, editKey) {
+	switch request := c.(type) {
+	case tgbotapi.MessageConfig:
+		return request.ChatID, editKey{}
+	case tgbotapi.PhotoConfig:
+		return request.ChatID, editKey{}
+	case tgbotapi.VoiceConfig:
+		return request.ChatID, editKey{}
+	case tgbotapi.EditMessageTextConfig:
+		return request.ChatID, editKey{chatId: request.ChatID, messageId: request.MessageID}
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		return request.ChatID, editKey{chatId: request.ChatID, messageId: request.MessageID}
+	case tgbotapi.EditMessageCaptionConfig:
+		return request.ChatID, editKey{chatId: request.ChatID, messageId: request.MessageID}
+	case tgbotapi.DeleteMessageConfig:
+		return request.ChatID, editKey{}
+	default:
+		return 0, editKey{}
+	}
+}
+
+func sameKind(a tgbotapi.Chattable, b tgbotapi.Chattable) bool {
+	switch a.(type) {
+	case tgbotapi.EditMessageTextConfig:
+		_, ok := b.(tgbotapi.EditMessageTextConfig)
+		return ok
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		_, ok := b.(tgbotapi.EditMessageReplyMarkupConfig)
+		return ok
+	case tgbotapi.EditMessageCaptionConfig:
+		_, ok := b.(tgbotapi.EditMessageCaptionConfig)
+		return ok
+	default:
+		return false
+	}
+}
@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+func newTestDispatcher(t *testing.T, limits RateLimits) (*Dispatcher, *telegramtest.Server) {
+	server := telegramtest.NewServer()
+	t.Cleanup(server.Close)
+
+	bot, err := server.Bot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := NewDispatcher(bot, limits)
+	t.Cleanup(dispatcher.Close)
+
+	return dispatcher, server
+}
+
+func TestDispatcherRateLimits(t *testing.T) {
+	dispatcher, server := newTestDispatcher(t, RateLimits{
+		Global: Rate{Count: 100, Per: time.Second},
+		Chat:   Rate{Count: 1, Per: 100 * time.Millisecond},
+		Group:  Rate{Count: 3, Per: time.Second},
+	})
+
+	var lock sync.Mutex
+	sentAt := map[int64][]time.Time{}
+	server.Handle("sendMessage", func(req telegramtest.Request) (interface{}, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		sentAt[req.ChatId()] = append(sentAt[req.ChatId()], time.Now())
+		return tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: req.ChatId()}}, nil
+	})
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		dispatcher.Enqueue(tgbotapi.NewMessage(-100, fmt.Sprintf("Số %d", i)))
+	}
+	// a busy group does not hold back other chats
+	if _, err := dispatcher.Send(tgbotapi.NewMessage(42, "Vé của bạn")); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 90*time.Millisecond {
+		t.Fatalf("private message waited %s behind the group", elapsed)
+	}
+
+	if !server.WaitFor(3*time.Second, func(requests []telegramtest.Request) bool {
+		return len(server.Requests("sendMessage")) == 5
+	}) {
+		t.Fatal("queued messages were not sent")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	group := sentAt[-100]
+	for i := 1; i < len(group); i++ {
+		if gap := group[i].Sub(group[i-1]); gap < 95*time.Millisecond {
+			t.Fatalf("messages %d and %d were sent %s apart", i-1, i, gap)
+		}
+	}
+	if gap := group[3].Sub(group[0]); gap < 990*time.Millisecond {
+		t.Fatalf("the 4th group message came %s after the 1st, within the group window", gap)
+	}
+}
+
+func TestDispatcherRetryAfter(t *testing.T) {
+	dispatcher, server := newTestDispatcher(t, DefaultRateLimits())
+
+	calls := 0
+	server.Handle("sendMessage", func(req telegramtest.Request) (interface{}, error) {
+		calls++
+		if calls == 1 {
+			return nil, &tgbotapi.Error{
+				Code:               http.StatusTooManyRequests,
+				Message:            "Too Many Requests: retry after 1",
+				ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 1},
+			}
+		}
+		return tgbotapi.Message{MessageID: 7, Chat: &tgbotapi.Chat{ID: req.ChatId()}}, nil
+	})
+
+	start := time.Now()
+	message, err := dispatcher.Send(tgbotapi.NewMessage(-100, "Kết thúc!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.MessageID != 7 || len(server.Requests("sendMessage")) != 2 {
+		t.Fatal("expected the message to be sent again")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retry_after was not honoured, retried after %s", elapsed)
+	}
+}
+
+func TestDispatcherCoalescesEdits(t *testing.T) {
+	dispatcher, server := newTestDispatcher(t, RateLimits{
+		Global: Rate{Count: 30, Per: time.Second},
+		Chat:   Rate{Count: 1, Per: 200 * time.Millisecond},
+	})
+
+	if _, err := dispatcher.Send(tgbotapi.NewMessage(42, "Vé của bạn")); err != nil {
+		t.Fatal(err)
+	}
+	// the chat has to wait now, so the edits pile up
+	for i := 1; i <= 5; i++ {
+		dispatcher.Enqueue(tgbotapi.NewEditMessageText(42, 7, fmt.Sprintf("lần %d", i)))
+	}
+	dispatcher.Enqueue(tgbotapi.NewEditMessageText(42, 8, "vé khác"))
+	if depth := dispatcher.ChatDepth(42); depth != 2 {
+		t.Fatalf("expected the edits of a message to be coalesced, queue depth %d", depth)
+	}
+
+	recorder := httptest.NewRecorder()
+	dispatcher.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, QUEUE_PATH, nil))
+	if body := recorder.Body.String(); !strings.Contains(body, `"depth":2`) {
+		t.Fatalf("unexpected queue stats %s", body)
+	}
+
+	server.WaitFor(2*time.Second, func(requests []telegramtest.Request) bool {
+		return len(server.Requests("editMessageText")) == 2
+	})
+	time.Sleep(300 * time.Millisecond)
+	var texts []string
+	for _, req := range server.Requests("editMessageText") {
+		texts = append(texts, req.Text())
+	}
+	if strings.Join(texts, "|") != "lần 5|vé khác" {
+		t.Fatalf("expected only the latest edit of each message, got %v", texts)
+	}
+	if dispatcher.Depth() != 0 {
+		t.Fatalf("queue was not drained, depth %d", dispatcher.Depth())
+	}
+}
+
+func TestDispatcherQueuesDeletes(t *testing.T) {
+	dispatcher, server := newTestDispatcher(t, DefaultRateLimits())
+
+	dispatcher.Enqueue(tgbotapi.NewDeleteMessage(-100, 7))
+	deleted := server.WaitFor(2*time.Second, func(requests []telegramtest.Request) bool {
+		return len(server.Requests("deleteMessage")) == 1
+	})
+	if !deleted || dispatcher.Depth() != 0 {
+		t.Fatal("queued delete was not sent")
+	}
+}
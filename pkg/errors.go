@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/apex/log"
+)
+
+type ErrorKind int
+
+const (
+	// ERROR_USER is a mistake of the user, the message tells them what to do.
+	ERROR_USER ErrorKind = iota
+	// ERROR_PERMISSION is a press on a button the user may not use.
+	ERROR_PERMISSION
+	// ERROR_INTERNAL is a failure of the bot. Its cause is logged and the
+	// user only sees a generic message.
+	ERROR_INTERNAL
+)
+
+// INTERNAL_ERROR_MESSAGE is the key of the generic text shown for internal
+// failures.
+const INTERNAL_ERROR_MESSAGE = "error.internal"
+
+// BotError is the error returned by the handlers. Its message is a key of
+// the catalog, translated for whoever reads it.
+type BotError struct {
+	Kind  ErrorKind
+	Key   string
+	Args  []interface{}
+	Cause error
+}
+
+func UserError(key string, a ...interface{}) *BotError {
+	return &BotError{Kind: ERROR_USER, Key: key, Args: a}
+}
+
+func PermissionError(key string, a ...interface{}) *BotError {
+	return &BotError{Kind: ERROR_PERMISSION, Key: key, Args: a}
+}
+
+func InternalError(cause error) *BotError {
+	return &BotError{Kind: ERROR_INTERNAL, Key: INTERNAL_ERROR_MESSAGE, Cause: cause}
+}
+
+// Text is the message of the error in the language.
+func (err *BotError) Text(language string) string {
+	return T(language, err.Key, err.Args...)
+}
+
+func (err *BotError) Error() string {
+	if err.Cause != nil {
+		return fmt.Sprintf("%s: %s", err.Text(DEFAULT_LANGUAGE), err.Cause.Error())
+	}
+	return err.Text(DEFAULT_LANGUAGE)
+}
+
+func (err *BotError) Unwrap() error {
+	return err.Cause
+}
+
+// AsBotError classifies any error, errors which are not a BotError are
+// internal failures.
+func AsBotError(err error) *BotError {
+	var botErr *BotError
+	if errors.As(err, &botErr) {
+		return botErr
+	}
+	return InternalError(err)
+}
+
+// errorMessage is the text shown to the user for the error, in their
+// language. Internal failures are logged here.
+func errorMessage(language string, err error) string {
+	botErr := AsBotError(err)
+	if botErr.Kind == ERROR_INTERNAL && botErr.Cause != nil {
+		log.Errorf("internal error: %s", botErr.Cause.Error())
+	}
+	return botErr.Text(language)
+}
@@ -0,0 +1,240 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/apex/log"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Event kinds of the game log, see GameEvent.
+const (
+	EVENT_OPENED      = "opened"
+	EVENT_JOINED      = "joined"
+	EVENT_TICKET      = "ticket"
+	EVENT_STARTED     = "started"
+	EVENT_DRAWN       = "drawn"
+	EVENT_DAUBED      = "daubed"
+	EVENT_UNDAUBED    = "undaubed"
+	EVENT_WAIT        = "wait"
+	EVENT_BINGO       = "bingo"
+	EVENT_FALSE_BINGO = "false_bingo"
+	EVENT_PAUSED      = "paused"
+	EVENT_RESUMED     = "resumed"
+	EVENT_HOST        = "host"
+	EVENT_FINISHED    = "finished"
+
+	// MAX_MESSAGE_LENGTH is the longest text Telegram accepts in a message
+	MAX_MESSAGE_LENGTH = 4096
+)
+
+// GameEvent is an entry of the event log of a game. Ticket is the 1-based
+// number of the ticket of the player, Number the drawn or daubed number.
+// Daubs made by the auto daub mode are not logged, they follow the draws.
+type GameEvent struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	UserId   int64     `json:"user_id,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Ticket   int       `json:"ticket,omitempty"`
+	Number   int       `json:"number,omitempty"`
+}
+
+// GameExport is the JSON export of a game: its archive, when the game was
+// finished, and its whole event log.
+type GameExport struct {
+	ChatId  int64        `json:"chat_id"`
+	GameId  int          `json:"game_id"`
+	Archive *GameArchive `json:"archive,omitempty"`
+	Events  []GameEvent  `json:"events"`
+}
+
+// logEvent appends the event to the log of the game. The log is only a
+// record, a failure does not stop the game.
+func (handler *MessageHandler) logEvent(game *Lobby, event GameEvent) {
+	event.Time = time.Now()
+	if err := handler.storage.AppendEvent(game.ChatId, game.GameId, event); err != nil {
+		log.Errorf("log %s event of game %d error: %s", event.Kind, game.GameId, err.Error())
+	}
+}
+
+// playerEvent is an event done by the player, with the 0-based index of
+// the ticket or -1.
+func playerEvent(kind string, player *Player, ticket int, number int) GameEvent {
+	return GameEvent{
+		Kind:     kind,
+		UserId:   player.Id,
+		Username: player.Username,
+		Ticket:   ticket + 1,
+		Number:   number,
+	}
+}
+
+// userEvent is an event done by a user who may not play, like the host.
+func userEvent(kind string, user *tgbotapi.User) GameEvent {
+	return GameEvent{Kind: kind, UserId: user.ID, Username: user.UserName}
+}
+
+// replay posts the compressed timeline of a game of the chat:
+// "/replay <game id>", or sends its export with "/replay <game id> json".
+func (handler *MessageHandler) replay(update *tgbotapi.Update) error {
+	chatId := update.Message.Chat.ID
+	args := strings.Fields(update.Message.CommandArguments())
+	if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[1] != "json") {
+		return UserError("replay.usage", CMD_REPLAY, CMD_REPLAY)
+	}
+	gameId, err := strconv.Atoi(args[0])
+	if err != nil {
+		return UserError("verify.bad_game", args[0])
+	}
+	if game := handler.lobbies.Get(chatId); game != nil {
+		game.lock.Lock()
+		running := game.GameId == gameId
+		game.lock.Unlock()
+		if running {
+			// the daubs would show the tickets of the players
+			return UserError("replay.running", gameId)
+		}
+	}
+
+	events, err := handler.storage.LoadEvents(chatId, gameId)
+	if err != nil {
+		return InternalError(err)
+	}
+	if len(events) == 0 {
+		return UserError("replay.not_found", gameId)
+	}
+
+	if len(args) == 2 {
+		return handler.sendExport(chatId, update.Message.MessageID, gameId, events)
+	}
+
+	language := handler.chatLanguage(chatId)
+	for _, text := range splitMessage(replayTimeline(language, gameId, events), MAX_MESSAGE_LENGTH) {
+		msg := tgbotapi.NewMessage(chatId, text)
+		msg.ReplyToMessageID = update.Message.MessageID
+		handler.sendMessage(msg)
+	}
+
+	return nil
+}
+
+// sendExport sends the game as a JSON document.
+func (handler *MessageHandler) sendExport(chatId int64, replyTo int, gameId int, events []GameEvent) error {
+	export := GameExport{ChatId: chatId, GameId: gameId, Events: events}
+	archives, err := handler.storage.LoadArchives(chatId, time.Time{})
+	if err != nil {
+		return InternalError(err)
+	}
+	for i := range archives {
+		if archives[i].GameId == gameId {
+			export.Archive = &archives[i]
+		}
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return InternalError(err)
+	}
+	document := tgbotapi.NewDocument(chatId, tgbotapi.FileBytes{Name: fmt.Sprintf("game-%d.json", gameId), Bytes: data})
+	document.Caption = T(handler.chatLanguage(chatId), "replay.export", gameId, len(events))
+	document.ReplyToMessageID = replyTo
+	if _, err := handler.bot.Send(document); err != nil {
+		return InternalError(fmt.Errorf("send export of game %d: %w", gameId, err))
+	}
+
+	return nil
+}
+
+// replayTimeline writes a line per event, offset from the opening of the
+// lobby. Draws and the daubs between them are folded into one line.
+func replayTimeline(language string, gameId int, events []GameEvent) string {
+	lines := []string{TN(language, "replay.title", len(events), gameId)}
+	start := events[0].Time
+
+	var drawn []string
+	var drawnAt time.Time
+	daubs := 0
+	flush := func() {
+		if len(drawn) == 0 {
+			return
+		}
+		line := T(language, "replay.event.drawn", strings.Join(drawn, ", "))
+		if daubs > 0 {
+			line += " " + TN(language, "replay.daubs", daubs)
+		}
+		lines = append(lines, replayOffset(drawnAt.Sub(start))+" "+line)
+		drawn, daubs = nil, 0
+	}
+
+	for _, event := range events {
+		switch event.Kind {
+		case EVENT_DRAWN:
+			if len(drawn) == 0 {
+				drawnAt = event.Time
+			}
+			drawn = append(drawn, strconv.Itoa(event.Number))
+			continue
+		case EVENT_DAUBED, EVENT_UNDAUBED:
+			if len(drawn) > 0 {
+				daubs++
+				continue
+			}
+		}
+		flush()
+		lines = append(lines, replayOffset(event.Time.Sub(start))+" "+replayEvent(language, event))
+	}
+	flush()
+
+	return strings.Join(lines, "\n")
+}
+
+func replayEvent(language string, event GameEvent) string {
+	key := "replay.event." + event.Kind
+	if event.UserId == 0 && (event.Kind == EVENT_STARTED || event.Kind == EVENT_FINISHED) {
+		// started or closed on its own
+		return T(language, key+"_auto")
+	}
+	switch event.Kind {
+	case EVENT_OPENED, EVENT_JOINED, EVENT_STARTED, EVENT_WAIT, EVENT_PAUSED, EVENT_RESUMED, EVENT_HOST, EVENT_FINISHED:
+		return T(language, key, event.Username)
+	case EVENT_TICKET, EVENT_BINGO, EVENT_FALSE_BINGO:
+		return T(language, key, event.Username, event.Ticket)
+	case EVENT_DAUBED, EVENT_UNDAUBED:
+		return T(language, key, event.Username, event.Number, event.Ticket)
+	}
+
+	return event.Kind
+}
+
+// replayOffset formats the time since the opening as "+mm:ss".
+func replayOffset(offset time.Duration) string {
+	seconds := int(offset / time.Second)
+	return fmt.Sprintf("+%02d:%02d", seconds/60, seconds%60)
+}
+
+// splitMessage cuts the text at line ends into texts of at most limit
+// bytes. Longer lines are cut as they are.
+func splitMessage(text string, limit int) []string {
+	var texts []string
+	for len(text) > limit {
+		// the line may end right after the limit
+		end := strings.LastIndexByte(text[:limit+1], '\n')
+		if end <= 0 {
+			end = limit
+			for end > 0 && !utf8.RuneStart(text[end]) {
+				end--
+			}
+		}
+		texts = append(texts, text[:end])
+		text = strings.TrimPrefix(text[end:], "\n")
+	}
+
+	return append(texts, text)
+}
@@ -0,0 +1,176 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+func TestBoltStorageEvents(t *testing.T) {
+	storage, err := NewBoltStorage(filepath.Join(t.TempDir(), "lotovn.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	for _, number := range []int{5, 17, 88} {
+		if err := storage.AppendEvent(-100, 1, GameEvent{Kind: EVENT_DRAWN, Number: number}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// game 10 shares the "1" prefix but not the log
+	if err := storage.AppendEvent(-100, 10, GameEvent{Kind: EVENT_OPENED}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := storage.LoadEvents(-100, 1)
+	if err != nil || len(events) != 3 || events[0].Number != 5 || events[2].Number != 88 || events[0].Seq >= events[1].Seq {
+		t.Fatalf("unexpected events %+v (%v)", events, err)
+	}
+
+	if err := storage.DeleteEvents(-100, 1); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := storage.LoadEvents(-100, 1); err != nil || len(events) != 0 {
+		t.Fatalf("events were not deleted: %+v (%v)", events, err)
+	}
+	if events, err := storage.LoadEvents(-100, 10); err != nil || len(events) != 1 {
+		t.Fatalf("events of another game were deleted: %+v (%v)", events, err)
+	}
+}
+
+func TestReplayTimeline(t *testing.T) {
+	start := time.Date(2024, 2, 10, 20, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	events := []GameEvent{
+		{Time: at(0), Kind: EVENT_OPENED, Username: "lotovn_host"},
+		{Time: at(5), Kind: EVENT_JOINED, Username: "player_teo"},
+		{Time: at(30), Kind: EVENT_STARTED, UserId: 10, Username: "lotovn_host"},
+		{Time: at(33), Kind: EVENT_DRAWN, Number: 5},
+		{Time: at(36), Kind: EVENT_DRAWN, Number: 17},
+		{Time: at(37), Kind: EVENT_DAUBED, Username: "player_teo", Ticket: 1, Number: 17},
+		{Time: at(39), Kind: EVENT_DRAWN, Number: 88},
+		{Time: at(70), Kind: EVENT_BINGO, Username: "player_teo", Ticket: 1},
+		{Time: at(3725), Kind: EVENT_FINISHED, UserId: 10, Username: "lotovn_host"},
+	}
+
+	want := strings.Join([]string{
+		"🎬 Replay of game 42 (9 events)",
+		"+00:00 🏠 @lotovn_host opened the lobby",
+		"+00:05 🙋 @player_teo joined",
+		"+00:30 ▶️ @lotovn_host started the draw",
+		"+00:33 🔢 Called 5, 17, 88 (1 daub)",
+		"+01:10 🎊 @player_teo won with ticket 1",
+		"+62:05 🏁 @lotovn_host finished the game",
+	}, "\n")
+	if text := replayTimeline("en", 42, events); text != want {
+		t.Fatalf("unexpected timeline:\n%s", text)
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	texts := splitMessage("aaaa\nbbbb\ncc", 9)
+	if len(texts) != 2 || texts[0] != "aaaa\nbbbb" || texts[1] != "cc" {
+		t.Fatalf("unexpected split %q", texts)
+	}
+	// a line longer than the limit is cut between runes
+	texts = splitMessage("ôôô", 4)
+	if len(texts) != 2 || texts[0] != "ôô" || texts[1] != "ô" {
+		t.Fatalf("unexpected split %q", texts)
+	}
+}
+
+func TestReplayCommand(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1030)
+	host := telegramtest.User(10, "lotovn_host")
+	player := telegramtest.User(11, "player_teo")
+
+	// a lobby closed before the draw leaves no history
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame"))
+	closed := handler.lobbies.Get(group.ID).GameId
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, closed, QUERY_DATA_STOP))
+	if events, err := handler.storage.LoadEvents(group.ID, closed); err != nil || len(events) != 0 {
+		t.Fatalf("closed lobby kept its events %+v (%v)", events, err)
+	}
+	server.Reset()
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 2, "/newgame interval=5s max=40 rows=3 perrow=2"))
+	game := handler.lobbies.Get(group.ID)
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	game.lock.Lock()
+	game.lifecycle = NewGame(time.Millisecond, game.lifecycle.ticketConfig())
+	game.lock.Unlock()
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_START))
+	if !server.WaitFor(5*time.Second, func(requests []telegramtest.Request) bool {
+		return countTexts(requests, group.ID, "Số ") == 40
+	}) {
+		t.Fatal("numbers were not called")
+	}
+
+	// the history of a running game stays hidden
+	bingo := findButton(ticketButtons(t, server, player.ID), QUERY_DATA_BINGO)
+	ticket := game.players[player.ID].Tickets[0]
+	server.Reset()
+	Dispatch(handler, telegramtest.MessageUpdate(group, player, 3, fmt.Sprintf("/replay %d", game.GameId)))
+	if countTexts(server.Sent(group.ID), group.ID, T(DEFAULT_LANGUAGE, "replay.running", game.GameId)) != 1 {
+		t.Fatal("running game was replayed")
+	}
+
+	Dispatch(handler, telegramtest.CallbackUpdate(telegramtest.Private(player), player, 4, bingo))
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_STOP))
+
+	events, err := handler.storage.LoadEvents(group.ID, game.GameId)
+	if err != nil || len(events) != 45 {
+		t.Fatalf("expected 45 events, got %d (%v)", len(events), err)
+	}
+	kinds := []string{EVENT_OPENED, EVENT_JOINED, EVENT_STARTED}
+	for i, kind := range kinds {
+		if events[i].Kind != kind {
+			t.Fatalf("event %d is %s, want %s", i, events[i].Kind, kind)
+		}
+	}
+	if events[43].Kind != EVENT_BINGO || events[43].Username != player.UserName || events[44].Kind != EVENT_FINISHED {
+		t.Fatalf("unexpected last events %+v", events[43:])
+	}
+
+	server.Reset()
+	Dispatch(handler, telegramtest.MessageUpdate(group, player, 5, fmt.Sprintf("/replay %d", game.GameId)))
+	sent := server.Sent(group.ID)
+	if len(sent) != 1 || !strings.HasPrefix(sent[0].Text(), TN(DEFAULT_LANGUAGE, "replay.title", 45, game.GameId)) {
+		t.Fatalf("unexpected replay %+v", sent)
+	}
+	// the 40 draws are folded into one line
+	if lines := strings.Split(sent[0].Text(), "\n"); len(lines) != 7 {
+		t.Fatalf("unexpected timeline:\n%s", sent[0].Text())
+	}
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, player, 6, fmt.Sprintf("/replay %d json", game.GameId)))
+	documents := server.Requests("sendDocument")
+	if len(documents) != 1 {
+		t.Fatal("export was not sent")
+	}
+	var export GameExport
+	if err := json.Unmarshal(documents[0].Files["document"], &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.GameId != game.GameId || len(export.Events) != 45 || export.Archive == nil || len(export.Archive.Draws) != 40 {
+		t.Fatalf("unexpected export %+v", export)
+	}
+	// the tickets are kept to check the Kinh again
+	if players := export.Archive.Players; len(players) != 1 || len(players[0].Boards) != 1 ||
+		!reflect.DeepEqual(players[0].Boards[0].Board, ticket.board) {
+		t.Fatalf("unexpected archived tickets %+v", export.Archive.Players)
+	}
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, player, 7, "/replay 999"))
+	if countTexts(server.Sent(group.ID), group.ID, T(DEFAULT_LANGUAGE, "replay.not_found", 999)) != 1 {
+		t.Fatal("unknown game was not reported")
+	}
+}
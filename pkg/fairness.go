@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DRAW_SEED_SIZE is the size in bytes of the secret draw seed.
+const DRAW_SEED_SIZE = 32
+
+// The draw is provably fair with a commit-reveal scheme: a lobby publishes
+// the SHA-256 commitment of a secret seed when it opens, the whole draw
+// order is derived from that seed, and the seed is revealed when the game
+// finishes. Anyone can then recompute the commitment and the draw order.
+
+// NewDrawSeed returns a secret seed from the system CSPRNG.
+func NewDrawSeed() []byte {
+	seed := make([]byte, DRAW_SEED_SIZE)
+	if _, err := rand.Read(seed); err != nil {
+		panic(fmt.Sprintf("read random draw seed: %s", err.Error()))
+	}
+	return seed
+}
+
+// Commitment is the hex SHA-256 of the seed.
+func Commitment(seed []byte) string {
+	sum := sha256.Sum256(seed)
+	return hex.EncodeToString(sum[:])
+}
+
+func ParseDrawSeed(text string) ([]byte, error) {
+	seed, err := hex.DecodeString(text)
+	if err != nil || len(seed) != DRAW_SEED_SIZE {
+		return nil, fmt.Errorf("draw seed must be %d hex characters", DRAW_SEED_SIZE*2)
+	}
+	return seed, nil
+}
+
+// drawStream is a deterministic stream of random uint64: the i-th block
+// of 32 bytes is SHA-256(seed || uint64 big endian i), read 8 bytes at a
+// time in big endian.
+type drawStream struct {
+	seed    []byte
+	counter uint64
+	block   []byte
+}
+
+func (stream *drawStream) next() uint64 {
+	if len(stream.block) == 0 {
+		input := make([]byte, len(stream.seed)+8)
+		copy(input, stream.seed)
+		binary.BigEndian.PutUint64(input[len(stream.seed):], stream.counter)
+		sum := sha256.Sum256(input)
+		stream.block = sum[:]
+		stream.counter++
+	}
+
+	value := binary.BigEndian.Uint64(stream.block[:8])
+	stream.block = stream.block[8:]
+	return value
+}
+
+// uniform returns a value in [0, n) without modulo bias by rejecting the
+// values above the largest multiple of n.
+func (stream *drawStream) uniform(n uint64) uint64 {
+	limit := ^uint64(0) - (^uint64(0) % n)
+	for {
+		if value := stream.next(); value < limit {
+			return value % n
+		}
+	}
+}
+
+// DrawOrder derives the full draw order of the number space from the seed
+// with a Fisher-Yates shuffle of 1..max: for i from the last index down
+// to 1, swap i with uniform(i+1).
+func DrawOrder(seed []byte, space NumberSpace) []int {
+	order := space.Numbers()
+	stream := &drawStream{seed: seed}
+	for i := len(order) - 1; i > 0; i-- {
+		j := stream.uniform(uint64(i + 1))
+		order[i], order[j] = order[j], order[i]
+	}
+
+	return order
+}
+
+// VerifyDraw checks that the seed matches the commitment and that the
+// drawn numbers follow the order derived from it.
+func VerifyDraw(commitment string, seed []byte, space NumberSpace, draws []int) error {
+	if Commitment(seed) != commitment {
+		return fmt.Errorf("seed does not match the commitment %s", commitment)
+	}
+
+	order := DrawOrder(seed, space)
+	if len(draws) > len(order) {
+		return fmt.Errorf("%d numbers drawn from a space of %d", len(draws), len(order))
+	}
+	for i, number := range draws {
+		if order[i] != number {
+			return fmt.Errorf("draw %d was %d, the seed gives %d", i+1, number, order[i])
+		}
+	}
+
+	return nil
+}
+
+// verify checks a finished game of the chat: "/verify <game id> <seed>".
+func (handler *MessageHandler) verify(update *tgbotapi.Update) (string, error) {
+	args := strings.Fields(update.Message.CommandArguments())
+	if len(args) != 2 {
+		return "", UserError("verify.usage", CMD_VERIFY)
+	}
+	gameId, err := strconv.Atoi(args[0])
+	if err != nil {
+		return "", UserError("verify.bad_game", args[0])
+	}
+	seed, err := ParseDrawSeed(args[1])
+	if err != nil {
+		return "", UserError("verify.bad_seed", DRAW_SEED_SIZE*2)
+	}
+
+	language := handler.chatLanguage(update.Message.Chat.ID)
+	archives, err := handler.storage.LoadArchives(update.Message.Chat.ID, time.Time{})
+	if err != nil {
+		return "", InternalError(err)
+	}
+	for _, archive := range archives {
+		if archive.GameId != gameId {
+			continue
+		}
+		if err := VerifyDraw(archive.Commitment, seed, NumberSpace{Max: archive.MaxNumber}, archive.Draws); err != nil {
+			return T(language, "verify.mismatch", gameId, err.Error()), nil
+		}
+		return T(language, "verify.ok", gameId, archive.Commitment, len(archive.Draws)), nil
+	}
+
+	return "", UserError("verify.not_found", gameId)
+}
@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+func testDrawSeed() []byte {
+	seed := make([]byte, DRAW_SEED_SIZE)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	return seed
+}
+
+func TestDrawOrderVector(t *testing.T) {
+	// pinned so other implementations of the verifier can check themselves
+	seed := testDrawSeed()
+	if commitment := Commitment(seed); commitment != "630dcd2966c4336691125448bbb25b4ff412a49c732db2c8abc1b8581bd710dd" {
+		t.Fatalf("unexpected commitment %s", commitment)
+	}
+	order := DrawOrder(seed, NumberSpace{Max: CLASSIC_NUMBER_SPACE})
+	if first := fmt.Sprint(order[:10]); first != "[83 58 70 84 32 5 4 48 80 24]" {
+		t.Fatalf("unexpected draw order %s", first)
+	}
+
+	sorted := append([]int{}, order...)
+	sort.Ints(sorted)
+	for i, number := range sorted {
+		if number != i+1 {
+			t.Fatalf("draw order is not a permutation of 1-90: %v", sorted)
+		}
+	}
+	if fmt.Sprint(DrawOrder(NewDrawSeed(), NumberSpace{Max: 90})) == fmt.Sprint(order) {
+		t.Fatal("a new seed gave the same draw order")
+	}
+}
+
+func TestVerifyDraw(t *testing.T) {
+	seed := testDrawSeed()
+	space := NumberSpace{Max: 40}
+	commitment := Commitment(seed)
+	draws := DrawOrder(seed, space)[:12]
+
+	if err := VerifyDraw(commitment, seed, space, draws); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyDraw(commitment, NewDrawSeed(), space, draws); err == nil {
+		t.Fatal("expected another seed to be rejected")
+	}
+	tampered := append([]int{}, draws...)
+	tampered[3], tampered[4] = tampered[4], tampered[3]
+	if err := VerifyDraw(commitment, seed, space, tampered); err == nil {
+		t.Fatal("expected a tampered draw to be rejected")
+	}
+	if _, err := ParseDrawSeed("abc"); err == nil {
+		t.Fatal("expected a short seed to be rejected")
+	}
+}
+
+// Pausing in the middle of a draw must not change the committed order.
+func TestDrawFollowsCommitmentAcrossPauses(t *testing.T) {
+	game := NewGame(time.Millisecond, DefaultGameSettings().Ticket).(*Game)
+	release := game.start()
+
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 5; i++ {
+			game.addResultSeed(<-release)
+		}
+		game.pause()
+		// a number popped before the pause goes back in front
+		time.Sleep(2 * time.Millisecond)
+		game.resume()
+	}
+	game.stop()
+
+	if err := VerifyDraw(game.commitment(), game.drawSeed(), game.TicketConifg.Space(), game.result()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Changing a lobby setting keeps the seed players saw the commitment of.
+func TestSettingsKeepCommitment(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	group := telegramtest.Group(-1016)
+	host := telegramtest.User(10, "lotovn_host")
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame"))
+	game := handler.lobbies.Get(group.ID)
+	commitment := game.lifecycle.commitment()
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_SETTING+";max;-5"))
+
+	game.lock.Lock()
+	defer game.lock.Unlock()
+	if game.lifecycle.ticketConfig().MaxNumer != DefaultGameSettings().Ticket.MaxNumer-5 {
+		t.Fatal("setting was not changed")
+	}
+	if game.lifecycle.commitment() != commitment {
+		t.Fatal("commitment changed with the settings")
+	}
+}
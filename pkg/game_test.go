@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+// ticketButtons returns the callback data of the latest keyboard the player
+// received for the ticket message.
+func ticketButtons(t *testing.T, server *telegramtest.Server, userId int64) []string {
+	t.Helper()
+
+	var markup tgbotapi.InlineKeyboardMarkup
+	found := false
+	for _, req := range server.Requests("sendMessage", "editMessageText") {
+		if req.ChatId() != userId {
+			continue
+		}
+		if m, ok := req.ReplyMarkup(); ok {
+			markup, found = m, true
+		}
+	}
+	if !found {
+		t.Fatalf("player %d did not receive a ticket keyboard", userId)
+	}
+
+	var data []string
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData != nil {
+				data = append(data, *button.CallbackData)
+			}
+		}
+	}
+	return data
+}
+
+func findButton(buttons []string, prefix string) string {
+	for _, data := range buttons {
+		if strings.HasPrefix(data, prefix) {
+			return data
+		}
+	}
+	return ""
+}
+
+func countTexts(requests []telegramtest.Request, chatId int64, prefix string) int {
+	count := 0
+	for _, req := range requests {
+		if req.ChatId() == chatId && strings.HasPrefix(req.Text(), prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestPlayFullGame(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1001)
+	host := telegramtest.User(10, "lotovn_host")
+	players := []*tgbotapi.User{
+		telegramtest.User(11, "player_teo"),
+		telegramtest.User(12, "player_ti"),
+		telegramtest.User(13, "player_tun"),
+	}
+
+	// open
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame interval=5s max=40 rows=3 perrow=2"))
+	game := handler.lobbies.Get(group.ID)
+	if game == nil {
+		t.Fatal("lobby was not opened")
+	}
+	sent := server.Sent(group.ID)
+	if len(sent) == 0 || sent[0].Params.Get("reply_markup") == "" {
+		t.Fatal("lobby message with keyboard was not sent")
+	}
+
+	// register three players, each gets a private ticket
+	for _, player := range players {
+		Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	}
+	for _, player := range players {
+		if len(server.Sent(player.ID)) != 1 {
+			t.Fatalf("player %s did not receive a ticket", player.UserName)
+		}
+	}
+
+	// draw fast so the whole space is called within the test
+	game.lock.Lock()
+	game.lifecycle = NewGame(time.Millisecond, game.lifecycle.ticketConfig())
+	game.lock.Unlock()
+
+	// start
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_START))
+	drawn := server.WaitFor(5*time.Second, func(requests []telegramtest.Request) bool {
+		return countTexts(requests, group.ID, "Số ") == 40
+	})
+	if !drawn {
+		t.Fatalf("expected 40 numbers to be called, got %d", countTexts(server.Requests(), group.ID, "Số "))
+	}
+
+	// daub a called number of the first ticket
+	daub := findButton(ticketButtons(t, server, players[0].ID), QUERY_DATA_CHECKED)
+	if daub == "" {
+		t.Fatal("ticket has no cell to daub")
+	}
+	Dispatch(handler, telegramtest.CallbackUpdate(telegramtest.Private(players[0]), players[0], 1, daub))
+	daubed := false
+	for _, req := range server.Requests("editMessageText") {
+		if req.ChatId() == players[0].ID && strings.Contains(req.Params.Get("reply_markup"), "✅") {
+			daubed = true
+		}
+	}
+	if !daubed {
+		t.Fatal("daubed cell was not marked on the ticket")
+	}
+
+	// claim Kinh, every row is covered once the space is drawn
+	bingo := findButton(ticketButtons(t, server, players[1].ID), QUERY_DATA_BINGO)
+	Dispatch(handler, telegramtest.CallbackUpdate(telegramtest.Private(players[1]), players[1], 2, bingo))
+	announced := false
+	for _, req := range server.Requests("sendPhoto") {
+		if req.ChatId() == group.ID && strings.Contains(req.Text(), "@"+players[1].UserName) {
+			announced = true
+		}
+	}
+	if !announced {
+		t.Fatal("valid Kinh was not announced")
+	}
+	if len(game.winners) != 1 || game.winners[0].Id != players[1].ID {
+		t.Fatalf("expected %s to win", players[1].UserName)
+	}
+
+	// finish
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_STOP))
+	if countTexts(server.Requests(), group.ID, "Kết thúc!") != 1 {
+		t.Fatal("finish was not announced")
+	}
+	for _, player := range players {
+		if countPhotos(server, player.ID) != 1 {
+			t.Fatalf("player %s did not receive the ticket picture", player.UserName)
+		}
+	}
+	if handler.lobbies.Get(group.ID) != nil {
+		t.Fatal("lobby was not removed after finish")
+	}
+	records, err := handler.storage.LoadLobbies()
+	if err != nil || len(records) != 0 {
+		t.Fatalf("expected no stored lobby, got %d (%v)", len(records), err)
+	}
+
+	// the finished game is archived for the statistics
+	archives, err := handler.storage.LoadArchives(group.ID, time.Time{})
+	if err != nil || len(archives) != 1 || len(archives[0].Draws) != 40 || archives[0].Winners[0] != players[1].ID {
+		t.Fatalf("unexpected archive %+v (%v)", archives, err)
+	}
+	// the revealed seed verifies the draw
+	seed := archives[0].DrawSeed
+	if countTexts(server.Sent(group.ID), group.ID, "🔓 Hạt giống bộ số: <code>"+seed) != 1 {
+		t.Fatal("draw seed was not revealed")
+	}
+	Dispatch(handler, telegramtest.MessageUpdate(group, players[1], 4, fmt.Sprintf("/verify %d %s", game.GameId, seed)))
+	if countTexts(server.Sent(group.ID), group.ID, "✅ Game") != 1 {
+		t.Fatal("revealed seed did not verify the draw")
+	}
+	Dispatch(handler, telegramtest.MessageUpdate(group, players[1], 5, "/top week"))
+	if countTexts(server.Sent(group.ID), group.ID, "🏆 Bảng xếp hạng 7 ngày qua (1 ván)") != 1 {
+		t.Fatal("leaderboard was not posted")
+	}
+}
+
+func countPhotos(server *telegramtest.Server, chatId int64) int {
+	count := 0
+	for _, req := range server.Requests("sendPhoto") {
+		if req.ChatId() == chatId && len(req.Files["photo"]) > 0 {
+			count++
+		}
+	}
+	return count
+}
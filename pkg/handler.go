@@ -1,6 +1,10 @@
 package pkg
 
 import (
+	"sync"
+	"time"
+
+	"github.com/apex/log"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
@@ -8,11 +12,30 @@ type Handler interface {
 	Command(update *tgbotapi.Update) error
 	Keyboard(update *tgbotapi.Update) error
 	InlineKeyboard(update *tgbotapi.Update) error
+	Restore() error
+	RunSchedules(interval time.Duration, quit <-chan struct{})
+}
+
+// BotClient is the part of the Bot API the handlers use. *tgbotapi.BotAPI
+// implements it, tests point it to telegramtest.Server.
+type BotClient interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
 }
 
 type MessageHandler struct {
-	bot             *tgbotapi.BotAPI
+	bot             BotClient
+	storage         Storage
+	lobbies         *LobbyRegistry
+	admins          *AdminCache
+	rhymes          *RhymeCatalog
+	voices          *VoicePack
+	languages       *LanguageCache
+	templates       *Templates
 	SpreadsheetClub *SpreadsheetClub
+
+	// scheduleLock serializes the changes of the schedules
+	scheduleLock sync.Mutex
 }
 
 //	func NewHandler(bot *tgbotapi.BotAPI, sheetClub *SpreadsheetClub) Handler {
@@ -22,8 +45,51 @@ type MessageHandler struct {
 //		}
 //	}
 
-func NewHandler(bot *tgbotapi.BotAPI) Handler {
+// NewHandler creates the handler of the bot. Without a rhyme catalog the
+// numbers are only sung with the rhymes the groups added themselves,
+// without a voice pack they are not read out loud and without templates
+// the embedded ones are used.
+func NewHandler(bot BotClient, storage Storage, rhymes *RhymeCatalog, voices *VoicePack, templates *Templates) Handler {
+	if rhymes == nil {
+		rhymes = NewRhymeCatalog(nil)
+	}
+	if templates == nil {
+		var err error
+		if templates, err = LoadTemplates(""); err != nil {
+			// the embedded templates are checked by the tests
+			panic(err)
+		}
+	}
 	return &MessageHandler{
-		bot: bot,
+		bot:       bot,
+		storage:   storage,
+		lobbies:   NewLobbyRegistry(),
+		admins:    NewAdminCache(bot, ADMIN_CACHE_TTL),
+		rhymes:    rhymes,
+		voices:    voices,
+		languages: NewLanguageCache(storage),
+		templates: templates,
+	}
+}
+
+// Dispatch routes an update to the matching handler method. It is shared by
+// long polling and the webhook server so both modes behave the same.
+func Dispatch(handler Handler, update *tgbotapi.Update) {
+	if update.Message != nil { // If we got a message
+		log.Infof("[%s] %s", update.Message.From.UserName, update.Message.Text)
+
+		var err error
+		if update.Message.IsCommand() {
+			err = handler.Command(update)
+		} else {
+			err = handler.Keyboard(update)
+		}
+		if err != nil {
+			log.Errorf("handle message %d of chat %d error: %s", update.Message.MessageID, update.Message.Chat.ID, err.Error())
+		}
+	} else if update.CallbackQuery != nil {
+		if err := handler.InlineKeyboard(update); err != nil {
+			log.Errorf("handle callback %s error: %s", update.CallbackQuery.ID, err.Error())
+		}
 	}
 }
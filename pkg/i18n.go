@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ted-vo/lotovn-telegram-bot/config"
+)
+
+const (
+	DEFAULT_LANGUAGE = "vi"
+
+	PLURAL_ONE   = "one"
+	PLURAL_OTHER = "other"
+)
+
+// Message is a translated text. Texts which depend on a count have a form
+// per plural category, written as {"one": "...", "other": "..."} in the
+// catalog; a plain string is the "other" form.
+type Message map[string]string
+
+func (message *Message) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*message = Message{PLURAL_OTHER: text}
+		return nil
+	}
+
+	var forms map[string]string
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return err
+	}
+	if len(forms[PLURAL_OTHER]) == 0 {
+		return fmt.Errorf("plural message without an %q form", PLURAL_OTHER)
+	}
+	*message = forms
+	return nil
+}
+
+// pluralRules picks the plural category of a count. Languages without a
+// rule, like Vietnamese, only have the "other" form.
+var pluralRules = map[string]func(n int) string{
+	"en": func(n int) string {
+		if n == 1 {
+			return PLURAL_ONE
+		}
+		return PLURAL_OTHER
+	},
+}
+
+// Catalog holds the messages of every shipped language by key.
+type Catalog struct {
+	locales map[string]map[string]Message
+}
+
+// LoadCatalog reads the <language>.json files of the directory.
+func LoadCatalog(fsys fs.FS, dir string) (*Catalog, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &Catalog{locales: make(map[string]map[string]Message)}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]Message
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", file, err)
+		}
+		catalog.locales[strings.TrimSuffix(path.Base(file), ".json")] = messages
+	}
+	if catalog.locales[DEFAULT_LANGUAGE] == nil {
+		return nil, fmt.Errorf("catalog %s has no %s locale", dir, DEFAULT_LANGUAGE)
+	}
+
+	return catalog, nil
+}
+
+// Languages lists the shipped languages.
+func (catalog *Catalog) Languages() []string {
+	var languages []string
+	for language := range catalog.locales {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+func (catalog *Catalog) supports(language string) bool {
+	return catalog.locales[language] != nil
+}
+
+// message finds the text of the key, falling back to the default language
+// and at last to the key itself so a missing text is easy to spot.
+func (catalog *Catalog) message(language string, key string) Message {
+	if message, ok := catalog.locales[language][key]; ok {
+		return message
+	}
+	if message, ok := catalog.locales[DEFAULT_LANGUAGE][key]; ok {
+		return message
+	}
+	return Message{PLURAL_OTHER: key}
+}
+
+func (catalog *Catalog) Text(language string, key string, a ...interface{}) string {
+	text := catalog.message(language, key)[PLURAL_OTHER]
+	if len(a) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, a...)
+}
+
+// Plural formats the form of the key matching the count, the count is the
+// first argument of the format.
+func (catalog *Catalog) Plural(language string, key string, count int, a ...interface{}) string {
+	message := catalog.message(language, key)
+	form := PLURAL_OTHER
+	if rule := pluralRules[language]; rule != nil {
+		form = rule(count)
+	}
+	text, ok := message[form]
+	if !ok {
+		text = message[PLURAL_OTHER]
+	}
+	return fmt.Sprintf(text, append([]interface{}{count}, a...)...)
+}
+
+// messages is the catalog shipped with the bot.
+var messages = func() *Catalog {
+	catalog, err := LoadCatalog(config.Locales, "locales")
+	if err != nil {
+		panic(err)
+	}
+	return catalog
+}()
+
+// T translates the key into the language.
+func T(language string, key string, a ...interface{}) string {
+	return messages.Text(language, key, a...)
+}
+
+// TN translates the key with the plural form matching the count.
+func TN(language string, key string, count int, a ...interface{}) string {
+	return messages.Plural(language, key, count, a...)
+}
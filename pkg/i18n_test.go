@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+var formatVerb = regexp.MustCompile(`%(\[\d+\])?[-+# 0]*[0-9.]*[a-zA-Z%]`)
+
+// formatVerbs lists the verbs of a format so translations can be compared
+// even when they reorder the arguments.
+func formatVerbs(text string) []string {
+	var verbs []string
+	for _, verb := range formatVerb.FindAllString(text, -1) {
+		if verb != "%%" {
+			verbs = append(verbs, verb)
+		}
+	}
+	sort.Strings(verbs)
+	return verbs
+}
+
+func TestCatalogLocalesMatch(t *testing.T) {
+	reference := messages.locales[DEFAULT_LANGUAGE]
+	for _, language := range messages.Languages() {
+		locale := messages.locales[language]
+		if len(locale) != len(reference) {
+			t.Errorf("%s has %d messages, %s has %d", language, len(locale), DEFAULT_LANGUAGE, len(reference))
+		}
+		for key, message := range reference {
+			translated, ok := locale[key]
+			if !ok {
+				t.Errorf("%s misses %s", language, key)
+				continue
+			}
+			want := strings.Join(formatVerbs(message[PLURAL_OTHER]), " ")
+			for form, text := range translated {
+				if got := strings.Join(formatVerbs(text), " "); got != want {
+					t.Errorf("%s %s (%s) has verbs %q, want %q", language, key, form, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCatalogPlural(t *testing.T) {
+	if text := TN("en", "stats.game_count", 1); text != "1 game" {
+		t.Fatalf("unexpected singular %q", text)
+	}
+	if text := TN("en", "stats.game_count", 3); text != "3 games" {
+		t.Fatalf("unexpected plural %q", text)
+	}
+	if text := TN(DEFAULT_LANGUAGE, "stats.game_count", 1); text != "1 ván" {
+		t.Fatalf("unexpected vietnamese %q", text)
+	}
+	if text := TN("en", "game.waited", 2, "player_teo"); text != "@player_teo is waiting (2 times)" {
+		t.Fatalf("unexpected reordered plural %q", text)
+	}
+	// unknown languages and keys fall back
+	if text := T("xx", "game.finished"); text != "Kết thúc!" {
+		t.Fatalf("unexpected fallback %q", text)
+	}
+	if text := T("en", "no.such.key"); text != "no.such.key" {
+		t.Fatalf("unexpected missing key %q", text)
+	}
+}
+
+func TestLanguageCommand(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1010)
+	admin := telegramtest.User(10, "group_admin")
+	player := telegramtest.User(11, "player_teo")
+	server.Handle("getChatAdministrators", func(req telegramtest.Request) (interface{}, error) {
+		return []tgbotapi.ChatMember{{User: admin, Status: "administrator"}}, nil
+	})
+
+	// only administrators choose the language of the group
+	Dispatch(handler, telegramtest.MessageUpdate(group, player, 1, "/lang en"))
+	if handler.chatLanguage(group.ID) != DEFAULT_LANGUAGE {
+		t.Fatal("player changed the language of the group")
+	}
+	Dispatch(handler, telegramtest.MessageUpdate(group, admin, 2, "/lang fr"))
+	if handler.chatLanguage(group.ID) != DEFAULT_LANGUAGE {
+		t.Fatal("unsupported language was saved")
+	}
+	Dispatch(handler, telegramtest.MessageUpdate(group, admin, 3, "/lang en"))
+	if handler.chatLanguage(group.ID) != "en" {
+		t.Fatal("administrator could not change the language of the group")
+	}
+
+	server.Reset()
+	Dispatch(handler, telegramtest.MessageUpdate(group, admin, 4, "/newgame"))
+	game := handler.lobbies.Get(group.ID)
+	if game == nil || countTexts(server.Requests("editMessageText"), group.ID, T("en", "lobby.welcome")) == 0 {
+		t.Fatal("lobby was not posted in English")
+	}
+
+	// the player reads their own ticket in Vietnamese
+	Dispatch(handler, telegramtest.MessageUpdate(telegramtest.Private(player), player, 5, "/lang vi"))
+	if language := handler.userLanguage(player, group.ID); language != DEFAULT_LANGUAGE {
+		t.Fatalf("unexpected personal language %s", language)
+	}
+	server.Reset()
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	if countTexts(server.Sent(player.ID), player.ID, T(DEFAULT_LANGUAGE, "ticket.title")) != 1 {
+		t.Fatal("ticket was not sent in the language of the player")
+	}
+	if answer := lastAnswer(t, server); answer.Text() != T(DEFAULT_LANGUAGE, "callback.registered") {
+		t.Fatalf("unexpected confirmation %q", answer.Text())
+	}
+
+	// the language of the Telegram app comes before the one of the group
+	stranger := telegramtest.User(12, "stranger")
+	stranger.LanguageCode = "vi-VN"
+	if language := handler.userLanguage(stranger, group.ID); language != DEFAULT_LANGUAGE {
+		t.Fatalf("unexpected app language %s", language)
+	}
+}
@@ -1,28 +1,51 @@
 package pkg
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
+	"github.com/apex/log"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// The labels are keys of the message catalog, see T.
 const (
-	TITLE     = "Báo danh mua vé Lô Tô Cô/Chú/Bác/Anh/Chị/Em ơi"
-	OPEN_GAME = "📝 Mở báo danh"
-	HELP      = "❓ Help"
-	FEEDBACK  = "💡 Feedback"
+	TITLE     = "lobby.title"
+	OPEN_GAME = "button.open_game"
+	HELP      = "button.help"
+	FEEDBACK  = "button.feedback"
 
 	CMD_OPEN_MENU  = "open"
 	CMD_CLOSE_MENU = "close"
+	CMD_NEW_GAME   = "newgame"
+	CMD_BOARD      = "board"
+	CMD_HOST       = "host"
+	CMD_BALANCE    = "balance"
+	CMD_TOPUP      = "topup"
+	CMD_STATS      = "stats"
+	CMD_TOP        = "top"
+	CMD_VERIFY     = "verify"
+	CMD_ADD_RHYME  = "addrhyme"
+	CMD_LANG       = "lang"
+	CMD_REPLAY     = "replay"
+	CMD_SCHEDULE   = "schedule"
+	CMD_SCHEDULES  = "schedules"
 
-	ILB_REGISTER = "🎮 Báo danh"
-	ILB_START    = "🎬 Bắt đầu"
-	ILB_PAUSE    = "⏸ Tạm dừng"
-	ILB_RESUME   = "⏯ Tiếp tục"
-	ILB_STOP     = "🏁 Kết thúc"
-	ILB_WAIT     = "💣 Hò"
-	ILB_BINGO    = "🎊 Kinh"
+	ILB_REGISTER = "button.register"
+	ILB_START    = "button.start"
+	ILB_PAUSE    = "button.pause"
+	ILB_RESUME   = "button.resume"
+	ILB_STOP     = "button.stop"
+	ILB_WAIT     = "button.wait"
+	ILB_BINGO    = "button.bingo"
+
+	ILB_AUTO_WAIT_ON  = "button.auto_wait_on"
+	ILB_AUTO_WAIT_OFF = "button.auto_wait_off"
+	ILB_SETTINGS      = "button.settings"
+	ILB_BUY_TICKET    = "button.buy_ticket"
+	ILB_SETTINGS_DONE = "button.settings_done"
 
 	QUERY_DATA_REGISTER = "query_register"
 	QUERY_DATA_START    = "query_start"
@@ -32,46 +55,134 @@ const (
 	QUERY_DATA_WAIT     = "query_wait"
 	QUERY_DATA_BINGO    = "query_bingo"
 	QUERY_DATA_CHECKED  = "query_checked"
-)
 
-var LobbyKeyboard = tgbotapi.NewReplyKeyboard(
-	tgbotapi.NewKeyboardButtonRow(
-		tgbotapi.NewKeyboardButton(OPEN_GAME),
-	),
-)
+	QUERY_DATA_AUTO_WAIT = "query_auto_wait"
+	QUERY_DATA_SETTINGS  = "query_settings"
+	QUERY_DATA_SETTING   = "query_setting"
+	QUERY_DATA_BUY       = "query_buy"
+	QUERY_DATA_DAUB      = "query_daub"
 
-var PrivateKeyboard = tgbotapi.NewReplyKeyboard(
-	tgbotapi.NewKeyboardButtonRow(
-		tgbotapi.NewKeyboardButton("Hello"),
-	),
-)
+	QUERY_DATA_UNSCHEDULE = "query_unschedule"
 
-var OpenGameInlineKeyboard = tgbotapi.NewInlineKeyboardMarkup(
-	tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData(ILB_REGISTER, QUERY_DATA_REGISTER),
-	),
-	tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData(ILB_START, QUERY_DATA_START),
-	),
+	// Telegram clients do not render more buttons than this in a row
+	MAX_KEYBOARD_COLUMNS = 8
 )
 
-var PlayingInnlineKeyboard = tgbotapi.NewInlineKeyboardMarkup(
-	tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData(ILB_PAUSE, QUERY_DATA_PAUSE),
-	),
-	tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData(ILB_STOP, QUERY_DATA_STOP),
-	),
-)
+func lobbyKeyboard(language string) tgbotapi.ReplyKeyboardMarkup {
+	return tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton(T(language, OPEN_GAME)),
+		),
+	)
+}
 
-var PausedInlineKeyboard = tgbotapi.NewInlineKeyboardMarkup(
-	tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData(ILB_RESUME, QUERY_DATA_RESUME),
-	),
-	tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData(ILB_STOP, QUERY_DATA_STOP),
-	),
-)
+func privateKeyboard(language string) tgbotapi.ReplyKeyboardMarkup {
+	return tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton(T(language, "button.hello")),
+		),
+	)
+}
+
+// isButton tells if the text is the label of the reply keyboard button in
+// any language, the keyboard may have been sent before a /lang change.
+func isButton(text string, key string) bool {
+	for _, language := range messages.Languages() {
+		if text == T(language, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func GenerateOpenGameKeyboard(language string, autoWait bool, maxTickets int) tgbotapi.InlineKeyboardMarkup {
+	autoWaitLabel := ILB_AUTO_WAIT_OFF
+	if autoWait {
+		autoWaitLabel = ILB_AUTO_WAIT_ON
+	}
+
+	registerRow := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(T(language, ILB_REGISTER), QUERY_DATA_REGISTER),
+	)
+	if maxTickets > 1 {
+		registerRow = append(registerRow, tgbotapi.NewInlineKeyboardButtonData(T(language, ILB_BUY_TICKET), QUERY_DATA_BUY))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		registerRow,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(T(language, autoWaitLabel), QUERY_DATA_AUTO_WAIT),
+			tgbotapi.NewInlineKeyboardButtonData(T(language, ILB_SETTINGS), QUERY_DATA_SETTINGS),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(T(language, ILB_START), QUERY_DATA_START),
+		),
+	)
+}
+
+func GenerateSettingsKeyboard(language string, settings GameSettings) tgbotapi.InlineKeyboardMarkup {
+	settingRow := func(key string, label string, step int) []tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➖", fmt.Sprintf("%s;%s;%d", QUERY_DATA_SETTING, key, -step)),
+			tgbotapi.NewInlineKeyboardButtonData(label, " "),
+			tgbotapi.NewInlineKeyboardButtonData("➕", fmt.Sprintf("%s;%s;%d", QUERY_DATA_SETTING, key, step)),
+		)
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		settingRow("interval", T(language, "setting.interval", settings.Interval), 1),
+		settingRow("max", T(language, "setting.max", settings.Ticket.MaxNumer), 5),
+		settingRow("rows", T(language, "setting.rows", settings.Ticket.MaxRow), 1),
+		settingRow("cols", T(language, "setting.cols", settings.Ticket.MaxCol), 1),
+		settingRow("perrow", T(language, "setting.perrow", settings.Ticket.MaxNumberOfRow), 1),
+		settingRow("tickets", T(language, "setting.tickets", settings.MaxTickets), 1),
+		settingRow("price", T(language, "setting.price", settings.TicketPrice), 5),
+		settingRow("minplayers", T(language, "setting.min_players", settings.MinPlayers), 1),
+		settingRow("maxplayers", T(language, "setting.max_players", playersLabel(language, settings.MaxPlayers)), 1),
+		settingRow("countdown", T(language, "setting.countdown", countdownSetting(language, settings.Countdown)), COUNTDOWN_STEP),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				T(language, "setting.layout", layoutLabel(language, settings.Ticket)),
+				fmt.Sprintf("%s;layout;0", QUERY_DATA_SETTING),
+			),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				T(language, "setting.style", rhymeStyleLabel(language, settings.RhymeStyle)),
+				fmt.Sprintf("%s;style;0", QUERY_DATA_SETTING),
+			),
+			tgbotapi.NewInlineKeyboardButtonData(
+				T(language, "setting.voice", onOffLabel(language, settings.Voice)),
+				fmt.Sprintf("%s;voice;0", QUERY_DATA_SETTING),
+			),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(T(language, ILB_SETTINGS_DONE), QUERY_DATA_SETTINGS),
+		),
+	)
+}
+
+func GeneratePlayingKeyboard(language string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(T(language, ILB_PAUSE), QUERY_DATA_PAUSE),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(T(language, ILB_STOP), QUERY_DATA_STOP),
+		),
+	)
+}
+
+func GeneratePausedKeyboard(language string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(T(language, ILB_RESUME), QUERY_DATA_RESUME),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(T(language, ILB_STOP), QUERY_DATA_STOP),
+		),
+	)
+}
 
 type Command interface {
 	openKeyboard(update *tgbotapi.Update)
@@ -80,10 +191,10 @@ type Command interface {
 }
 
 func (handler *MessageHandler) Keyboard(update *tgbotapi.Update) error {
-	switch update.Message.Text {
-	case OPEN_GAME:
+	switch text := update.Message.Text; {
+	case isButton(text, OPEN_GAME):
 		handler.openGame(update)
-	case HELP:
+	case isButton(text, HELP):
 		handler.help(update)
 	}
 
@@ -91,100 +202,172 @@ func (handler *MessageHandler) Keyboard(update *tgbotapi.Update) error {
 }
 
 func (handler *MessageHandler) InlineKeyboard(update *tgbotapi.Update) error {
-	// Respond to the callback query, telling Telegram to show the user
-	// a message with the data received.
-	callback := tgbotapi.NewCallback(update.CallbackQuery.ID, update.CallbackQuery.Data)
-	if _, err := handler.bot.Request(callback); err != nil {
-		panic(err)
-	}
-
-	msg := tgbotapi.NewMessage(update.CallbackQuery.Message.Chat.ID, "")
+	var err error
 	switch update.CallbackQuery.Data {
 	case QUERY_DATA_REGISTER:
-		if err := handler.register(update); err != nil {
-			msg.Text = fmt.Sprintf("Hey %s => %s", getQuerier(update.CallbackQuery.From), err.Error())
-		}
+		err = handler.register(update)
 	case QUERY_DATA_START:
-		if err := handler.start(update); err != nil {
-			msg.Text = fmt.Sprintf("Hey %s => %s", getQuerier(update.CallbackQuery.From), err.Error())
-		}
+		err = handler.start(update)
 	case QUERY_DATA_PAUSE:
-		if err := handler.pause(update); err != nil {
-			msg.Text = fmt.Sprintf("Hey %s => %s", getQuerier(update.CallbackQuery.From), err.Error())
-		}
+		err = handler.pause(update)
 	case QUERY_DATA_RESUME:
-		if err := handler.resume(update); err != nil {
-			msg.Text = fmt.Sprintf("Hey %s => %s", getQuerier(update.CallbackQuery.From), err.Error())
-		}
+		err = handler.resume(update)
 	case QUERY_DATA_STOP:
-		if err := handler.finish(update); err != nil {
-			msg.Text = fmt.Sprintf("Hey %s => %s", getQuerier(update.CallbackQuery.From), err.Error())
-		}
+		err = handler.finish(update)
+	case QUERY_DATA_BUY:
+		err = handler.buyTicket(update)
+	case QUERY_DATA_AUTO_WAIT:
+		err = handler.toggleAutoWait(update)
+	case QUERY_DATA_SETTINGS:
+		err = handler.toggleSettings(update)
 	default:
 		if strings.HasPrefix(update.CallbackQuery.Data, QUERY_DATA_CHECKED) {
-			if err := handler.queryNumerCheck(update); err != nil {
-				text := fmt.Sprintf("Hey %s => %s", getQuerier(update.CallbackQuery.From), err.Error())
-				msg := tgbotapi.NewMessage(update.CallbackQuery.Message.Chat.ID, text)
-				handler.sendMessage(msg)
-			}
+			err = handler.queryNumerCheck(update)
 		} else if strings.HasPrefix(update.CallbackQuery.Data, QUERY_DATA_WAIT) {
-			if err := handler.wait(update); err != nil {
-				msg.Text = fmt.Sprintf("Hey %s => %s", getQuerier(update.CallbackQuery.From), err.Error())
-			}
+			err = handler.wait(update)
+		} else if strings.HasPrefix(update.CallbackQuery.Data, QUERY_DATA_DAUB) {
+			err = handler.toggleDaubMode(update)
 		} else if strings.HasPrefix(update.CallbackQuery.Data, QUERY_DATA_BINGO) {
-			if err := handler.bingo(update); err != nil {
-				msg.Text = fmt.Sprintf("Hey %s => %s", getQuerier(update.CallbackQuery.From), err.Error())
-			}
+			err = handler.bingo(update)
+		} else if strings.HasPrefix(update.CallbackQuery.Data, QUERY_DATA_SETTING+";") {
+			err = handler.adjustSetting(update)
+		} else if strings.HasPrefix(update.CallbackQuery.Data, QUERY_DATA_UNSCHEDULE+";") {
+			err = handler.unschedule(update)
 		}
 	}
 
-	if len(msg.Text) > 0 {
-		handler.sendMessage(msg)
+	if err != errAnsweredLater {
+		handler.answerCallback(update.CallbackQuery, err)
 	}
 
 	return nil
 }
 
-func getQuerier(from *tgbotapi.User) string {
-	var name string
-	if len(from.UserName) > 5 {
-		name = fmt.Sprintf("@%s", from.UserName)
-	} else {
-		name = fmt.Sprintf("%s %s", from.FirstName, from.LastName)
+// errAnsweredLater is returned by the buttons which answer their callback
+// query themselves, once the press had its effect.
+var errAnsweredLater = errors.New("callback query is answered later")
+
+// answerCallback answers the callback query only to the presser, errors pop
+// up as an alert and confirmations as a short toast.
+func (handler *MessageHandler) answerCallback(query *tgbotapi.CallbackQuery, err error) {
+	language := handler.userLanguage(query.From, callbackChat(query))
+	callback := tgbotapi.NewCallback(query.ID, callbackConfirmation(language, query.Data))
+	if err != nil {
+		callback = tgbotapi.NewCallbackWithAlert(query.ID, errorMessage(language, err))
+	}
+	if _, err := handler.bot.Request(callback); err != nil {
+		log.Errorf("answer callback query error: %s", err.Error())
+	}
+}
+
+// callbackConfirmation is the toast shown after a successful press. Presses
+// which are announced in the group need no confirmation.
+func callbackConfirmation(language string, data string) string {
+	switch {
+	case data == QUERY_DATA_REGISTER:
+		return T(language, "callback.registered")
+	case data == QUERY_DATA_BUY:
+		return T(language, "callback.bought")
+	case strings.HasPrefix(data, QUERY_DATA_WAIT):
+		return T(language, "callback.waited")
+	case strings.HasPrefix(data, QUERY_DATA_UNSCHEDULE):
+		return T(language, "callback.unscheduled")
+	default:
+		return ""
+	}
+}
+
+// callbackChat is the chat of the lobby a button belongs to: the chat of
+// the message for the lobby buttons, the one in the callback data for the
+// buttons of a private ticket.
+func callbackChat(callback *tgbotapi.CallbackQuery) int64 {
+	if query, err := parseTicketQuery(callback.Data); err == nil {
+		return query.ChatId
+	}
+	if callback.Message != nil {
+		return callback.Message.Chat.ID
 	}
+	return 0
+}
 
-	return name
+// TicketQuery is the callback data of the buttons on a private ticket:
+// "<query>;<chat id>;<game id>;<ticket index>[;<row>-<col>]".
+type TicketQuery struct {
+	ChatId int64
+	GameId int
+	Ticket int
+	Row    int
+	Col    int
 }
 
-func GenerateTicketKeyboard(chatId int64, gameId int, board [][]int) tgbotapi.InlineKeyboardMarkup {
+func parseTicketQuery(data string) (TicketQuery, error) {
+	var query TicketQuery
+	arrData := strings.Split(data, ";")
+	if len(arrData) < 4 {
+		return query, UserError("ticket.outdated")
+	}
+
+	var err error
+	if query.ChatId, err = strconv.ParseInt(arrData[1], 10, 64); err != nil {
+		return query, UserError("ticket.invalid")
+	}
+	if query.GameId, err = strconv.Atoi(arrData[2]); err != nil {
+		return query, UserError("ticket.invalid")
+	}
+	if query.Ticket, err = strconv.Atoi(arrData[3]); err != nil {
+		return query, UserError("ticket.invalid")
+	}
+	if len(arrData) > 4 {
+		coordinate := strings.Split(arrData[4], "-")
+		if len(coordinate) != 2 {
+			return query, UserError("ticket.invalid_cell")
+		}
+		query.Row, _ = strconv.Atoi(coordinate[0])
+		query.Col, _ = strconv.Atoi(coordinate[1])
+	}
+
+	return query, nil
+}
+
+// GenerateTicketKeyboard shows the numbers of a ticket as buttons to daub
+// them, the daubed ones checked and, in hybrid mode, the called ones which
+// still need a tap highlighted.
+func GenerateTicketKeyboard(language string, chatId int64, gameId int, ticketIndex int, daubMode string, cells TicketCells) tgbotapi.InlineKeyboardMarkup {
 	var keyboard [][]tgbotapi.InlineKeyboardButton
-	for i, r := range board {
+	for i, r := range cells.Board {
+		// wide tickets only show their numbers to fit in a keyboard row
+		compact := len(r) > MAX_KEYBOARD_COLUMNS
+
 		var row []tgbotapi.InlineKeyboardButton
 		for j, number := range r {
-			var label string
-			var data string
-
-			if number > 0 {
-				label = fmt.Sprintf("%d", number)
-				data = " "
-			} else {
-				data = fmt.Sprintf("%s;%d;%d;%d-%d", QUERY_DATA_CHECKED, chatId, gameId, i, j)
-				if number < 0 {
-					label = "✅"
-				} else {
-					label = " "
+			if number <= 0 {
+				if !compact {
+					row = append(row, tgbotapi.NewInlineKeyboardButtonData(" ", " "))
 				}
+				continue
 			}
 
+			label := fmt.Sprintf("%d", number)
+			switch {
+			case cells.Daubed[number]:
+				label = "✅"
+			case cells.Callable[number]:
+				label = fmt.Sprintf("🔔%d", number)
+			}
+			data := fmt.Sprintf("%s;%d;%d;%d;%d-%d", QUERY_DATA_CHECKED, chatId, gameId, ticketIndex, i, j)
 			row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, data))
 		}
 		keyboard = append(keyboard, row)
 	}
 	keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData(ILB_WAIT, fmt.Sprintf("%s;%d;%d", QUERY_DATA_WAIT, chatId, gameId)),
+		tgbotapi.NewInlineKeyboardButtonData(T(language, ILB_WAIT), fmt.Sprintf("%s;%d;%d;%d", QUERY_DATA_WAIT, chatId, gameId, ticketIndex)),
+		tgbotapi.NewInlineKeyboardButtonData(
+			T(language, "button.daub", daubModeLabel(language, daubMode)),
+			fmt.Sprintf("%s;%d;%d;%d", QUERY_DATA_DAUB, chatId, gameId, ticketIndex),
+		),
 	))
 	keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData(ILB_BINGO, fmt.Sprintf("%s;%d;%d", QUERY_DATA_BINGO, chatId, gameId)),
+		tgbotapi.NewInlineKeyboardButtonData(T(language, ILB_BINGO), fmt.Sprintf("%s;%d;%d;%d", QUERY_DATA_BINGO, chatId, gameId, ticketIndex)),
 	))
 
 	return tgbotapi.InlineKeyboardMarkup{
@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+func lastAnswer(t *testing.T, server *telegramtest.Server) telegramtest.Request {
+	t.Helper()
+
+	answers := server.Requests("answerCallbackQuery")
+	if len(answers) == 0 {
+		t.Fatal("callback query was not answered")
+	}
+	return answers[len(answers)-1]
+}
+
+func TestCallbackAnswers(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1003)
+	host := telegramtest.User(10, "lotovn_host")
+	player := telegramtest.User(11, "player_teo")
+	blocked := telegramtest.User(12, "player_blocked")
+
+	server.Handle("sendMessage", func(req telegramtest.Request) (interface{}, error) {
+		if req.ChatId() == blocked.ID {
+			return nil, &tgbotapi.Error{Code: http.StatusForbidden, Message: "Forbidden: bot can't initiate conversation with a user"}
+		}
+		return tgbotapi.Message{MessageID: 100, Chat: &tgbotapi.Chat{ID: req.ChatId()}}, nil
+	})
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame"))
+	game := handler.lobbies.Get(group.ID)
+	server.Reset()
+
+	// confirmations are a toast for the presser only
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	answer := lastAnswer(t, server)
+	if answer.Params.Get("show_alert") == "true" || answer.Text() != callbackConfirmation(DEFAULT_LANGUAGE, QUERY_DATA_REGISTER) {
+		t.Fatalf("unexpected register answer %v", answer.Params)
+	}
+
+	// user errors pop up as an alert, nothing is posted to the group
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	answer = lastAnswer(t, server)
+	if answer.Params.Get("show_alert") != "true" || !strings.Contains(answer.Text(), "Báo danh rồi") {
+		t.Fatalf("unexpected duplicate register answer %v", answer.Params)
+	}
+
+	// players who never talked to the bot are told how to get their ticket
+	Dispatch(handler, telegramtest.CallbackUpdate(group, blocked, game.GameId, QUERY_DATA_REGISTER))
+	answer = lastAnswer(t, server)
+	if answer.Params.Get("show_alert") != "true" || !strings.Contains(answer.Text(), "Start") {
+		t.Fatalf("unexpected blocked register answer %v", answer.Params)
+	}
+	if game.players[blocked.ID] != nil {
+		t.Fatal("player without a ticket was registered")
+	}
+
+	// the raw callback data never reaches the user
+	data := fmt.Sprintf("%s;%d;%d;0;0-0", QUERY_DATA_CHECKED, group.ID, game.GameId)
+	Dispatch(handler, telegramtest.CallbackUpdate(telegramtest.Private(player), player, 100, data))
+	for _, req := range server.Requests("answerCallbackQuery") {
+		if strings.Contains(req.Text(), "query_") {
+			t.Fatalf("callback data leaked: %s", req.Text())
+		}
+	}
+
+	for _, req := range server.Sent(group.ID) {
+		if strings.HasPrefix(req.Text(), "Hey ") {
+			t.Fatalf("error was posted to the group: %s", req.Text())
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	if text := errorMessage(DEFAULT_LANGUAGE, UserError("daub.not_called", 3)); text != "Số 3 chưa được gọi!" {
+		t.Fatalf("unexpected user message %q", text)
+	}
+	if text := errorMessage("en", UserError("daub.not_called", 3)); text != "Number 3 has not been called!" {
+		t.Fatalf("unexpected translated message %q", text)
+	}
+	internal := fmt.Errorf("bolt: database not open")
+	if text := errorMessage(DEFAULT_LANGUAGE, internal); text != T(DEFAULT_LANGUAGE, INTERNAL_ERROR_MESSAGE) {
+		t.Fatalf("internal failure leaked %q", text)
+	}
+	if botErr := AsBotError(InternalError(internal)); botErr.Kind != ERROR_INTERNAL || botErr.Unwrap() != internal {
+		t.Fatal("internal error lost its cause")
+	}
+}
+
+func TestOutdatedTicketKeyboard(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1008)
+	host := telegramtest.User(10, "lotovn_host")
+	player := telegramtest.User(11, "player_teo")
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame"))
+	old := handler.lobbies.Get(group.ID)
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, old.GameId, QUERY_DATA_REGISTER))
+	buttons := ticketButtons(t, server, player.ID)
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, old.GameId, QUERY_DATA_STOP))
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 2, "/newgame"))
+	game := handler.lobbies.Get(group.ID)
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_START))
+	defer Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_STOP))
+
+	game.lock.Lock()
+	daub := game.players[player.ID].Daub
+	game.lock.Unlock()
+
+	// the keyboard of the earlier game does not play the current ticket
+	private := telegramtest.Private(player)
+	for _, prefix := range []string{QUERY_DATA_WAIT, QUERY_DATA_BINGO, QUERY_DATA_CHECKED, QUERY_DATA_DAUB} {
+		data := findButton(buttons, prefix)
+		if data == "" {
+			t.Fatalf("ticket has no %s button", prefix)
+		}
+		Dispatch(handler, telegramtest.CallbackUpdate(private, player, 100, data))
+		answer := lastAnswer(t, server)
+		if answer.Params.Get("show_alert") != "true" || answer.Text() != T(DEFAULT_LANGUAGE, "ticket.outdated") {
+			t.Fatalf("%s: unexpected answer %v", prefix, answer.Params)
+		}
+	}
+
+	game.lock.Lock()
+	defer game.lock.Unlock()
+	if current := game.players[player.ID]; current.Wait != 0 || current.Daub != daub {
+		t.Fatalf("old keyboard changed the current ticket %+v", current)
+	}
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// LanguageCache keeps the languages chosen with /lang by chats and users.
+// A missing entry is cached too, so most lookups never touch the storage.
+type LanguageCache struct {
+	storage Storage
+	entries map[string]string
+
+	lock sync.Mutex
+}
+
+func NewLanguageCache(storage Storage) *LanguageCache {
+	return &LanguageCache{
+		storage: storage,
+		entries: make(map[string]string),
+	}
+}
+
+func chatLanguageKey(chatId int64) string {
+	return fmt.Sprintf("chat:%d", chatId)
+}
+
+func userLanguageKey(userId int64) string {
+	return fmt.Sprintf("user:%d", userId)
+}
+
+func (cache *LanguageCache) get(key string) string {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if language, ok := cache.entries[key]; ok {
+		return language
+	}
+	language, err := cache.storage.LoadLanguage(key)
+	if err != nil {
+		log.Errorf("load language of %s error: %s", key, err.Error())
+		return ""
+	}
+	cache.entries[key] = language
+	return language
+}
+
+func (cache *LanguageCache) set(key string, language string) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if err := cache.storage.SaveLanguage(key, language); err != nil {
+		return err
+	}
+	cache.entries[key] = language
+	return nil
+}
+
+// chatLanguage is the language of the messages posted in the chat.
+func (handler *MessageHandler) chatLanguage(chatId int64) string {
+	if language := handler.languages.get(chatLanguageKey(chatId)); len(language) > 0 {
+		return language
+	}
+	return DEFAULT_LANGUAGE
+}
+
+// userLanguage is the language of what only the user reads: the tickets,
+// alerts and private chats. It is the language chosen with /lang, else the
+// one of the Telegram app when it is shipped, else the one of the chat.
+func (handler *MessageHandler) userLanguage(user *tgbotapi.User, chatId int64) string {
+	if user == nil {
+		return handler.chatLanguage(chatId)
+	}
+	if language := handler.languages.get(userLanguageKey(user.ID)); len(language) > 0 {
+		return language
+	}
+	// Telegram sends IETF tags like "en-US"
+	if language := strings.ToLower(strings.SplitN(user.LanguageCode, "-", 2)[0]); messages.supports(language) {
+		return language
+	}
+	return handler.chatLanguage(chatId)
+}
+
+// playerLanguage is the language of the private messages of a player.
+func (handler *MessageHandler) playerLanguage(player *Player, chatId int64) string {
+	return handler.userLanguage(&tgbotapi.User{ID: player.Id, LanguageCode: player.LanguageCode}, chatId)
+}
+
+// setLanguage is /lang: in a group the administrators choose the language
+// of the group, in private everyone chooses their own.
+func (handler *MessageHandler) setLanguage(update *tgbotapi.Update) (string, error) {
+	chatId := update.Message.Chat.ID
+	from := update.Message.From
+	language := strings.ToLower(strings.TrimSpace(update.Message.CommandArguments()))
+	current := handler.userLanguage(from, chatId)
+	if !update.Message.Chat.IsPrivate() {
+		current = handler.chatLanguage(chatId)
+	}
+
+	if len(language) == 0 {
+		return T(current, "lang.current", current, strings.Join(messages.Languages(), ", "), CMD_LANG), nil
+	}
+	if !messages.supports(language) {
+		return "", UserError("lang.unsupported", language, strings.Join(messages.Languages(), ", "))
+	}
+
+	if update.Message.Chat.IsPrivate() {
+		if err := handler.languages.set(userLanguageKey(from.ID), language); err != nil {
+			return "", InternalError(err)
+		}
+		return T(language, "lang.user_set"), nil
+	}
+
+	if !handler.admins.IsAdmin(chatId, from.ID) {
+		return "", PermissionError("lang.admin_only")
+	}
+	if err := handler.languages.set(chatLanguageKey(chatId), language); err != nil {
+		return "", InternalError(err)
+	}
+	return T(language, "lang.chat_set"), nil
+}
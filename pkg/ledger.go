@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// MINT_ACCOUNT issues the allowances and top-ups. System accounts are
+	// the only ones allowed to go below zero.
+	MINT_ACCOUNT   = "system:mint"
+	SYSTEM_ACCOUNT = "system:"
+
+	TX_ALLOWANCE = "allowance"
+	TX_TOPUP     = "topup"
+	TX_TICKET    = "ticket"
+	TX_REFUND    = "refund"
+	TX_PRIZE     = "prize"
+)
+
+var (
+	ErrDuplicateTransaction = errors.New("transaction already posted")
+	ErrInsufficientFunds    = errors.New("insufficient funds")
+)
+
+// Transaction is a double-entry record: its entries move coins between
+// accounts and always sum to zero, so every coin can be traced back to the
+// mint. The id is unique and makes posting idempotent.
+type Transaction struct {
+	Id      string
+	Kind    string
+	Time    time.Time
+	Memo    string
+	Entries []Entry
+}
+
+// Entry credits the account with a positive amount and debits it with a
+// negative one.
+type Entry struct {
+	Account string
+	Amount  int64
+}
+
+func (transaction Transaction) validate() error {
+	if len(transaction.Id) == 0 {
+		return fmt.Errorf("transaction without id")
+	}
+	if len(transaction.Entries) < 2 {
+		return fmt.Errorf("transaction %s needs at least two entries", transaction.Id)
+	}
+
+	var sum int64
+	for _, entry := range transaction.Entries {
+		if len(entry.Account) == 0 {
+			return fmt.Errorf("transaction %s has an entry without account", transaction.Id)
+		}
+		sum += entry.Amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("transaction %s is unbalanced by %d", transaction.Id, sum)
+	}
+
+	return nil
+}
+
+// transfer moves amount from one account to another.
+func transfer(id string, kind string, memo string, from string, to string, amount int64) Transaction {
+	return Transaction{
+		Id:   id,
+		Kind: kind,
+		Time: time.Now(),
+		Memo: memo,
+		Entries: []Entry{
+			{Account: from, Amount: -amount},
+			{Account: to, Amount: amount},
+		},
+	}
+}
+
+func isSystemAccount(account string) bool {
+	return strings.HasPrefix(account, SYSTEM_ACCOUNT)
+}
+
+func walletAccount(chatId int64, userId int64) string {
+	return fmt.Sprintf("wallet:%d:%d", chatId, userId)
+}
+
+func potAccount(chatId int64, gameId int) string {
+	return fmt.Sprintf("pot:%d:%d", chatId, gameId)
+}
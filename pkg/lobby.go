@@ -2,37 +2,128 @@ package pkg
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/apex/log"
 	"github.com/aquasecurity/table"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-var GameInChatMap = make(map[int64]*Lobby)
-
 type Lobby struct {
-	ChatId    int64
-	GameId    int
+	ChatId int64
+	GameId int
+	// HostId is the opener of the lobby ("nhà cái"), who controls the game
+	// together with the chat administrators.
+	HostId    int64
+	HostName  string
 	players   map[int64]*Player
+	winners   []*Player
 	lifecycle Lifecycle
+	// autoWait announces players who are one number away from a row
+	// after each release instead of relying on the 💣 Hò button only.
+	autoWait bool
+	// showSettings swaps the lobby keyboard for the settings menu
+	showSettings bool
+	maxTickets   int
+	ticketPrice  int64
+	// rhymeStyle is how released numbers are called, see RHYME_STYLE_*
+	rhymeStyle string
+	// voice sends every released number as a voice message too
+	voice bool
+	// refresh are the tickets waiting for their keyboard to be edited
+	refresh []ticketRef
+	// minPlayers and startAt start the draw on their own once enough
+	// players registered or the countdown runs out, see watchRegistration.
+	// maxPlayers closes the registration.
+	minPlayers int
+	maxPlayers int
+	countdown  time.Duration
+	startAt    time.Time
+	// shownCountdown is the countdown of the lobby message, watching
+	// tells that the registration is watched
+	shownCountdown string
+	watching       bool
+
+	// lock guards the lobby, its players and tickets. Handlers hold it for
+	// the whole update, the release listener for every released number.
+	lock sync.Mutex
+}
+
+func (lobby *Lobby) isWinner(player *Player) bool {
+	for _, winner := range lobby.winners {
+		if winner.Id == player.Id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (lobby *Lobby) settings() GameSettings {
+	return GameSettings{
+		Interval:    lobby.lifecycle.interval(),
+		Ticket:      lobby.lifecycle.ticketConfig(),
+		MaxTickets:  lobby.maxTickets,
+		TicketPrice: lobby.ticketPrice,
+		RhymeStyle:  lobby.rhymeStyle,
+		Voice:       lobby.voice,
+		MinPlayers:  lobby.minPlayers,
+		MaxPlayers:  lobby.maxPlayers,
+		Countdown:   lobby.countdown,
+	}
 }
 
-func (lobby *Lobby) renderPlayerList() string {
+func (lobby *Lobby) playerTicket(userId int64, index int) (*Player, *Ticket, error) {
+	player := lobby.players[userId]
+	if player == nil {
+		return nil, nil, UserError("game.not_registered")
+	}
+	if index < 0 || index >= len(player.Tickets) {
+		return nil, nil, UserError("ticket.not_found")
+	}
+
+	return player, player.Tickets[index], nil
+}
+
+func (lobby *Lobby) renderPlayerList(language string) string {
+	headers := []string{
+		T(language, "table.index"),
+		T(language, "table.username"),
+		T(language, "players.header.tickets"),
+		T(language, "players.header.waits"),
+	}
+	if lobby.autoWait {
+		headers = append(headers, T(language, "players.header.waiting"))
+	}
+
 	buf := new(bytes.Buffer)
 	tb := table.New(buf)
-	tb.SetHeaders("STT", "Username", "Mã vé", "Hò")
+	tb.SetHeaders(headers...)
 
 	i := 1
 	for _, player := range lobby.players {
-		tb.AddRow(
+		row := []string{
 			fmt.Sprint(i),
 			fmt.Sprintf("%s", player.Username),
-			fmt.Sprint(player.Ticket.Id.ID()),
+			fmt.Sprint(len(player.Tickets)),
 			fmt.Sprint(player.Wait),
-		)
+		}
+		if lobby.autoWait {
+			var waiting []string
+			for _, number := range player.Waiting {
+				waiting = append(waiting, fmt.Sprint(number))
+			}
+			row = append(row, strings.Join(waiting, ","))
+		}
+		tb.AddRow(row...)
 		i++
 	}
 	tb.Render()
@@ -54,55 +145,35 @@ type Player struct {
 	Username string
 	Name     string
 	Wait     int
-	Ticket   *Ticket
+	// FalseBingo counts the rejected "Kinh" claims of the player.
+	FalseBingo int
+	// Waiting holds the numbers the tickets are currently one away for.
+	Waiting []int
+	Tickets []*Ticket
+	// Paid is what the player put into the prize pool of the lobby.
+	Paid int64
+	// WonAt is how many numbers were drawn when the Kinh was validated.
+	WonAt int
+	// Daub is how the tickets of the player are daubed, see DAUB_*
+	Daub string
+	// LanguageCode is the language of the Telegram app of the player, the
+	// fallback of the language of the tickets.
+	LanguageCode string
 }
 
 func (handler *MessageHandler) openGame(update *tgbotapi.Update) error {
-	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "")
-	chatId := update.Message.Chat.ID
+	return handler.newGame(update, DefaultGameSettings())
+}
 
-	var currentGame = GameInChatMap[chatId]
-	if currentGame == nil {
-		msg.ReplyMarkup = OpenGameInlineKeyboard
-		msg.Text = "🎯 Chào mừng bà con cô bác đến với Đoàn Lô Tô Ted Vo!"
-		msg.ParseMode = "HTML"
-		respMsg := handler.sendMessage(msg)
-		currentGame = &Lobby{
-			ChatId:  chatId,
-			GameId:  respMsg.MessageID,
-			players: make(map[int64]*Player),
-			lifecycle: NewGame(
-				time.Second*10,
-				TicketConifg{
-					MaxNumer:       70,
-					MaxRow:         9,
-					MaxCol:         8,
-					MaxNumberOfRow: 4,
-				},
-			),
-		}
-		GameInChatMap[chatId] = currentGame
-
-		text, _ := Parse("./config/game.html",
-			struct {
-				GameId int
-				List   string
-			}{
-				GameId: currentGame.GameId,
-				List:   currentGame.renderPlayerList(),
-			})
-		editMessage := tgbotapi.NewEditMessageTextAndMarkup(
-			chatId,
-			respMsg.MessageID,
-			text,
-			OpenGameInlineKeyboard,
-		)
-		editMessage.ParseMode = "HTML"
+func (handler *MessageHandler) newGame(update *tgbotapi.Update, settings GameSettings) error {
+	chatId := update.Message.Chat.ID
 
-		handler.editMessage(editMessage)
-	} else {
-		msg.Text = "Ủa alo? Game hiện tại chưa kết thúc mà, nè..."
+	currentGame, created := handler.openLobby(handler.newLobby(chatId, update.Message.From, settings))
+	if !created {
+		msg := tgbotapi.NewMessage(chatId, T(handler.chatLanguage(chatId), "game.still_running"))
+		currentGame.lock.Lock()
 		msg.ReplyToMessageID = currentGame.GameId
+		currentGame.lock.Unlock()
 		handler.sendMessage(msg)
 	}
 
@@ -111,115 +182,518 @@ func (handler *MessageHandler) openGame(update *tgbotapi.Update) error {
 	return nil
 }
 
+func (handler *MessageHandler) newLobby(chatId int64, host *tgbotapi.User, settings GameSettings) *Lobby {
+	lobby := &Lobby{
+		ChatId:      chatId,
+		HostId:      host.ID,
+		HostName:    host.UserName,
+		players:     make(map[int64]*Player),
+		autoWait:    true,
+		maxTickets:  settings.MaxTickets,
+		ticketPrice: settings.TicketPrice,
+		rhymeStyle:  settings.RhymeStyle,
+		voice:       settings.Voice && handler.voices != nil,
+		minPlayers:  settings.MinPlayers,
+		maxPlayers:  settings.MaxPlayers,
+		countdown:   settings.Countdown,
+		lifecycle:   NewGame(settings.Interval, settings.Ticket),
+	}
+	if settings.Countdown > 0 {
+		lobby.startAt = time.Now().Add(settings.Countdown)
+	}
+
+	return lobby
+}
+
+// openLobby posts the lobby message of the new lobby unless the chat
+// already has a game, which is returned instead. The lobby is only playable
+// once its message is posted, see lobbyPosted.
+func (handler *MessageHandler) openLobby(lobby *Lobby) (*Lobby, bool) {
+	lobby.lock.Lock()
+	currentGame, created := handler.lobbies.Register(lobby)
+	if !created {
+		lobby.lock.Unlock()
+		return currentGame, false
+	}
+
+	language := handler.chatLanguage(lobby.ChatId)
+	msg := tgbotapi.NewMessage(lobby.ChatId, T(language, "lobby.welcome"))
+	msg.ReplyMarkup = GenerateOpenGameKeyboard(language, lobby.autoWait, lobby.maxTickets)
+	msg.ParseMode = "HTML"
+	lobby.lock.Unlock()
+
+	handler.postThen(msg, func(message tgbotapi.Message, err error) {
+		handler.lobbyPosted(lobby, message, err)
+	})
+
+	return lobby, true
+}
+
+// lobbyPosted takes the id of the lobby message as the game id, the lobby
+// is given up when the message could not be posted.
+func (handler *MessageHandler) lobbyPosted(lobby *Lobby, message tgbotapi.Message, err error) {
+	lobby.lock.Lock()
+	defer lobby.lock.Unlock()
+
+	if err != nil || message.MessageID == 0 {
+		log.Errorf("send lobby message to chat %d failed: %v", lobby.ChatId, err)
+		lobby.lifecycle.stop()
+		handler.lobbies.Remove(lobby)
+		return
+	}
+	lobby.GameId = message.MessageID
+	handler.logEvent(lobby, GameEvent{Kind: EVENT_OPENED, UserId: lobby.HostId, Username: lobby.HostName})
+	handler.saveGame(lobby)
+	handler.updateListPlayerState(lobby)
+	handler.watchLobby(lobby)
+}
+
 func (handler *MessageHandler) help(update *tgbotapi.Update) error {
 
 	return nil
 }
 
 func (handler *MessageHandler) register(update *tgbotapi.Update) error {
+	delivery, err := handler.joinLobby(update)
+	if err != nil {
+		return err
+	}
+	handler.deliverTicket(delivery)
+
+	return errAnsweredLater
+}
+
+// joinLobby adds the presser to the lobby with a first ticket, which is
+// still to be delivered.
+func (handler *MessageHandler) joinLobby(update *tgbotapi.Update) (*ticketDelivery, error) {
 	chatId := update.CallbackQuery.Message.Chat.ID
-	var currentGame = GameInChatMap[chatId]
-	if currentGame == nil {
-		return fmt.Errorf("Game không tồn tại. Vui lòng mở báo danh!")
+	currentGame, err := handler.lockGame(chatId)
+	if err != nil {
+		return nil, err
 	}
+	defer currentGame.lock.Unlock()
 
-	if currentGame.lifecycle.isStarted() {
-		return fmt.Errorf("Game đã bắt đầu. Hãy đợi lượt kế tiếp!")
+	if currentGame.lifecycle.status() != LOBBY {
+		return nil, UserError("game.already_started_wait")
 	}
 
 	registor := update.CallbackQuery.From
 	if len(registor.UserName) < 5 {
-		return fmt.Errorf("Vui lòng cập nhật `username` trước khi báo danh!")
+		return nil, UserError("register.no_username")
 	}
 
 	if existed := currentGame.players[registor.ID]; existed != nil {
-		return fmt.Errorf("@%s > Báo danh rồi thì ngồi im đi nào!", existed.Username)
+		return nil, UserError("register.already", existed.Username)
+	}
+	if currentGame.maxPlayers > 0 && len(currentGame.players) >= currentGame.maxPlayers {
+		return nil, UserError("register.full", currentGame.maxPlayers)
 	}
 
 	player := &Player{
-		Id:       registor.ID,
-		Username: registor.UserName,
-		Name:     fmt.Sprintf("%s %s", registor.FirstName, registor.LastName),
-		Ticket:   NewTicket(currentGame.GameId, currentGame.lifecycle.ticketConfig()),
+		Id:           registor.ID,
+		Username:     registor.UserName,
+		Name:         fmt.Sprintf("%s %s", registor.FirstName, registor.LastName),
+		Daub:         DAUB_MANUAL,
+		LanguageCode: registor.LanguageCode,
 	}
+	delivery, err := handler.addTicket(currentGame, player, update.CallbackQuery)
+	if err != nil {
+		return nil, err
+	}
+	delivery.joined = true
 	currentGame.players[registor.ID] = player
 
-	// send ticket for player in Private
-	ticketText, _ := Parse("./config/ticket.html",
-		struct {
-			GameId   int
-			TicketId uint32
-			Data     string
-		}{
-			GameId:   currentGame.GameId,
-			TicketId: player.Ticket.Id.ID(),
-			Data:     "",
-		})
-	msgPlayer := tgbotapi.NewMessage(
-		player.Id,
-		ticketText,
-	)
-	msgPlayer.ParseMode = "HTML"
-	msgPlayer.ReplyMarkup = GenerateTicketKeyboard(currentGame.ChatId, currentGame.GameId, player.Ticket.board)
-	resMsg := handler.sendMessage(msgPlayer)
-	// tracked msg of ticket send to player for clear when game end
-	player.Ticket.MessageId = resMsg.MessageID
+	handler.saveGame(currentGame)
+	handler.updateListPlayerState(currentGame)
 
+	return delivery, nil
+}
+
+func (handler *MessageHandler) buyTicket(update *tgbotapi.Update) error {
+	delivery, err := handler.addPlayerTicket(update)
+	if err != nil {
+		return err
+	}
+	handler.deliverTicket(delivery)
+
+	return errAnsweredLater
+}
+
+// addPlayerTicket adds another ticket, still to be delivered, to the
+// presser.
+func (handler *MessageHandler) addPlayerTicket(update *tgbotapi.Update) (*ticketDelivery, error) {
+	chatId := update.CallbackQuery.Message.Chat.ID
+	currentGame, err := handler.lockGame(chatId)
+	if err != nil {
+		return nil, err
+	}
+	defer currentGame.lock.Unlock()
+
+	if currentGame.lifecycle.status() != LOBBY {
+		return nil, UserError("game.already_started_wait")
+	}
+
+	player := currentGame.players[update.CallbackQuery.From.ID]
+	if player == nil {
+		return nil, UserError("buy.not_registered")
+	}
+	if len(player.Tickets) >= currentGame.maxTickets {
+		return nil, UserError("buy.limit", player.Username, currentGame.maxTickets)
+	}
+
+	delivery, err := handler.addTicket(currentGame, player, update.CallbackQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	handler.saveGame(currentGame)
 	handler.updateListPlayerState(currentGame)
 
-	return nil
+	return delivery, nil
+}
+
+// ticketDelivery is a ticket on its way to the private chat of its player.
+type ticketDelivery struct {
+	game    *Lobby
+	player  *Player
+	ticket  *Ticket
+	index   int
+	message tgbotapi.MessageConfig
+	// query is the press which bought the ticket, it is answered once the
+	// ticket was delivered
+	query *tgbotapi.CallbackQuery
+	// joined tells that the ticket is the first one of the player
+	joined bool
+}
+
+// addTicket charges the ticket price and generates a new ticket for the
+// locked lobby, with its message for the private chat of the player. The
+// ticket is kept at once so the limits count it, its message id is only
+// known once it was delivered.
+func (handler *MessageHandler) addTicket(game *Lobby, player *Player, query *tgbotapi.CallbackQuery) (*ticketDelivery, error) {
+	ticket := NewTicket(game.GameId, game.lifecycle.ticketConfig())
+	index := len(player.Tickets)
+	language := handler.playerLanguage(player, game.ChatId)
+	text, err := handler.ticketText(language, ticket, index)
+	if err != nil {
+		return nil, InternalError(err)
+	}
+	if err := handler.chargeTicket(game, player, index, ticket); err != nil {
+		return nil, err
+	}
+	player.Tickets = append(player.Tickets, ticket)
+
+	msgPlayer := tgbotapi.NewMessage(player.Id, text)
+	msgPlayer.ParseMode = "HTML"
+	msgPlayer.ReplyMarkup = GenerateTicketKeyboard(language, game.ChatId, game.GameId, index, player.Daub, ticket.cells(nil, player.Daub))
+
+	return &ticketDelivery{game: game, player: player, ticket: ticket, index: index, message: msgPlayer, query: query}, nil
+}
+
+// deliverTicket sends the ticket in private, without the lobby lock. The
+// press which bought it is answered once it was delivered.
+func (handler *MessageHandler) deliverTicket(delivery *ticketDelivery) {
+	handler.postThen(delivery.message, func(message tgbotapi.Message, err error) {
+		err = handler.ticketDelivered(delivery, message, err)
+		handler.answerCallback(delivery.query, err)
+	})
+}
+
+// ticketDelivered tracks the message of a delivered ticket, to clear it
+// when the game ends. A ticket which could not be delivered is taken back
+// and refunded, with the player when it was their first one. The tickets of
+// a player go through the same private chat in order, so the later ones of
+// a blocked chat fail as well.
+func (handler *MessageHandler) ticketDelivered(delivery *ticketDelivery, message tgbotapi.Message, err error) error {
+	game, player, ticket := delivery.game, delivery.player, delivery.ticket
+	game.lock.Lock()
+	defer game.lock.Unlock()
+
+	// a closed lobby refunded the stakes already
+	closed := game.lifecycle.status() == STOPPED
+	if err == nil {
+		ticket.MessageId = message.MessageID
+		if closed {
+			// the ticket came too late, its keyboard has nothing to play
+			editMessage := tgbotapi.NewEditMessageText(player.Id, message.MessageID, delivery.message.Text)
+			editMessage.ParseMode = "HTML"
+			handler.editMessage(editMessage)
+			return nil
+		}
+		if delivery.joined {
+			handler.logEvent(game, playerEvent(EVENT_JOINED, player, -1, 0))
+		} else {
+			handler.logEvent(game, playerEvent(EVENT_TICKET, player, delivery.index, 0))
+		}
+		handler.saveGame(game)
+		if delivery.joined && game.autoStarts() {
+			handler.checkRegistration(game)
+		}
+		return nil
+	}
+
+	if !closed {
+		for i, kept := range player.Tickets {
+			if kept == ticket {
+				player.Tickets = append(player.Tickets[:i], player.Tickets[i+1:]...)
+				break
+			}
+		}
+		if refundErr := handler.refundTicket(game, player, delivery.index, ticket); refundErr != nil {
+			log.Errorf("refund ticket of %d error: %s", player.Id, refundErr.Error())
+		}
+		if len(player.Tickets) == 0 && game.players[player.Id] == player {
+			delete(game.players, player.Id)
+		}
+		handler.saveGame(game)
+		handler.updateListPlayerState(game)
+	}
+
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+		// bots cannot start a private chat on their own
+		return UserError("ticket.blocked", player.Username)
+	}
+	return InternalError(fmt.Errorf("send ticket to %d: %w", player.Id, err))
+}
+
+func (handler *MessageHandler) ticketText(language string, ticket *Ticket, index int) (string, error) {
+	return handler.templates.Render(language, TEMPLATE_TICKET, TicketView{
+		GameId:   ticket.GameId,
+		TicketId: ticket.Id.ID(),
+		Number:   index + 1,
+	})
 }
 
 func (handler *MessageHandler) start(update *tgbotapi.Update) error {
 	chatId := update.CallbackQuery.Message.Chat.ID
-	var currentGame = GameInChatMap[chatId]
-	if currentGame == nil {
-		return fmt.Errorf("Game không tồn tại. Vui lòng mở báo danh!")
+	currentGame, err := handler.lockControlledGame(chatId, update.CallbackQuery.From)
+	if err != nil {
+		return err
 	}
+	defer currentGame.lock.Unlock()
 
-	if currentGame.lifecycle.isStarted() {
-		return fmt.Errorf("Game đã bắt đầu rồi mà.")
+	if currentGame.lifecycle.status() != LOBBY {
+		return UserError("game.already_started")
 	}
+	handler.startGame(currentGame, userEvent(EVENT_STARTED, update.CallbackQuery.From))
 
-	msg := tgbotapi.NewMessage(chatId, "Game bắt đầu!")
-	msg.ReplyToMessageID = currentGame.GameId
+	return nil
+}
+
+// startGame starts the draw of the locked lobby, the event tells who
+// started it.
+func (handler *MessageHandler) startGame(game *Lobby, event GameEvent) {
+	msg := tgbotapi.NewMessage(game.ChatId, T(handler.chatLanguage(game.ChatId), "game.started"))
+	msg.ReplyToMessageID = game.GameId
 	handler.sendMessage(msg)
 
-	releaseChanel := currentGame.lifecycle.start()
-	go func(chatId int64, c chan int, handler *MessageHandler) {
-		for {
-			res, ok := <-c
-			if ok == false {
-				break
-			}
-			currentGame.lifecycle.addResultSeed(res)
-			handler.sendMessage(tgbotapi.NewMessage(
-				chatId,
-				fmt.Sprintf("Số %d", res)))
+	game.lifecycle.start()
+	handler.logEvent(game, event)
+	go handler.listenRelease(game)
+
+	handler.saveGame(game)
+	handler.updateListPlayerState(game)
+}
+
+// listenRelease announces every number the game releases until the game
+// is stopped, and refreshes the daubed tickets in batches meanwhile.
+func (handler *MessageHandler) listenRelease(game *Lobby) {
+	refresh := time.NewTicker(DAUB_FLUSH_INTERVAL)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case <-game.lifecycle.quitChanel():
+			return
+		case res := <-game.lifecycle.releaseChanel():
+			handler.release(game, res)
+		case <-refresh.C:
+			handler.flushTicketRefresh(game)
 		}
-	}(chatId, releaseChanel, handler)
+	}
+}
+
+func (handler *MessageHandler) release(game *Lobby, number int) {
+	game.lock.Lock()
+	defer game.lock.Unlock()
+
+	if !game.lifecycle.addResultSeed(number) {
+		return
+	}
+	handler.logEvent(game, GameEvent{Kind: EVENT_DRAWN, Number: number})
+	handler.sendMessage(tgbotapi.NewMessage(game.ChatId, handler.announcement(game, number)))
+	handler.sendVoice(game, number)
+	handler.daubReleased(game, number)
+
+	if game.autoWait {
+		handler.announceWaiting(game)
+	}
+
+	handler.saveGame(game)
+}
+
+func (handler *MessageHandler) toggleAutoWait(update *tgbotapi.Update) error {
+	chatId := update.CallbackQuery.Message.Chat.ID
+	currentGame, err := handler.lockControlledGame(chatId, update.CallbackQuery.From)
+	if err != nil {
+		return err
+	}
+	defer currentGame.lock.Unlock()
+	if currentGame.lifecycle.status() != LOBBY {
+		return UserError("settings.locked")
+	}
+
+	currentGame.autoWait = !currentGame.autoWait
+
+	handler.saveGame(currentGame)
+	handler.updateListPlayerState(currentGame)
+
+	return nil
+}
+
+func (handler *MessageHandler) toggleSettings(update *tgbotapi.Update) error {
+	chatId := update.CallbackQuery.Message.Chat.ID
+	currentGame, err := handler.lockControlledGame(chatId, update.CallbackQuery.From)
+	if err != nil {
+		return err
+	}
+	defer currentGame.lock.Unlock()
+
+	if currentGame.lifecycle.status() != LOBBY {
+		return UserError("settings.locked")
+	}
 
+	currentGame.showSettings = !currentGame.showSettings
+
+	handler.updateListPlayerState(currentGame)
+
+	return nil
+}
+
+func (handler *MessageHandler) adjustSetting(update *tgbotapi.Update) error {
+	arrData := strings.Split(update.CallbackQuery.Data, ";")
+	if len(arrData) != 3 {
+		return UserError("settings.invalid")
+	}
+	step, _ := strconv.Atoi(arrData[2])
+
+	chatId := update.CallbackQuery.Message.Chat.ID
+	currentGame, err := handler.lockControlledGame(chatId, update.CallbackQuery.From)
+	if err != nil {
+		return err
+	}
+	defer currentGame.lock.Unlock()
+
+	if currentGame.lifecycle.status() != LOBBY {
+		return UserError("settings.locked")
+	}
+	if !lobbySettings[arrData[1]] && len(currentGame.players) > 0 {
+		return UserError("settings.tickets_locked")
+	}
+	if arrData[1] == "voice" && handler.voices == nil {
+		return UserError("settings.no_voice")
+	}
+
+	settings, err := currentGame.settings().adjust(arrData[1], step)
+	if err != nil {
+		// the settings validation explains the limits to the user
+		return err
+	}
+	if settings.MaxPlayers > 0 && len(currentGame.players) > settings.MaxPlayers {
+		return UserError("settings.max_players_taken", len(currentGame.players))
+	}
+	// nothing has been drawn in the lobby, so the game is simply replaced;
+	// the draw seed stays the one committed to when the lobby opened
+	currentGame.lifecycle = RestoreGame(GameSnapshot{
+		Status:       LOBBY,
+		Interval:     settings.Interval,
+		TicketConifg: settings.Ticket,
+		DrawSeed:     currentGame.lifecycle.drawSeed(),
+	})
+	currentGame.maxTickets = settings.MaxTickets
+	currentGame.ticketPrice = settings.TicketPrice
+	currentGame.rhymeStyle = settings.RhymeStyle
+	currentGame.voice = settings.Voice
+	currentGame.minPlayers = settings.MinPlayers
+	currentGame.maxPlayers = settings.MaxPlayers
+	currentGame.setCountdown(settings.Countdown)
+
+	handler.saveGame(currentGame)
 	handler.updateListPlayerState(currentGame)
+	handler.watchLobby(currentGame)
 
 	return nil
 }
 
+// announceWaiting recomputes the "Hò" numbers of every player and
+// announces the ones that appeared with the latest release.
+func (handler *MessageHandler) announceWaiting(game *Lobby) {
+	result := game.lifecycle.result()
+	language := handler.chatLanguage(game.ChatId)
+
+	changed := false
+	for _, player := range game.players {
+		announced := map[int]*None{}
+		for _, number := range player.Waiting {
+			announced[number] = &None{}
+		}
+
+		// tickets of the same player may wait for the same number
+		var waiting []int
+		seen := map[int]*None{}
+		for _, ticket := range player.Tickets {
+			for _, number := range ticket.waitingNumbers(result) {
+				if seen[number] == nil {
+					seen[number] = &None{}
+					waiting = append(waiting, number)
+				}
+			}
+		}
+		sort.Ints(waiting)
+		for _, number := range waiting {
+			if announced[number] != nil {
+				continue
+			}
+			handler.sendMessage(tgbotapi.NewMessage(
+				game.ChatId,
+				T(language, "game.waiting_for", player.Username, number),
+			))
+		}
+
+		if fmt.Sprint(waiting) != fmt.Sprint(player.Waiting) {
+			changed = true
+		}
+		player.Waiting = waiting
+	}
+
+	if changed {
+		handler.updateListPlayerState(game)
+	}
+}
+
 func (handler *MessageHandler) pause(update *tgbotapi.Update) error {
 	chatId := update.CallbackQuery.Message.Chat.ID
-	var currentGame = GameInChatMap[chatId]
-	if currentGame == nil {
-		return fmt.Errorf("Game không tồn tại. Vui lòng mở báo danh!")
+	currentGame, err := handler.lockControlledGame(chatId, update.CallbackQuery.From)
+	if err != nil {
+		return err
 	}
+	defer currentGame.lock.Unlock()
 	if currentGame.lifecycle.isPaused() {
-		return fmt.Errorf("Game đã dừng rồi mà.")
+		return UserError("game.already_paused")
+	}
+	if !currentGame.lifecycle.isStarted() {
+		return UserError("game.not_started")
 	}
 
-	msg := tgbotapi.NewMessage(chatId, "Game tạm dừng!")
+	msg := tgbotapi.NewMessage(chatId, T(handler.chatLanguage(chatId), "game.paused"))
 	msg.ReplyToMessageID = currentGame.GameId
 	handler.sendMessage(msg)
 
-	go currentGame.lifecycle.pause()
+	currentGame.lifecycle.pause()
+	handler.logEvent(currentGame, userEvent(EVENT_PAUSED, update.CallbackQuery.From))
 
+	handler.saveGame(currentGame)
 	handler.updateListPlayerState(currentGame)
 
 	return nil
@@ -227,20 +701,26 @@ func (handler *MessageHandler) pause(update *tgbotapi.Update) error {
 
 func (handler *MessageHandler) resume(update *tgbotapi.Update) error {
 	chatId := update.CallbackQuery.Message.Chat.ID
-	var currentGame = GameInChatMap[chatId]
-	if currentGame == nil {
-		return fmt.Errorf("Game không tồn tại. Vui lòng mở báo danh!")
+	currentGame, err := handler.lockControlledGame(chatId, update.CallbackQuery.From)
+	if err != nil {
+		return err
 	}
+	defer currentGame.lock.Unlock()
 	if currentGame.lifecycle.isStarted() {
-		return fmt.Errorf("Game đã bắt đầu rồi mà.")
+		return UserError("game.already_started")
+	}
+	if !currentGame.lifecycle.isPaused() {
+		return UserError("game.not_started")
 	}
 
-	msg := tgbotapi.NewMessage(chatId, "Game tiếp tục!")
+	msg := tgbotapi.NewMessage(chatId, T(handler.chatLanguage(chatId), "game.resumed"))
 	msg.ReplyToMessageID = currentGame.GameId
 	handler.sendMessage(msg)
 
 	currentGame.lifecycle.resume()
+	handler.logEvent(currentGame, userEvent(EVENT_RESUMED, update.CallbackQuery.From))
 
+	handler.saveGame(currentGame)
 	handler.updateListPlayerState(currentGame)
 
 	return nil
@@ -248,187 +728,400 @@ func (handler *MessageHandler) resume(update *tgbotapi.Update) error {
 
 func (handler *MessageHandler) finish(update *tgbotapi.Update) error {
 	chatId := update.CallbackQuery.Message.Chat.ID
-	var currentGame = GameInChatMap[chatId]
-	if currentGame == nil {
-		return fmt.Errorf("Game không tồn tại. Vui lòng mở báo danh!")
+	currentGame, err := handler.lockControlledGame(chatId, update.CallbackQuery.From)
+	if err != nil {
+		return err
 	}
+	defer currentGame.lock.Unlock()
+	handler.finishGame(currentGame, userEvent(EVENT_FINISHED, update.CallbackQuery.From))
 
-	go currentGame.lifecycle.stop()
+	return nil
+}
+
+// finishGame ends the locked game, settles it and removes the lobby. The
+// event tells who finished it.
+func (handler *MessageHandler) finishGame(currentGame *Lobby, event GameEvent) {
+	chatId := currentGame.ChatId
+	// a lobby closed before the draw is not a played game
+	played := currentGame.lifecycle.status() != LOBBY
+	currentGame.lifecycle.stop()
 
 	handler.updateListPlayerState(currentGame)
 
-	msg := tgbotapi.NewMessage(chatId, "Kết thúc!")
+	language := handler.chatLanguage(chatId)
+	msg := tgbotapi.NewMessage(chatId, T(language, "game.finished"))
 	msg.ReplyToMessageID = currentGame.GameId
 	handler.sendMessage(msg)
+	handler.sendResultBoard(currentGame)
+
+	// reveal the committed seed so the draw can be verified
+	seed := hex.EncodeToString(currentGame.lifecycle.drawSeed())
+	reveal := tgbotapi.NewMessage(chatId, T(language, "game.seed_revealed", seed, CMD_VERIFY, currentGame.GameId, seed))
+	reveal.ParseMode = HTML
+	handler.sendMessage(reveal)
+
+	handler.closeLobby(currentGame, played, event)
+}
+
+// closeLobby settles the pot of the stopped lobby, takes the keyboard off
+// the tickets and forgets the lobby. A played game is archived with its
+// last event, the events of a lobby closed before the draw are dropped.
+func (handler *MessageHandler) closeLobby(game *Lobby, played bool, event GameEvent) {
+	chatId := game.ChatId
+	// without winners the pot goes back to the players
+	text, err := handler.settlePot(game)
+	if err != nil {
+		log.Errorf("settle pot of game %d error: %s", game.GameId, err.Error())
+	}
+	handler.sendMessage(tgbotapi.NewMessage(chatId, text))
 
 	// update message ticket for user after game end
-	for _, v := range currentGame.players {
-		ticketText, _ := Parse(
-			"./config/ticket.html",
-			struct {
-				GameId   int
-				TicketId uint32
-				Data     string
-			}{
-				GameId:   v.Ticket.GameId,
-				TicketId: v.Ticket.Id.ID(),
-				Data:     BeautyTicket(v.Ticket.board),
-			})
-
-		editMessage := tgbotapi.NewEditMessageText(
-			v.Id,
-			v.Ticket.MessageId,
-			ticketText,
-		)
-		editMessage.ParseMode = "HTML"
-		handler.editMessage(editMessage)
+	result := game.lifecycle.result()
+	for _, v := range game.players {
+		playerLanguage := handler.playerLanguage(v, chatId)
+		for i, ticket := range v.Tickets {
+			if ticket.MessageId == 0 {
+				// still on its way, see ticketDelivered
+				continue
+			}
+			if text, err := handler.ticketText(playerLanguage, ticket, i); err != nil {
+				log.Errorf("render ticket %d of %d error: %s", i, v.Id, err.Error())
+			} else {
+				editMessage := tgbotapi.NewEditMessageText(v.Id, ticket.MessageId, text)
+				editMessage.ParseMode = "HTML"
+				handler.editMessage(editMessage)
+			}
+			if played {
+				handler.sendPhoto(v.Id, ticket.MessageId, "ticket.png", ticket.picture(result, nil).Render(), "")
+			}
+		}
 	}
 
-	// remove game
-	GameInChatMap[chatId] = nil
+	if played {
+		handler.logEvent(game, event)
+		if err := handler.storage.ArchiveGame(game.archive(time.Now())); err != nil {
+			log.Errorf("archive game %d of chat %d error: %s", game.GameId, chatId, err.Error())
+		}
+	} else if err := handler.storage.DeleteEvents(chatId, game.GameId); err != nil {
+		log.Errorf("delete events of game %d of chat %d error: %s", game.GameId, chatId, err.Error())
+	}
 
-	return nil
+	// remove game
+	handler.lobbies.Remove(game)
+	handler.deleteGame(chatId)
 }
 
 func (handler *MessageHandler) wait(update *tgbotapi.Update) error {
-	arrData := strings.Split(update.CallbackQuery.Data, ";")
-	gameChatId, _ := strconv.ParseInt(arrData[1], 10, 64)
+	query, err := parseTicketQuery(update.CallbackQuery.Data)
+	if err != nil {
+		return err
+	}
+	gameChatId := query.ChatId
 
-	var currentGame = GameInChatMap[gameChatId]
-	if currentGame == nil {
-		return fmt.Errorf("Game không tồn tại. Vui lòng mở báo danh!")
+	currentGame, err := handler.lockTicketGame(query)
+	if err != nil {
+		return err
 	}
+	defer currentGame.lock.Unlock()
 	if currentGame.lifecycle.status() == LOBBY {
-		return fmt.Errorf("Game chưa bắt đầu. Chờ chút nào!")
+		return UserError("game.not_started")
 	}
 
 	player := currentGame.players[update.CallbackQuery.From.ID]
+	if player == nil {
+		return UserError("game.not_registered")
+	}
 	player.Wait += 1
+	handler.logEvent(currentGame, playerEvent(EVENT_WAIT, player, query.Ticket, 0))
 
+	handler.saveGame(currentGame)
 	handler.updateListPlayerState(currentGame)
 
 	handler.sendMessage(tgbotapi.NewMessage(
 		gameChatId,
-		fmt.Sprintf("@%s đợi lần thứ %d", player.Username, player.Wait),
+		TN(handler.chatLanguage(gameChatId), "game.waited", player.Wait, player.Username),
 	))
 
 	return nil
 }
 
 func (handler *MessageHandler) bingo(update *tgbotapi.Update) error {
-	arrData := strings.Split(update.CallbackQuery.Data, ";")
-	gameChatId, _ := strconv.ParseInt(arrData[1], 10, 64)
+	query, err := parseTicketQuery(update.CallbackQuery.Data)
+	if err != nil {
+		return err
+	}
+	gameChatId := query.ChatId
 
-	var currentGame = GameInChatMap[gameChatId]
-	if currentGame == nil {
-		return fmt.Errorf("Game không tồn tại. Vui lòng mở báo danh!")
+	currentGame, err := handler.lockTicketGame(query)
+	if err != nil {
+		return err
 	}
+	defer currentGame.lock.Unlock()
 	if currentGame.lifecycle.status() == LOBBY {
-		return fmt.Errorf("Game chưa bắt đầu. Chờ chút nào!")
+		return UserError("game.not_started")
 	}
 
-	player := currentGame.players[update.CallbackQuery.From.ID]
-	text, _ := Parse("./config/bingo.html",
-		struct {
-			Username string
-			TicketId uint32
-			GameId   int
-			Result   string
-			Data     string
-		}{
-			Username: player.Username,
-			TicketId: player.Ticket.Id.ID(),
-			GameId:   currentGame.GameId,
-			Result:   BeautyResult(currentGame.lifecycle.result()),
-			Data:     BeautyTicket(player.Ticket.board),
-		})
-	msg := tgbotapi.NewMessage(gameChatId, text)
-	msg.ParseMode = "HTML"
-	msg.ReplyToMessageID = currentGame.GameId
-	handler.sendMessage(msg)
+	player, ticket, err := currentGame.playerTicket(update.CallbackQuery.From.ID, query.Ticket)
+	if err != nil {
+		return err
+	}
+	if currentGame.isWinner(player) {
+		return UserError("bingo.already_won")
+	}
 
+	// freeze the draw while the claim is verified
+	wasStarted := currentGame.lifecycle.isStarted()
 	currentGame.lifecycle.pause()
 
+	language := handler.chatLanguage(gameChatId)
+	rows := ticket.completedRows(currentGame.lifecycle.result())
+	if len(rows) == 0 {
+		player.FalseBingo += 1
+		handler.logEvent(currentGame, playerEvent(EVENT_FALSE_BINGO, player, query.Ticket, 0))
+
+		msg := tgbotapi.NewMessage(
+			gameChatId,
+			TN(language, "bingo.false", player.FalseBingo, player.Username),
+		)
+		msg.ReplyToMessageID = currentGame.GameId
+		handler.sendMessage(msg)
+
+		if wasStarted {
+			currentGame.lifecycle.resume()
+		}
+		handler.saveGame(currentGame)
+		handler.updateListPlayerState(currentGame)
+
+		return nil
+	}
+
+	currentGame.winners = append(currentGame.winners, player)
+	player.WonAt = len(currentGame.lifecycle.result())
+	handler.logEvent(currentGame, playerEvent(EVENT_BINGO, player, query.Ticket, 0))
+
+	var rowLabels []string
+	for _, row := range rows {
+		rowLabels = append(rowLabels, fmt.Sprint(row+1))
+	}
+	text, err := handler.templates.Render(language, TEMPLATE_BINGO, BingoView{
+		Username: player.Username,
+		TicketId: ticket.Id.ID(),
+		GameId:   currentGame.GameId,
+		Rows:     strings.Join(rowLabels, ", "),
+	})
+	if err != nil {
+		// the Kinh is valid anyway, announce it without the details
+		log.Errorf("render bingo of game %d error: %s", currentGame.GameId, err.Error())
+		text = escapeHTML(T(language, "bingo.title"))
+	}
+	result := currentGame.lifecycle.result()
+	handler.sendPhoto(
+		gameChatId,
+		currentGame.GameId,
+		"ticket.png",
+		ticket.picture(result, rows).Render(),
+		text,
+	)
+	handler.sendResultBoard(currentGame)
+
+	handler.saveGame(currentGame)
 	handler.updateListPlayerState(currentGame)
 
 	return nil
 }
 
+// queryNumerCheck daubs the tapped number of a ticket, or removes its
+// daub. Only called numbers can be daubed.
 func (handler *MessageHandler) queryNumerCheck(update *tgbotapi.Update) error {
-	chatId := update.CallbackQuery.Message.Chat.ID
+	query, err := parseTicketQuery(update.CallbackQuery.Data)
+	if err != nil {
+		return err
+	}
+	x, y := query.Row, query.Col
 
-	arrData := strings.Split(update.CallbackQuery.Data, ";")
-	gameChatId, _ := strconv.ParseInt(arrData[1], 10, 64)
-	gameId, _ := strconv.Atoi(arrData[2])
+	currentGame, err := handler.lockTicketGame(query)
+	if err != nil {
+		return err
+	}
+	defer currentGame.lock.Unlock()
+
+	player, ticket, err := currentGame.playerTicket(update.CallbackQuery.From.ID, query.Ticket)
+	if err != nil {
+		return err
+	}
+	if x < 0 || x >= len(ticket.board) || y < 0 || y >= len(ticket.board[x]) || ticket.board[x][y] <= 0 {
+		return UserError("ticket.no_cell")
+	}
 
-	coordinate := strings.Split(arrData[3], "-")
-	x, _ := strconv.Atoi(coordinate[0])
-	y, _ := strconv.Atoi(coordinate[1])
+	if currentGame.lifecycle.status() == LOBBY {
+		return UserError("game.not_started_calm")
+	}
 
-	var currentGame = GameInChatMap[gameChatId]
-	if currentGame == nil {
-		return fmt.Errorf("Game không tồn tại. Vui lòng mở báo danh!")
+	number := ticket.board[x][y]
+	drawn := false
+	for _, v := range currentGame.lifecycle.result() {
+		if v == number {
+			drawn = true
+		}
 	}
+	if !ticket.cells(nil, DAUB_MANUAL).Daubed[number] && !drawn {
+		return UserError("daub.not_called", number)
+	}
+	ticket.toggleDaub(number)
+	kind := EVENT_DAUBED
+	if !ticket.cells(nil, DAUB_MANUAL).Daubed[number] {
+		kind = EVENT_UNDAUBED
+	}
+	handler.logEvent(currentGame, playerEvent(kind, player, query.Ticket, number))
 
-	player := currentGame.players[update.CallbackQuery.From.ID]
-	if player == nil {
-		return fmt.Errorf("Game không tồn tại. Vui lòng mở báo danh!")
+	handler.saveGame(currentGame)
+	handler.editTicket(currentGame, player, query.Ticket)
+
+	return nil
+}
+
+func (handler *MessageHandler) board(update *tgbotapi.Update) error {
+	currentGame, err := handler.lockGame(update.Message.Chat.ID)
+	if err != nil {
+		return err
 	}
+	defer currentGame.lock.Unlock()
 
 	if currentGame.lifecycle.status() == LOBBY {
-		return fmt.Errorf("Game chưa bắt đầu mà. Bình tĩnh bạn ơi!")
+		return UserError("game.not_started")
 	}
+	handler.sendResultBoard(currentGame)
 
-	currentValue := player.Ticket.board[x][y]
-	if currentValue == 0 {
-		player.Ticket.board[x][y] = -1
-	} else if currentValue == -1 {
-		player.Ticket.board[x][y] = 0
-	} else {
-		return nil
+	return nil
+}
+
+// sendResultBoard posts the picture of the numbers drawn so far.
+func (handler *MessageHandler) sendResultBoard(game *Lobby) {
+	result := game.lifecycle.result()
+	picture := ResultPicture{
+		Space: game.lifecycle.ticketConfig().Space(),
+		Drawn: result,
 	}
 
-	ticketText, _ := Parse("./config/ticket.html",
-		struct {
-			GameId   int
-			TicketId uint32
-			Data     string
-		}{
-			GameId:   currentGame.GameId,
-			TicketId: player.Ticket.Id.ID(),
-			Data:     "",
-		})
-	editMsg := tgbotapi.NewEditMessageTextAndMarkup(
-		chatId,
-		update.CallbackQuery.Message.MessageID,
-		ticketText,
-		GenerateTicketKeyboard(gameChatId, gameId, player.Ticket.board),
+	handler.sendPhoto(
+		game.ChatId,
+		game.GameId,
+		"result.png",
+		picture.Render(),
+		TN(handler.chatLanguage(game.ChatId), "board.caption", len(result), game.GameId),
 	)
-	editMsg.ParseMode = "HTML"
+}
 
-	handler.editMessage(editMsg)
+// lockGame returns the running lobby of the chat, locked for the caller.
+func (handler *MessageHandler) lockGame(chatId int64) (*Lobby, error) {
+	game := handler.lobbies.Get(chatId)
+	if game == nil {
+		return nil, UserError("game.not_found")
+	}
+
+	game.lock.Lock()
+	// the game may have finished while waiting for the lock, or its lobby
+	// message is not posted yet
+	if game.lifecycle.status() == STOPPED || game.GameId == 0 {
+		game.lock.Unlock()
+		return nil, UserError("game.not_found")
+	}
+
+	return game, nil
+}
+
+// lockTicketGame returns the running lobby of the ticket query, locked for
+// the caller. A ticket kept from an earlier game of the chat is refused.
+func (handler *MessageHandler) lockTicketGame(query TicketQuery) (*Lobby, error) {
+	game, err := handler.lockGame(query.ChatId)
+	if err != nil {
+		return nil, err
+	}
+	if game.GameId != query.GameId {
+		game.lock.Unlock()
+		return nil, UserError("ticket.outdated")
+	}
+
+	return game, nil
+}
+
+func (handler *MessageHandler) saveGame(game *Lobby) {
+	if game.lifecycle.status() == STOPPED {
+		return
+	}
+	if err := handler.storage.SaveLobby(game.record()); err != nil {
+		log.Errorf("save game %d of chat %d error: %s", game.GameId, game.ChatId, err.Error())
+	}
+}
+
+func (handler *MessageHandler) deleteGame(chatId int64) {
+	if err := handler.storage.DeleteLobby(chatId); err != nil {
+		log.Errorf("delete game of chat %d error: %s", chatId, err.Error())
+	}
+}
+
+// Restore rehydrates the lobbies persisted before the bot went down.
+// Running games come back paused and the release listener is attached
+// again, so the tickets already sent keep working with their keyboards.
+func (handler *MessageHandler) Restore() error {
+	records, err := handler.storage.LoadLobbies()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		game := RestoreLobby(record)
+		if _, created := handler.lobbies.Register(game); !created {
+			continue
+		}
+
+		if game.lifecycle.status() == LOBBY {
+			handler.watchLobby(game)
+		}
+		if game.lifecycle.status() != LOBBY {
+			go handler.listenRelease(game)
+
+			msg := tgbotapi.NewMessage(game.ChatId, T(handler.chatLanguage(game.ChatId), "game.restored"))
+			msg.ReplyToMessageID = game.GameId
+			handler.sendMessage(msg)
+		}
+		handler.updateListPlayerState(game)
+
+		log.Infof("restored game %d of chat %d with %d players", game.GameId, game.ChatId, len(game.players))
+	}
 
 	return nil
 }
 
 func (handler *MessageHandler) updateListPlayerState(game *Lobby) {
-	text, _ := Parse("./config/game.html",
-		struct {
-			GameId int
-			List   string
-		}{
-			GameId: game.GameId,
-			List:   game.renderPlayerList(),
-		})
+	language := handler.chatLanguage(game.ChatId)
+	game.shownCountdown = game.countdownLabel(time.Now())
+	text, err := handler.templates.Render(language, TEMPLATE_LOBBY, LobbyView{
+		GameId:     game.GameId,
+		Host:       game.HostName,
+		Pot:        game.pot(),
+		Commitment: game.lifecycle.commitment(),
+		Settings:   game.settings().Text(language),
+		Countdown:  game.shownCountdown,
+		List:       game.renderPlayerList(language),
+	})
+	if err != nil {
+		log.Errorf("render lobby of game %d error: %s", game.GameId, err.Error())
+		return
+	}
 
 	var inlineKeyboard tgbotapi.InlineKeyboardMarkup
 	switch game.lifecycle.status() {
 	case STARTED:
-		inlineKeyboard = PlayingInnlineKeyboard
+		inlineKeyboard = GeneratePlayingKeyboard(language)
 	case PAUSED:
-		inlineKeyboard = PausedInlineKeyboard
+		inlineKeyboard = GeneratePausedKeyboard(language)
 	case LOBBY:
-		inlineKeyboard = OpenGameInlineKeyboard
+		if game.showSettings {
+			inlineKeyboard = GenerateSettingsKeyboard(language, game.settings())
+		} else {
+			inlineKeyboard = GenerateOpenGameKeyboard(language, game.autoWait, game.maxTickets)
+		}
 	default:
 	}
 
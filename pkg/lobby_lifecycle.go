@@ -1,7 +1,6 @@
 package pkg
 
 import (
-	"math/rand"
 	"sync"
 	"time"
 )
@@ -17,6 +16,8 @@ const (
 
 type Lifecycle interface {
 	start() chan int
+	releaseChanel() chan int
+	quitChanel() chan bool
 	stop()
 	pause()
 	resume()
@@ -24,18 +25,41 @@ type Lifecycle interface {
 	isStarted() bool
 	isPaused() bool
 	ticketConfig() TicketConifg
+	interval() time.Duration
 	result() []int
-	addResultSeed(number int)
+	addResultSeed(number int) bool
+	commitment() string
+	drawSeed() []byte
+	snapshot() GameSnapshot
 }
 
+// GameSnapshot is the persisted form of a Game.
+type GameSnapshot struct {
+	Status       GameStatus
+	Interval     time.Duration
+	TicketConifg TicketConifg
+	Seed         []int
+	Result       []int
+	DrawSeed     []byte
+}
+
+// Game draws the numbers of a lobby. Every transition is guarded by lock
+// and never waits for the draw goroutine: pausing closes the channel of the
+// current draw run and stopping closes QuitChanel, so callers holding the
+// lobby lock can not dead-lock with the release listener.
 type Game struct {
-	Status        GameStatus
-	Interval      time.Duration
-	TicketConifg  TicketConifg
-	seed          Seed
-	resultSeed    Seed
+	Status       GameStatus
+	Interval     time.Duration
+	TicketConifg TicketConifg
+	seed         Seed
+	resultSeed   Seed
+	// secret is the committed seed the draw order is derived from
+	secret        []byte
 	ReleaseChanel chan int
 	QuitChanel    chan bool
+	pauseChanel   chan bool
+
+	lock sync.RWMutex
 }
 
 type Seed struct {
@@ -49,42 +73,69 @@ func NewGame(interval time.Duration, ticketConfig TicketConifg) Lifecycle {
 		Interval:      interval,
 		TicketConifg:  ticketConfig,
 		seed:          Seed{},
+		secret:        NewDrawSeed(),
 		ReleaseChanel: make(chan int),
 		QuitChanel:    make(chan bool),
 	}
 }
 
-func (seed *Seed) shuffle() {
-	t := time.Now()
-	rand.Seed(int64(t.Nanosecond()))
-
-	for i := range seed.numbers {
-		j := rand.Intn(i + 1)
-		if i != j {
-			seed.numbers[i], seed.numbers[j] = seed.numbers[j], seed.numbers[i]
-		}
+// RestoreGame rebuilds a game from its snapshot. A game which was drawing
+// numbers when the snapshot was taken comes back paused, so the draw only
+// continues once somebody resumes it.
+func RestoreGame(snapshot GameSnapshot) Lifecycle {
+	game := NewGame(snapshot.Interval, snapshot.TicketConifg).(*Game)
+	game.Status = snapshot.Status
+	if game.Status == STARTED {
+		game.Status = PAUSED
 	}
+	if game.Status == STOPPED {
+		close(game.QuitChanel)
+	}
+	game.seed.numbers = append([]int{}, snapshot.Seed...)
+	game.resultSeed.numbers = append([]int{}, snapshot.Result...)
+	if len(snapshot.DrawSeed) > 0 {
+		game.secret = snapshot.DrawSeed
+	}
+
+	return game
+}
+
+func (seed *Seed) values() []int {
+	seed.lock.RLock()
+	defer seed.lock.RUnlock()
+
+	return append([]int{}, seed.numbers...)
 }
 
-func (seed *Seed) init(maxNumber int) {
+func (seed *Seed) init(numbers []int) {
 	seed.lock.Lock()
 	defer seed.lock.Unlock()
 
-	for i := 1; i <= maxNumber; i++ {
-		seed.numbers = append(seed.numbers, i)
-	}
+	seed.numbers = append(seed.numbers, numbers...)
 }
 
-func (seed *Seed) pop() int {
+// pop takes the next number of the draw order, ok is false once the seed
+// is empty.
+func (seed *Seed) pop() (int, bool) {
 	seed.lock.Lock()
 	defer seed.lock.Unlock()
 
-	seed.shuffle()
+	if len(seed.numbers) == 0 {
+		return 0, false
+	}
+
+	value := seed.numbers[0]
+	seed.numbers = seed.numbers[1:]
+	return value, true
+}
+
+// unpop puts a number which could not be released back in front of the
+// draw order.
+func (seed *Seed) unpop(number int) {
+	seed.lock.Lock()
+	defer seed.lock.Unlock()
 
-	top := len(seed.numbers) - 1
-	value := seed.numbers[top]
-	seed.numbers = seed.numbers[:top]
-	return value
+	seed.numbers = append([]int{number}, seed.numbers...)
 }
 
 func (seed *Seed) push(number int) {
@@ -94,83 +145,152 @@ func (seed *Seed) push(number int) {
 	seed.numbers = append(seed.numbers, number)
 }
 
-func (seed *Seed) autoRelease(duration time.Duration, releaseChanel chan int, quit chan bool) {
+// autoRelease sends a number to releaseChanel every duration until the seed
+// is empty or pause is closed. A number which could not be delivered before
+// the pause goes back into the seed.
+func (seed *Seed) autoRelease(duration time.Duration, releaseChanel chan int, pause chan bool) {
 	for {
-		select {
-		case <-quit:
+		value, ok := seed.pop()
+		if !ok {
 			return
-		default:
-			value := seed.pop()
-			releaseChanel <- value
+		}
 
-			if len(seed.numbers) == 0 {
-				releaseChanel <- 0
-				return
-			}
+		select {
+		case releaseChanel <- value:
+		case <-pause:
+			seed.unpop(value)
+			return
+		}
 
-			time.Sleep(duration)
+		select {
+		case <-pause:
+			return
+		case <-time.After(duration):
 		}
 	}
 }
 
 func (game *Game) start() chan int {
-	if game.status() == STARTED {
+	game.lock.Lock()
+	defer game.lock.Unlock()
+
+	if game.Status != LOBBY {
 		return game.ReleaseChanel
 	}
 	game.Status = STARTED
-	game.seed.init(game.ticketConfig().MaxNumer)
+	game.seed.init(DrawOrder(game.secret, game.TicketConifg.Space()))
+	game.pauseChanel = make(chan bool)
 
-	go game.seed.autoRelease(game.Interval, game.ReleaseChanel, game.QuitChanel)
+	go game.seed.autoRelease(game.Interval, game.ReleaseChanel, game.pauseChanel)
+
+	return game.ReleaseChanel
+}
 
+func (game *Game) releaseChanel() chan int {
 	return game.ReleaseChanel
 }
 
+func (game *Game) quitChanel() chan bool {
+	return game.QuitChanel
+}
+
 func (game *Game) stop() {
-	if game.status() == STOPPED {
+	game.lock.Lock()
+	defer game.lock.Unlock()
+
+	if game.Status == STOPPED {
 		return
 	}
+	if game.Status == STARTED {
+		close(game.pauseChanel)
+	}
 	game.Status = STOPPED
-	game.QuitChanel <- true
-	close(game.ReleaseChanel)
 	close(game.QuitChanel)
 }
 
 func (game *Game) pause() {
-	if game.status() == PAUSED {
+	game.lock.Lock()
+	defer game.lock.Unlock()
+
+	if game.Status != STARTED {
 		return
 	}
 	game.Status = PAUSED
-	game.QuitChanel <- true
+	close(game.pauseChanel)
 }
 
 func (game *Game) resume() {
-	if game.status() == STARTED {
+	game.lock.Lock()
+	defer game.lock.Unlock()
+
+	if game.Status != PAUSED {
 		return
 	}
 	game.Status = STARTED
-	go game.seed.autoRelease(game.Interval, game.ReleaseChanel, game.QuitChanel)
+	game.pauseChanel = make(chan bool)
+	go game.seed.autoRelease(game.Interval, game.ReleaseChanel, game.pauseChanel)
 }
 
-func (game *Game) addResultSeed(number int) {
+// addResultSeed records a released number. When the game stopped drawing
+// in the meantime the number goes back into the seed and false is
+// returned, so it is never announced while the game is paused.
+func (game *Game) addResultSeed(number int) bool {
+	game.lock.RLock()
+	defer game.lock.RUnlock()
+
+	if game.Status != STARTED {
+		game.seed.unpop(number)
+		return false
+	}
 	game.resultSeed.push(number)
+	return true
 }
 
 func (game *Game) result() []int {
-	return game.resultSeed.numbers
+	return game.resultSeed.values()
 }
 
 func (game *Game) isStarted() bool {
-	return game.Status == STARTED
+	return game.status() == STARTED
 }
 
 func (game *Game) isPaused() bool {
-	return game.Status == PAUSED
+	return game.status() == PAUSED
 }
 
 func (game *Game) status() GameStatus {
+	game.lock.RLock()
+	defer game.lock.RUnlock()
+
 	return game.Status
 }
 
 func (game *Game) ticketConfig() TicketConifg {
 	return game.TicketConifg
 }
+
+func (game *Game) interval() time.Duration {
+	return game.Interval
+}
+
+func (game *Game) commitment() string {
+	return Commitment(game.secret)
+}
+
+func (game *Game) drawSeed() []byte {
+	return game.secret
+}
+
+func (game *Game) snapshot() GameSnapshot {
+	game.lock.RLock()
+	defer game.lock.RUnlock()
+
+	return GameSnapshot{
+		Status:       game.Status,
+		Interval:     game.Interval,
+		TicketConifg: game.TicketConifg,
+		Seed:         game.seed.values(),
+		Result:       game.resultSeed.values(),
+		DrawSeed:     game.secret,
+	}
+}
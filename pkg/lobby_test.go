@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+func TestBuyTicketLimit(t *testing.T) {
+	handler := newRaceTestHandler(t)
+	chatId := int64(-100)
+
+	settings := DefaultGameSettings()
+	settings.MaxTickets = 3
+	handler.newGame(&tgbotapi.Update{
+		Message: &tgbotapi.Message{
+			MessageID: 1,
+			From:      &tgbotapi.User{ID: 1, UserName: "lotovn_host"},
+			Chat:      &tgbotapi.Chat{ID: chatId},
+		},
+	}, settings)
+
+	if err := handler.buyTicket(newCallbackUpdate(chatId, 2, QUERY_DATA_BUY)); err == errAnsweredLater {
+		t.Fatal("expected buying before registering to fail")
+	}
+	if err := handler.register(newCallbackUpdate(chatId, 2, QUERY_DATA_REGISTER)); err != errAnsweredLater {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := handler.buyTicket(newCallbackUpdate(chatId, 2, QUERY_DATA_BUY)); err != errAnsweredLater {
+			t.Fatal(err)
+		}
+	}
+	if err := handler.buyTicket(newCallbackUpdate(chatId, 2, QUERY_DATA_BUY)); err == errAnsweredLater {
+		t.Fatal("expected the 4th ticket to be rejected")
+	}
+
+	game := handler.lobbies.Get(chatId)
+	player := game.players[2]
+	if len(player.Tickets) != 3 {
+		t.Fatalf("expected 3 tickets, got %d", len(player.Tickets))
+	}
+
+	// every ticket is addressed by its own index in the callback data
+	data := fmt.Sprintf("%s;%d;%d;2;0-0", QUERY_DATA_CHECKED, chatId, game.GameId)
+	query, err := parseTicketQuery(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ticket, err := game.playerTicket(2, query.Ticket); err != nil || ticket != player.Tickets[2] {
+		t.Fatalf("expected the 3rd ticket, got %v", err)
+	}
+	if _, _, err := game.playerTicket(2, 3); err == nil {
+		t.Fatal("expected a missing ticket to be rejected")
+	}
+}
+
+func TestTicketSentWithoutLock(t *testing.T) {
+	server := telegramtest.NewServer()
+	t.Cleanup(server.Close)
+	bot, err := server.Bot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher := NewDispatcher(bot, DefaultRateLimits())
+	t.Cleanup(dispatcher.Close)
+	storage, err := NewBoltStorage(filepath.Join(t.TempDir(), "lotovn.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	handler := NewHandler(dispatcher, storage, nil, nil, nil).(*MessageHandler)
+
+	group := telegramtest.Group(-1062)
+	host := telegramtest.User(10, "lotovn_host")
+	player := telegramtest.User(11, "player_teo")
+	release := make(chan struct{})
+	server.Handle("sendMessage", func(req telegramtest.Request) (interface{}, error) {
+		if req.ChatId() == player.ID {
+			<-release
+		}
+		return tgbotapi.Message{MessageID: 100, Chat: &tgbotapi.Chat{ID: req.ChatId()}}, nil
+	})
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame"))
+	var game *Lobby
+	if !server.WaitFor(2*time.Second, func(requests []telegramtest.Request) bool {
+		game = handler.lobbies.Get(group.ID)
+		if game == nil {
+			return false
+		}
+		game.lock.Lock()
+		defer game.lock.Unlock()
+		return game.GameId != 0
+	}) {
+		t.Fatal("lobby message was not posted")
+	}
+
+	// the lobby stays playable while the ticket is on its way
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_AUTO_WAIT))
+	game.lock.Lock()
+	if game.autoWait || len(game.players[player.ID].Tickets) != 1 {
+		t.Fatal("lobby was held by the ticket delivery")
+	}
+	game.lock.Unlock()
+	if len(server.Requests("answerCallbackQuery")) != 1 {
+		t.Fatal("registration was answered before the ticket was delivered")
+	}
+
+	close(release)
+	if !server.WaitFor(2*time.Second, func(requests []telegramtest.Request) bool {
+		return len(server.Requests("answerCallbackQuery")) == 2
+	}) {
+		t.Fatal("registration was not answered")
+	}
+	game.lock.Lock()
+	defer game.lock.Unlock()
+	if ticket := game.players[player.ID].Tickets[0]; ticket.MessageId != 100 {
+		t.Fatalf("expected the message id of the ticket, got %d", ticket.MessageId)
+	}
+}
+
+func TestAnnounceWaitingOnce(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1009)
+
+	// both tickets wait for 23 once 1 is called
+	player := &Player{Id: 11, Username: "player_teo", Tickets: []*Ticket{
+		{board: [][]int{{1, 0, 23}, {0, 40, 50}}},
+		{board: [][]int{{0, 1, 23}, {60, 0, 70}}},
+	}}
+	game := &Lobby{
+		ChatId:    group.ID,
+		GameId:    1,
+		players:   map[int64]*Player{player.Id: player},
+		lifecycle: RestoreGame(GameSnapshot{Status: PAUSED, Result: []int{1}}),
+	}
+	handler.announceWaiting(game)
+
+	if !reflect.DeepEqual(player.Waiting, []int{23}) {
+		t.Fatalf("expected to wait for [23], got %v", player.Waiting)
+	}
+	if count := countTexts(server.Sent(group.ID), group.ID, T(DEFAULT_LANGUAGE, "game.waiting_for", player.Username, 23)); count != 1 {
+		t.Fatalf("waiting number was announced %d times", count)
+	}
+}
@@ -2,6 +2,7 @@ package pkg
 
 import (
 	"fmt"
+	"image"
 	"strings"
 
 	"github.com/apex/log"
@@ -21,24 +22,67 @@ func (handler *MessageHandler) getCaller(update *tgbotapi.Update) string {
 	return caller
 }
 
-func (handler *MessageHandler) sendMessage(msg tgbotapi.MessageConfig) *tgbotapi.Message {
+// post does not wait for the request when the bot client has an outbox, so
+// announcements never hold a lobby lock until the chat may send again.
+func (handler *MessageHandler) post(c tgbotapi.Chattable) {
+	if outbox, ok := handler.bot.(Outbox); ok {
+		outbox.Enqueue(c)
+		return
+	}
+
+	if _, err := handler.bot.Send(c); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// postThen posts the request and calls done with its result, once it was
+// sent. Without an outbox the request is sent right away, so the caller must
+// not hold a lock that done takes.
+func (handler *MessageHandler) postThen(c tgbotapi.Chattable, done func(tgbotapi.Message, error)) {
+	if outbox, ok := handler.bot.(Outbox); ok {
+		outbox.EnqueueThen(c, done)
+		return
+	}
+
+	done(handler.bot.Send(c))
+}
+
+func (handler *MessageHandler) sendMessage(msg tgbotapi.MessageConfig) {
 	if len(msg.Text) != 0 {
-		msg, err := handler.bot.Send(msg)
-		if err != nil {
-			log.Error(err.Error())
-		}
-		return &msg
+		handler.post(msg)
+	}
+}
+
+// sendPhoto sends the rendered image as a PNG photo with an HTML caption.
+func (handler *MessageHandler) sendPhoto(chatId int64, replyTo int, name string, img image.Image, caption string) {
+	data, err := EncodePNG(img)
+	if err != nil {
+		log.Errorf("encode photo %s error: %s", name, err.Error())
+		return
 	}
 
-	return nil
+	photo := tgbotapi.NewPhoto(chatId, tgbotapi.FileBytes{Name: name, Bytes: data})
+	photo.Caption = caption
+	photo.ParseMode = HTML
+	photo.ReplyToMessageID = replyTo
+
+	handler.post(photo)
 }
 
-func (handler *MessageHandler) editMessage(msg tgbotapi.Chattable) (tgbotapi.Message, error) {
-	return handler.bot.Send(msg)
+// editMessage does not wait for the edit either, an older edit of the
+// message which is still queued is dropped.
+func (handler *MessageHandler) editMessage(msg tgbotapi.Chattable) {
+	handler.post(msg)
 }
 
 func (handler *MessageHandler) removeMessage(chatId int64, messageId int) {
-	if _, err := handler.bot.Request(tgbotapi.NewDeleteMessage(chatId, messageId)); err != nil {
+	deleteMsg := tgbotapi.NewDeleteMessage(chatId, messageId)
+	if outbox, ok := handler.bot.(Outbox); ok {
+		outbox.Enqueue(deleteMsg)
+		return
+	}
+
+	if _, err := handler.bot.Request(deleteMsg); err != nil {
 		log.Errorf("delete message erorr: %s", err.Error())
 	}
 }
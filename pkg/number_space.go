@@ -0,0 +1,73 @@
+package pkg
+
+const (
+	MIN_NUMBER_SPACE     = 10
+	CLASSIC_NUMBER_SPACE = 90
+)
+
+// NumberSpace is the pool 1..Max shared by the draw seed, the ticket
+// columns and the result board. Column i holds the numbers of its tens
+// (the first column starts at 1) and the last column also takes the
+// numbers up to Max, so the classic 1-90 space has 9 columns: 1-9,
+// 10-19, ..., 80-90.
+type NumberSpace struct {
+	Max int
+}
+
+func (space NumberSpace) validate() error {
+	if space.Max < MIN_NUMBER_SPACE || space.Max > CLASSIC_NUMBER_SPACE {
+		return UserError("settings.max_range", MIN_NUMBER_SPACE, CLASSIC_NUMBER_SPACE)
+	}
+
+	return nil
+}
+
+func (space NumberSpace) Columns() int {
+	return space.Max / 10
+}
+
+// ColumnRange returns the first and last number of the column.
+func (space NumberSpace) ColumnRange(index int) (int, int) {
+	min := index * 10
+	if index == 0 {
+		min = 1
+	}
+	max := index*10 + 9
+	if index == space.Columns()-1 {
+		max = space.Max
+	}
+
+	return min, max
+}
+
+func (space NumberSpace) Column(index int) []int {
+	var values []int
+	min, max := space.ColumnRange(index)
+	for i := min; i <= max; i++ {
+		values = append(values, i)
+	}
+
+	return values
+}
+
+func (space NumberSpace) ColumnOf(number int) int {
+	index := number / 10
+	if index >= space.Columns() {
+		index = space.Columns() - 1
+	}
+
+	return index
+}
+
+func (space NumberSpace) Contains(number int) bool {
+	return number >= 1 && number <= space.Max
+}
+
+func (space NumberSpace) Numbers() []int {
+	var values []int
+	for i := 1; i <= space.Max; i++ {
+		values = append(values, i)
+	}
+
+	return values
+}
@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	OGG_HEADER_SIZE = 27
+
+	OGG_CONTINUED = 0x01
+	OGG_BOS       = 0x02
+	OGG_EOS       = 0x04
+
+	// OGG_NO_GRANULE marks a page on which no packet ends.
+	OGG_NO_GRANULE = ^uint64(0)
+)
+
+var oggCapturePattern = []byte("OggS")
+
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// oggPage is a page of an Ogg bitstream (RFC 3533). Segments is the lacing
+// table, a packet ends at every segment shorter than 255 bytes.
+type oggPage struct {
+	HeaderType byte
+	Granule    uint64
+	Serial     uint32
+	Sequence   uint32
+	Segments   []byte
+	Body       []byte
+}
+
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// parseOggPages splits an Ogg file into its pages and checks their
+// checksums.
+func parseOggPages(data []byte) ([]oggPage, error) {
+	var pages []oggPage
+	for offset := 0; offset < len(data); {
+		if len(data)-offset < OGG_HEADER_SIZE || !bytes.Equal(data[offset:offset+4], oggCapturePattern) {
+			return nil, fmt.Errorf("no Ogg page at byte %d", offset)
+		}
+		header := data[offset : offset+OGG_HEADER_SIZE]
+		if header[4] != 0 {
+			return nil, fmt.Errorf("unsupported Ogg version %d", header[4])
+		}
+
+		count := int(header[26])
+		if len(data)-offset < OGG_HEADER_SIZE+count {
+			return nil, errors.New("truncated Ogg segment table")
+		}
+		segments := data[offset+OGG_HEADER_SIZE : offset+OGG_HEADER_SIZE+count]
+		size := 0
+		for _, segment := range segments {
+			size += int(segment)
+		}
+		end := offset + OGG_HEADER_SIZE + count + size
+		if end > len(data) {
+			return nil, errors.New("truncated Ogg page")
+		}
+
+		page := oggPage{
+			HeaderType: header[5],
+			Granule:    binary.LittleEndian.Uint64(header[6:14]),
+			Serial:     binary.LittleEndian.Uint32(header[14:18]),
+			Sequence:   binary.LittleEndian.Uint32(header[18:22]),
+			Segments:   append([]byte{}, segments...),
+			Body:       append([]byte{}, data[offset+OGG_HEADER_SIZE+count:end]...),
+		}
+		if checksum := binary.LittleEndian.Uint32(header[22:26]); checksum != oggCRC(page.unsigned()) {
+			return nil, fmt.Errorf("bad checksum of Ogg page %d", page.Sequence)
+		}
+		pages = append(pages, page)
+		offset = end
+	}
+
+	return pages, nil
+}
+
+// bytes encodes the page with its checksum.
+func (page oggPage) bytes() []byte {
+	buf := page.unsigned()
+	binary.LittleEndian.PutUint32(buf[22:26], oggCRC(buf))
+	return buf
+}
+
+// unsigned encodes the page with a zero checksum field, which is what the
+// checksum is computed over.
+func (page oggPage) unsigned() []byte {
+	buf := make([]byte, OGG_HEADER_SIZE, OGG_HEADER_SIZE+len(page.Segments)+len(page.Body))
+	copy(buf, oggCapturePattern)
+	buf[5] = page.HeaderType
+	binary.LittleEndian.PutUint64(buf[6:14], page.Granule)
+	binary.LittleEndian.PutUint32(buf[14:18], page.Serial)
+	binary.LittleEndian.PutUint32(buf[18:22], page.Sequence)
+	buf[26] = byte(len(page.Segments))
+	buf = append(buf, page.Segments...)
+	return append(buf, page.Body...)
+}
+
+// endsPacket tells if a packet is completed on the page.
+func (page oggPage) endsPacket() bool {
+	for _, segment := range page.Segments {
+		if segment < 255 {
+			return true
+		}
+	}
+	return false
+}
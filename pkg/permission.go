@@ -0,0 +1,183 @@
+package pkg
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	ADMIN_CACHE_TTL = 5 * time.Minute
+	// ADMIN_RETRY_TTL is how long a failed getChatAdministrators is not
+	// tried again, the last known administrators are used meanwhile
+	ADMIN_RETRY_TTL = 30 * time.Second
+)
+
+type adminEntry struct {
+	ids     map[int64]bool
+	expires time.Time
+}
+
+// AdminCache remembers the administrators of each chat for a while, so
+// pressing a control button does not call getChatAdministrators each time.
+type AdminCache struct {
+	bot     BotClient
+	ttl     time.Duration
+	entries map[int64]adminEntry
+
+	lock sync.Mutex
+}
+
+func NewAdminCache(bot BotClient, ttl time.Duration) *AdminCache {
+	return &AdminCache{
+		bot:     bot,
+		ttl:     ttl,
+		entries: make(map[int64]adminEntry),
+	}
+}
+
+func (cache *AdminCache) IsAdmin(chatId int64, userId int64) bool {
+	cache.lock.Lock()
+	entry, ok := cache.entries[chatId]
+	cache.lock.Unlock()
+
+	if !ok || time.Now().After(entry.expires) {
+		ids, err := cache.fetch(chatId)
+		if err != nil {
+			// every press of a control button would call it again
+			log.Errorf("get administrators of chat %d error: %s", chatId, err.Error())
+			entry.expires = time.Now().Add(ADMIN_RETRY_TTL)
+		} else {
+			entry = adminEntry{ids: ids, expires: time.Now().Add(cache.ttl)}
+		}
+
+		cache.lock.Lock()
+		cache.entries[chatId] = entry
+		cache.lock.Unlock()
+	}
+
+	return entry.ids[userId]
+}
+
+// Forget drops the cached administrators of the chat.
+func (cache *AdminCache) Forget(chatId int64) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	delete(cache.entries, chatId)
+}
+
+func (cache *AdminCache) fetch(chatId int64) (map[int64]bool, error) {
+	res, err := cache.bot.Request(tgbotapi.ChatAdministratorsConfig{
+		ChatConfig: tgbotapi.ChatConfig{ChatID: chatId},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var members []tgbotapi.ChatMember
+	if err := json.Unmarshal(res.Result, &members); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int64]bool)
+	for _, member := range members {
+		if member.User != nil {
+			ids[member.User.ID] = true
+		}
+	}
+	return ids, nil
+}
+
+// canControl tells whether the user is the host ("nhà cái") of the lobby or
+// an administrator of the chat.
+func (handler *MessageHandler) canControl(game *Lobby, userId int64) bool {
+	if game.HostId != 0 && game.HostId == userId {
+		return true
+	}
+
+	return handler.admins.IsAdmin(game.ChatId, userId)
+}
+
+// lockControlledGame is lockGame for the lobby control buttons, it fails
+// with a PermissionError when the presser is not allowed to use them.
+func (handler *MessageHandler) lockControlledGame(chatId int64, user *tgbotapi.User) (*Lobby, error) {
+	game, err := handler.lockGame(chatId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !handler.canControl(game, user.ID) {
+		game.lock.Unlock()
+		return nil, PermissionError("control.host_only", game.HostName)
+	}
+
+	return game, nil
+}
+
+// handOver makes another user the host of the lobby. The new host is the
+// author of the replied message or a registered player mentioned by
+// @username.
+func (handler *MessageHandler) handOver(update *tgbotapi.Update) error {
+	chatId := update.Message.Chat.ID
+	currentGame, err := handler.lockControlledGame(chatId, update.Message.From)
+	if err != nil {
+		return err
+	}
+	defer currentGame.lock.Unlock()
+
+	username := strings.TrimSpace(update.Message.CommandArguments())
+	if len(username) == 0 && update.Message.ReplyToMessage == nil {
+		return UserError("host.usage", CMD_HOST)
+	}
+	hostId, hostName, found := mentionedUser(update.Message, currentGame, username)
+	if !found {
+		return UserError("host.not_found", username, CMD_HOST)
+	}
+	if len(hostName) == 0 {
+		return UserError("host.no_username")
+	}
+
+	currentGame.HostId = hostId
+	currentGame.HostName = hostName
+	handler.logEvent(currentGame, GameEvent{Kind: EVENT_HOST, UserId: hostId, Username: hostName})
+
+	handler.saveGame(currentGame)
+	handler.updateListPlayerState(currentGame)
+
+	msg := tgbotapi.NewMessage(chatId, T(handler.chatLanguage(chatId), "host.changed", hostName))
+	msg.ReplyToMessageID = currentGame.GameId
+	handler.sendMessage(msg)
+
+	return nil
+}
+
+// mentionedUser finds the user a command is about: the author of the
+// replied message, a text mention, or a player of the lobby mentioned by
+// @username. Bots cannot look up other users by username.
+func mentionedUser(message *tgbotapi.Message, game *Lobby, username string) (int64, string, bool) {
+	if reply := message.ReplyToMessage; reply != nil && reply.From != nil && !reply.From.IsBot {
+		return reply.From.ID, reply.From.UserName, true
+	}
+	for _, entity := range message.Entities {
+		if entity.Type == "text_mention" && entity.User != nil {
+			return entity.User.ID, entity.User.UserName, true
+		}
+	}
+
+	username = strings.TrimPrefix(username, "@")
+	if game == nil || len(username) == 0 {
+		return 0, "", false
+	}
+	for _, player := range game.players {
+		if strings.EqualFold(player.Username, username) {
+			return player.Id, player.Username, true
+		}
+	}
+
+	return 0, "", false
+}
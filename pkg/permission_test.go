@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+func TestLobbyControlPermissions(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1002)
+	host := telegramtest.User(10, "lotovn_host")
+	admin := telegramtest.User(20, "group_admin")
+	stranger := telegramtest.User(30, "stranger")
+	player := telegramtest.User(40, "player_teo")
+
+	server.Handle("getChatAdministrators", func(req telegramtest.Request) (interface{}, error) {
+		return []tgbotapi.ChatMember{{User: admin, Status: "administrator"}}, nil
+	})
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame"))
+	game := handler.lobbies.Get(group.ID)
+	if game == nil || game.HostId != host.ID {
+		t.Fatal("opener did not become the host")
+	}
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+
+	// strangers only get an alert and the game does not move
+	server.Reset()
+	Dispatch(handler, telegramtest.CallbackUpdate(group, stranger, game.GameId, QUERY_DATA_START))
+	if game.lifecycle.status() != LOBBY {
+		t.Fatal("stranger started the game")
+	}
+	answers := server.Requests("answerCallbackQuery")
+	if len(answers) != 1 || answers[0].Params.Get("show_alert") != "true" {
+		t.Fatal("stranger did not get a callback alert")
+	}
+	if len(server.Sent(group.ID)) != 0 {
+		t.Fatal("denied control was posted to the group")
+	}
+
+	// the host and chat administrators may control the game
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_START))
+	if game.lifecycle.status() != STARTED {
+		t.Fatal("host could not start the game")
+	}
+	Dispatch(handler, telegramtest.CallbackUpdate(group, admin, game.GameId, QUERY_DATA_PAUSE))
+	if game.lifecycle.status() != PAUSED {
+		t.Fatal("administrator could not pause the game")
+	}
+	Dispatch(handler, telegramtest.CallbackUpdate(group, stranger, game.GameId, QUERY_DATA_RESUME))
+	if game.lifecycle.status() != PAUSED {
+		t.Fatal("stranger resumed the game")
+	}
+	if calls := len(server.Requests("getChatAdministrators")); calls != 1 {
+		t.Fatalf("expected cached administrators, got %d calls", calls)
+	}
+
+	// hand the game over to a registered player
+	Dispatch(handler, telegramtest.MessageUpdate(group, stranger, 2, "/host @player_teo"))
+	if game.HostId != host.ID {
+		t.Fatal("stranger handed the game over")
+	}
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 3, "/host @player_teo"))
+	if game.HostId != player.ID || game.HostName != player.UserName {
+		t.Fatal("host did not hand the game over")
+	}
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_RESUME))
+	if game.lifecycle.status() != PAUSED {
+		t.Fatal("former host still controls the game")
+	}
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_STOP))
+	if handler.lobbies.Get(group.ID) != nil {
+		t.Fatal("new host could not finish the game")
+	}
+}
+
+func TestAdminCacheFailures(t *testing.T) {
+	server := telegramtest.NewServer()
+	t.Cleanup(server.Close)
+	bot, err := server.Bot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := telegramtest.Group(-1063)
+	admin := telegramtest.User(20, "group_admin")
+	failing := false
+	server.Handle("getChatAdministrators", func(req telegramtest.Request) (interface{}, error) {
+		if failing {
+			return nil, errors.New("Bad Gateway")
+		}
+		return []tgbotapi.ChatMember{{User: admin, Status: "administrator"}}, nil
+	})
+
+	cache := NewAdminCache(bot, 0)
+	if !cache.IsAdmin(group.ID, admin.ID) {
+		t.Fatal("administrator was not found")
+	}
+
+	// the last known administrators are kept, the failure is not retried
+	// at every press
+	failing = true
+	time.Sleep(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if !cache.IsAdmin(group.ID, admin.ID) {
+			t.Fatal("administrator was lost with a failed fetch")
+		}
+	}
+	if calls := len(server.Requests("getChatAdministrators")); calls != 2 {
+		t.Fatalf("expected the failure to be cached, got %d calls", calls)
+	}
+
+	// a chat never fetched has no administrators until the fetch works
+	if cache.IsAdmin(-1064, admin.ID) || cache.IsAdmin(-1064, admin.ID) {
+		t.Fatal("administrator was made up")
+	}
+	if calls := len(server.Requests("getChatAdministrators")); calls != 3 {
+		t.Fatalf("expected the failure to be cached, got %d calls", calls)
+	}
+}
@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// REGISTRATION_CHECK_INTERVAL is how often a lobby which starts on its own
+// checks whether its registration is over.
+const REGISTRATION_CHECK_INTERVAL = time.Second
+
+// autoStarts tells whether the lobby starts the draw on its own.
+func (lobby *Lobby) autoStarts() bool {
+	return lobby.minPlayers > 0 || lobby.maxPlayers > 0 || !lobby.startAt.IsZero()
+}
+
+// setCountdown changes the countdown of the lobby, the time already
+// counted down is kept.
+func (lobby *Lobby) setCountdown(countdown time.Duration) {
+	switch {
+	case countdown == 0:
+		lobby.startAt = time.Time{}
+	case lobby.startAt.IsZero():
+		lobby.startAt = time.Now().Add(countdown)
+	default:
+		lobby.startAt = lobby.startAt.Add(countdown - lobby.countdown)
+	}
+	lobby.countdown = countdown
+}
+
+// countdownLabel is the time left before the draw, in minutes and in steps
+// of 10 seconds for the last minute so the lobby message is not edited
+// every second.
+func (lobby *Lobby) countdownLabel(now time.Time) string {
+	if lobby.startAt.IsZero() || lobby.lifecycle.status() != LOBBY {
+		return ""
+	}
+
+	left := lobby.startAt.Sub(now)
+	switch {
+	case left <= 0:
+		return "0s"
+	case left > time.Minute:
+		return shortDuration((left + time.Minute - 1) / time.Minute * time.Minute)
+	}
+	return shortDuration((left + 10*time.Second - 1) / (10 * time.Second) * (10 * time.Second))
+}
+
+// watchLobby watches the registration of the locked lobby when it starts on
+// its own.
+func (handler *MessageHandler) watchLobby(game *Lobby) {
+	if game.watching || !game.autoStarts() {
+		return
+	}
+	game.watching = true
+
+	go handler.watchRegistration(game)
+}
+
+// watchRegistration starts the draw of the lobby once its registration is
+// over and refreshes the countdown of the lobby message meanwhile, until
+// the lobby is started or closed.
+func (handler *MessageHandler) watchRegistration(game *Lobby) {
+	ticker := time.NewTicker(REGISTRATION_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		game.lock.Lock()
+		done := handler.lobbies.Get(game.ChatId) != game || !game.autoStarts() || handler.checkRegistration(game)
+		if done {
+			game.watching = false
+		} else if game.countdownLabel(time.Now()) != game.shownCountdown {
+			handler.updateListPlayerState(game)
+		}
+		game.lock.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// checkRegistration starts the draw of the locked lobby when it is full,
+// when the minimum of players registered or when the countdown runs out
+// with enough players. A lobby still short of players then is cancelled
+// and the stakes are refunded. It returns whether the registration is
+// over.
+func (handler *MessageHandler) checkRegistration(game *Lobby) bool {
+	if game.lifecycle.status() != LOBBY {
+		return true
+	}
+
+	players := len(game.players)
+	full := game.maxPlayers > 0 && players >= game.maxPlayers
+	enough := game.minPlayers > 0 && players >= game.minPlayers
+	if full || enough {
+		handler.startGame(game, GameEvent{Kind: EVENT_STARTED})
+		return true
+	}
+	if game.startAt.IsZero() || time.Now().Before(game.startAt) {
+		return false
+	}
+
+	needed := game.minPlayers
+	if needed == 0 {
+		needed = 1
+	}
+	if players >= needed {
+		handler.startGame(game, GameEvent{Kind: EVENT_STARTED})
+		return true
+	}
+
+	handler.cancelLobby(game, players, needed)
+
+	return true
+}
+
+// cancelLobby closes the locked lobby which is short of players without
+// the end of game messages, nothing was drawn. The stakes are refunded.
+func (handler *MessageHandler) cancelLobby(game *Lobby, players int, needed int) {
+	game.lifecycle.stop()
+	handler.updateListPlayerState(game)
+
+	msg := tgbotapi.NewMessage(game.ChatId, T(handler.chatLanguage(game.ChatId), "lobby.cancelled", game.GameId, players, needed))
+	msg.ReplyToMessageID = game.GameId
+	handler.sendMessage(msg)
+
+	handler.closeLobby(game, false, GameEvent{})
+}
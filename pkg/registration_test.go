@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+func TestCountdownLabel(t *testing.T) {
+	now := time.Now()
+	lobby := &Lobby{lifecycle: NewGame(time.Second, DefaultGameSettings().Ticket)}
+	if label := lobby.countdownLabel(now); label != "" {
+		t.Fatalf("lobby without countdown shows %q", label)
+	}
+
+	labels := map[time.Duration]string{
+		4*time.Minute + 30*time.Second: "5m",
+		61 * time.Second:               "2m",
+		45 * time.Second:               "50s",
+		time.Second:                    "10s",
+		-time.Second:                   "0s",
+	}
+	for left, want := range labels {
+		lobby.startAt = now.Add(left)
+		if label := lobby.countdownLabel(now); label != want {
+			t.Errorf("%s left: expected %q, got %q", left, want, label)
+		}
+	}
+}
+
+func TestFullLobbyStarts(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	group := telegramtest.Group(-1050)
+	host := telegramtest.User(10, "lotovn_host")
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame maxplayers=2 countdown=10m"))
+	game := handler.lobbies.Get(group.ID)
+	Dispatch(handler, telegramtest.CallbackUpdate(group, telegramtest.User(11, "player_teo"), game.GameId, QUERY_DATA_REGISTER))
+	Dispatch(handler, telegramtest.CallbackUpdate(group, telegramtest.User(12, "player_ti"), game.GameId, QUERY_DATA_REGISTER))
+
+	game.lock.Lock()
+	status := game.lifecycle.status()
+	game.lifecycle.stop()
+	game.lock.Unlock()
+	if status != STARTED {
+		t.Fatal("full lobby did not start")
+	}
+}
+
+func TestCountdownCancelsLobby(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1051)
+	host := telegramtest.User(10, "lotovn_host")
+	player := telegramtest.User(11, "player_teo")
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame price=30 minplayers=2 countdown=5m"))
+	game := handler.lobbies.Get(group.ID)
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	if balance := balanceOf(t, handler, group.ID, player.ID); balance != DAILY_ALLOWANCE-30 {
+		t.Fatalf("ticket was not paid, balance %d", balance)
+	}
+	edits := server.Requests("editMessageText")
+	if len(edits) == 0 || !strings.Contains(edits[len(edits)-1].Text(), T(DEFAULT_LANGUAGE, "lobby.countdown")+" <b>5m</b>") {
+		t.Fatal("lobby message does not show the countdown")
+	}
+
+	// the countdown runs out with one player out of two
+	game.lock.Lock()
+	game.startAt = time.Now()
+	game.lock.Unlock()
+	closed := server.WaitFor(5*time.Second, func(requests []telegramtest.Request) bool {
+		return handler.lobbies.Get(group.ID) == nil
+	})
+	if !closed || countTexts(server.Sent(group.ID), group.ID, T(DEFAULT_LANGUAGE, "lobby.cancelled", game.GameId, 1, 2)) != 1 {
+		t.Fatal("lobby short of players was not cancelled")
+	}
+	if balance := balanceOf(t, handler, group.ID, player.ID); balance != DAILY_ALLOWANCE {
+		t.Fatalf("stake was not refunded, balance %d", balance)
+	}
+	// nothing was drawn, so there is no end of game
+	if countTexts(server.Sent(group.ID), group.ID, T(DEFAULT_LANGUAGE, "game.finished")) != 0 ||
+		len(server.Requests("sendPhoto")) != 0 {
+		t.Fatal("cancelled lobby was finished like a played game")
+	}
+	if events, err := handler.storage.LoadEvents(group.ID, game.GameId); err != nil || len(events) != 0 {
+		t.Fatalf("cancelled lobby kept its events %+v (%v)", events, err)
+	}
+}
@@ -0,0 +1,56 @@
+package pkg
+
+import "sync"
+
+// LobbyRegistry keeps the lobby of every chat. The registry only guards the
+// map itself, the state of a lobby is guarded by the lock of the lobby.
+type LobbyRegistry struct {
+	lobbies map[int64]*Lobby
+
+	lock sync.RWMutex
+}
+
+func NewLobbyRegistry() *LobbyRegistry {
+	return &LobbyRegistry{
+		lobbies: make(map[int64]*Lobby),
+	}
+}
+
+func (registry *LobbyRegistry) Get(chatId int64) *Lobby {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return registry.lobbies[chatId]
+}
+
+// Register adds the lobby unless its chat already has one, in which case
+// the existing lobby is returned with false.
+func (registry *LobbyRegistry) Register(lobby *Lobby) (*Lobby, bool) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if existed := registry.lobbies[lobby.ChatId]; existed != nil {
+		return existed, false
+	}
+	registry.lobbies[lobby.ChatId] = lobby
+
+	return lobby, true
+}
+
+// Remove drops the lobby of its chat, a newer lobby of the same chat is
+// left untouched.
+func (registry *LobbyRegistry) Remove(lobby *Lobby) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if registry.lobbies[lobby.ChatId] == lobby {
+		delete(registry.lobbies, lobby.ChatId)
+	}
+}
+
+func (registry *LobbyRegistry) Len() int {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return len(registry.lobbies)
+}
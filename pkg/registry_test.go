@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+func newRaceTestHandler(t *testing.T) *MessageHandler {
+	handler, _ := newTestHandler(t)
+	return handler
+}
+
+// newTestHandler wires a handler to a fake Telegram server and a throwaway
+// bolt file.
+func newTestHandler(t *testing.T) (*MessageHandler, *telegramtest.Server) {
+	server := telegramtest.NewServer()
+	t.Cleanup(server.Close)
+
+	bot, err := server.Bot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := NewBoltStorage(filepath.Join(t.TempDir(), "lotovn.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+
+	return NewHandler(bot, storage, nil, nil, nil).(*MessageHandler), server
+}
+
+func newCallbackUpdate(chatId int64, userId int64, data string) *tgbotapi.Update {
+	return &tgbotapi.Update{
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID: "1",
+			From: &tgbotapi.User{
+				ID:       userId,
+				UserName: fmt.Sprintf("player%d", userId),
+			},
+			Message: &tgbotapi.Message{
+				MessageID: 1,
+				Chat:      &tgbotapi.Chat{ID: chatId},
+			},
+			Data: data,
+		},
+	}
+}
+
+func TestLobbyRegistryConcurrent(t *testing.T) {
+	registry := NewLobbyRegistry()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(chatId int64) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				lobby := &Lobby{ChatId: chatId}
+				if registered, created := registry.Register(lobby); created {
+					registry.Get(chatId)
+					registry.Remove(registered)
+				}
+			}
+		}(int64(i % 5))
+	}
+	wg.Wait()
+
+	if registry.Len() != 0 {
+		t.Fatalf("expected empty registry, got %d lobbies", registry.Len())
+	}
+}
+
+func TestLobbyLifecycleConcurrent(t *testing.T) {
+	handler := newRaceTestHandler(t)
+	chatId := int64(-100)
+
+	handler.openGame(&tgbotapi.Update{
+		Message: &tgbotapi.Message{
+			MessageID: 1,
+			From:      &tgbotapi.User{ID: 1, UserName: "lotovn_host"},
+			Chat:      &tgbotapi.Chat{ID: chatId},
+		},
+	})
+	game := handler.lobbies.Get(chatId)
+	if game == nil {
+		t.Fatal("lobby was not opened")
+	}
+	game.lifecycle = NewGame(time.Millisecond, game.lifecycle.ticketConfig())
+
+	var wg sync.WaitGroup
+	for i := int64(1); i <= 10; i++ {
+		wg.Add(1)
+		go func(userId int64) {
+			defer wg.Done()
+			handler.register(newCallbackUpdate(chatId, userId, QUERY_DATA_REGISTER))
+		}(i)
+	}
+	wg.Wait()
+
+	controls := []func(update *tgbotapi.Update) error{
+		handler.start,
+		handler.pause,
+		handler.resume,
+	}
+	for i := 0; i < 30; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			controls[i%len(controls)](newCallbackUpdate(chatId, 1, ""))
+		}(i)
+		go func(userId int64) {
+			defer wg.Done()
+			data := fmt.Sprintf("%s;%d;%d;0", QUERY_DATA_WAIT, chatId, game.GameId)
+			handler.wait(newCallbackUpdate(chatId, userId, data))
+			data = fmt.Sprintf("%s;%d;%d;0", QUERY_DATA_BINGO, chatId, game.GameId)
+			handler.bingo(newCallbackUpdate(chatId, userId, data))
+		}(int64(i%10 + 1))
+	}
+	wg.Wait()
+
+	handler.finish(newCallbackUpdate(chatId, 1, QUERY_DATA_STOP))
+	if handler.lobbies.Get(chatId) != nil {
+		t.Fatal("lobby was not removed after finish")
+	}
+	if game.lifecycle.status() != STOPPED {
+		t.Fatalf("expected stopped game, got %d", game.lifecycle.status())
+	}
+}
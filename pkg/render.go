@@ -0,0 +1,273 @@
+package pkg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+)
+
+const (
+	RENDER_CELL_SIZE   = 44
+	RENDER_PADDING     = 12
+	RENDER_LINE_WIDTH  = 2
+	RENDER_GLYPH_SCALE = 3
+)
+
+// digitGlyphs is a 5x7 pixel font, enough to draw every number of the game
+// without shipping a font file.
+var digitGlyphs = [10][7]string{
+	{"01110", "10001", "10011", "10101", "11001", "10001", "01110"},
+	{"00100", "01100", "00100", "00100", "00100", "00100", "01110"},
+	{"01110", "10001", "00001", "00010", "00100", "01000", "11111"},
+	{"11110", "00001", "00001", "01110", "00001", "00001", "11110"},
+	{"00010", "00110", "01010", "10010", "11111", "00010", "00010"},
+	{"11111", "10000", "11110", "00001", "00001", "10001", "01110"},
+	{"00110", "01000", "10000", "11110", "10001", "10001", "01110"},
+	{"11111", "00001", "00010", "00100", "01000", "01000", "01000"},
+	{"01110", "10001", "10001", "01110", "10001", "10001", "01110"},
+	{"01110", "10001", "10001", "01111", "00001", "00010", "01100"},
+}
+
+// TicketColors are the colors paper tickets are printed in.
+var TicketColors = []color.RGBA{
+	{R: 0xd3, G: 0x2f, B: 0x2f, A: 0xff},
+	{R: 0x38, G: 0x8e, B: 0x3c, A: 0xff},
+	{R: 0x19, G: 0x76, B: 0xd2, A: 0xff},
+	{R: 0xf9, G: 0xa8, B: 0x25, A: 0xff},
+	{R: 0x7b, G: 0x1f, B: 0xa2, A: 0xff},
+}
+
+var (
+	colorWhite     = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	colorInk       = color.RGBA{R: 0x21, G: 0x21, B: 0x21, A: 0xff}
+	colorCross     = color.RGBA{R: 0xb7, G: 0x1c, B: 0x1c, A: 0xff}
+	colorHighlight = color.RGBA{R: 0xff, G: 0xf1, B: 0x76, A: 0xff}
+	colorIdle      = color.RGBA{R: 0xee, G: 0xee, B: 0xee, A: 0xff}
+	colorIdleInk   = color.RGBA{R: 0x9e, G: 0x9e, B: 0x9e, A: 0xff}
+	colorLatest    = color.RGBA{R: 0xff, G: 0xb3, B: 0x00, A: 0xff}
+)
+
+// TicketPicture draws a ticket like the paper card: numbers on white cells,
+// empty cells in the color of the ticket, called numbers crossed out and
+// highlighted rows (e.g. the winning ones) on a yellow background.
+type TicketPicture struct {
+	Board      [][]int
+	Called     []int
+	Highlights []int
+	Color      color.RGBA
+}
+
+func (ticket *Ticket) picture(called []int, highlights []int) TicketPicture {
+	ticket.lock.RLock()
+	defer ticket.lock.RUnlock()
+
+	var board [][]int
+	for _, row := range ticket.board {
+		board = append(board, append([]int{}, row...))
+	}
+
+	return TicketPicture{
+		Board:      board,
+		Called:     called,
+		Highlights: highlights,
+		Color:      TicketColors[int(ticket.Id.ID()%uint32(len(TicketColors)))],
+	}
+}
+
+func (picture TicketPicture) Render() *image.RGBA {
+	rows := len(picture.Board)
+	cols := 0
+	if rows > 0 {
+		cols = len(picture.Board[0])
+	}
+
+	img := newCanvas(cols, rows, picture.Color)
+
+	called := map[int]bool{}
+	for _, v := range picture.Called {
+		called[v] = true
+	}
+	highlights := map[int]bool{}
+	for _, i := range picture.Highlights {
+		highlights[i] = true
+	}
+
+	for i, row := range picture.Board {
+		for j, v := range row {
+			cell := cellRect(i, j)
+			if v <= 0 {
+				fill(img, cell, picture.Color)
+				continue
+			}
+
+			background := colorWhite
+			if highlights[i] {
+				background = colorHighlight
+			}
+			fill(img, cell, background)
+			drawNumber(img, cell, v, colorInk)
+			if called[v] {
+				drawCross(img, cell, colorCross)
+			}
+		}
+	}
+
+	drawGrid(img, cols, rows, colorInk)
+	// thicker lines between the blocks of a traditional ticket
+	if rows == ROW_SIZE {
+		for i := BLOCK_SIZE; i < rows; i += BLOCK_SIZE {
+			y := RENDER_PADDING + i*RENDER_CELL_SIZE
+			fill(img, image.Rect(RENDER_PADDING, y-RENDER_LINE_WIDTH, RENDER_PADDING+cols*RENDER_CELL_SIZE, y+RENDER_LINE_WIDTH), colorInk)
+		}
+	}
+
+	return img
+}
+
+// ResultPicture draws every number of the space in its column, the drawn
+// ones highlighted and the latest one standing out.
+type ResultPicture struct {
+	Space NumberSpace
+	Drawn []int
+}
+
+func (picture ResultPicture) Render() *image.RGBA {
+	cols := picture.Space.Columns()
+	rows := 0
+	for j := 0; j < cols; j++ {
+		if size := len(picture.Space.Column(j)); size > rows {
+			rows = size
+		}
+	}
+
+	img := newCanvas(cols, rows, colorInk)
+
+	drawn := map[int]bool{}
+	for _, v := range picture.Drawn {
+		drawn[v] = true
+	}
+	latest := 0
+	if len(picture.Drawn) > 0 {
+		latest = picture.Drawn[len(picture.Drawn)-1]
+	}
+
+	for j := 0; j < cols; j++ {
+		column := picture.Space.Column(j)
+		for i := 0; i < rows; i++ {
+			cell := cellRect(i, j)
+			if i >= len(column) {
+				fill(img, cell, colorInk)
+				continue
+			}
+
+			v := column[i]
+			switch {
+			case v == latest:
+				fill(img, cell, colorLatest)
+				drawNumber(img, cell, v, colorInk)
+			case drawn[v]:
+				fill(img, cell, colorCross)
+				drawNumber(img, cell, v, colorWhite)
+			default:
+				fill(img, cell, colorIdle)
+				drawNumber(img, cell, v, colorIdleInk)
+			}
+		}
+	}
+
+	drawGrid(img, cols, rows, colorInk)
+
+	return img
+}
+
+func EncodePNG(img image.Image) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func newCanvas(cols int, rows int, background color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(
+		0,
+		0,
+		cols*RENDER_CELL_SIZE+2*RENDER_PADDING,
+		rows*RENDER_CELL_SIZE+2*RENDER_PADDING,
+	))
+	fill(img, img.Bounds(), background)
+
+	return img
+}
+
+func cellRect(row int, col int) image.Rectangle {
+	x := RENDER_PADDING + col*RENDER_CELL_SIZE
+	y := RENDER_PADDING + row*RENDER_CELL_SIZE
+
+	return image.Rect(x, y, x+RENDER_CELL_SIZE, y+RENDER_CELL_SIZE)
+}
+
+func fill(img *image.RGBA, rect image.Rectangle, c color.RGBA) {
+	draw.Draw(img, rect, &image.Uniform{C: c}, image.Point{}, draw.Src)
+}
+
+func drawGrid(img *image.RGBA, cols int, rows int, c color.RGBA) {
+	width := cols * RENDER_CELL_SIZE
+	height := rows * RENDER_CELL_SIZE
+	half := RENDER_LINE_WIDTH / 2
+
+	for i := 0; i <= rows; i++ {
+		y := RENDER_PADDING + i*RENDER_CELL_SIZE
+		fill(img, image.Rect(RENDER_PADDING, y-half, RENDER_PADDING+width, y+half), c)
+	}
+	for j := 0; j <= cols; j++ {
+		x := RENDER_PADDING + j*RENDER_CELL_SIZE
+		fill(img, image.Rect(x-half, RENDER_PADDING, x+half, RENDER_PADDING+height), c)
+	}
+}
+
+// drawNumber centers the number in the cell using the digit glyphs.
+func drawNumber(img *image.RGBA, cell image.Rectangle, number int, c color.RGBA) {
+	text := strconv.Itoa(number)
+	glyphWidth := 5 * RENDER_GLYPH_SCALE
+	glyphHeight := 7 * RENDER_GLYPH_SCALE
+	gap := RENDER_GLYPH_SCALE
+
+	width := len(text)*glyphWidth + (len(text)-1)*gap
+	x := cell.Min.X + (cell.Dx()-width)/2
+	y := cell.Min.Y + (cell.Dy()-glyphHeight)/2
+
+	for _, r := range text {
+		glyph := digitGlyphs[r-'0']
+		for gy, line := range glyph {
+			for gx, bit := range line {
+				if bit != '1' {
+					continue
+				}
+				px := x + gx*RENDER_GLYPH_SCALE
+				py := y + gy*RENDER_GLYPH_SCALE
+				fill(img, image.Rect(px, py, px+RENDER_GLYPH_SCALE, py+RENDER_GLYPH_SCALE), c)
+			}
+		}
+		x += glyphWidth + gap
+	}
+}
+
+// drawCross strikes the cell through with both diagonals.
+func drawCross(img *image.RGBA, cell image.Rectangle, c color.RGBA) {
+	margin := RENDER_CELL_SIZE / 6
+	size := cell.Dx() - 2*margin
+	thickness := 2
+
+	for i := 0; i <= size; i++ {
+		x := cell.Min.X + margin + i
+		down := cell.Min.Y + margin + i
+		up := cell.Max.Y - margin - i
+		fill(img, image.Rect(x-thickness, down-thickness, x+thickness, down+thickness), c)
+		fill(img, image.Rect(x-thickness, up-thickness, x+thickness, up+thickness), c)
+	}
+}
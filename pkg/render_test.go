@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden images in testdata")
+
+func assertGoldenImage(t *testing.T, name string, img image.Image) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		data, err := EncodePNG(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden image: %s (run go test -update to create it)", err)
+	}
+	golden, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if golden.Bounds() != img.Bounds() {
+		t.Fatalf("%s: expected bounds %v, got %v", name, golden.Bounds(), img.Bounds())
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, a1 := golden.At(x, y).RGBA()
+			r2, g2, b2, a2 := img.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				t.Fatalf("%s: pixel (%d, %d) differs from the golden image", name, x, y)
+			}
+		}
+	}
+}
+
+func TestRenderTicketGolden(t *testing.T) {
+	picture := TicketPicture{
+		Board: [][]int{
+			{3, 0, 21, 0, 45, 0, 63, 0, 80},
+			{0, 12, 0, 37, 0, 58, 0, 74, 0},
+			{7, 0, 0, 39, 0, 0, 66, 0, 90},
+		},
+		Called:     []int{3, 21, 45, 63, 80, 12},
+		Highlights: []int{0},
+		Color:      TicketColors[0],
+	}
+
+	assertGoldenImage(t, "ticket.png", picture.Render())
+}
+
+func TestRenderResultGolden(t *testing.T) {
+	picture := ResultPicture{
+		Space: NumberSpace{Max: CLASSIC_NUMBER_SPACE},
+		Drawn: []int{1, 90, 45, 23, 9, 10, 77},
+	}
+
+	assertGoldenImage(t, "result.png", picture.Render())
+}
@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// RHYME_STYLE_PLAIN calls the bare number, RHYME_STYLE_RHYME sings the
+	// "câu rao" only and RHYME_STYLE_BOTH sings it after the number.
+	RHYME_STYLE_PLAIN = "plain"
+	RHYME_STYLE_RHYME = "rhyme"
+	RHYME_STYLE_BOTH  = "both"
+
+	MAX_RHYME_LENGTH = 200
+)
+
+var rhymeStyles = []string{RHYME_STYLE_PLAIN, RHYME_STYLE_RHYME, RHYME_STYLE_BOTH}
+
+// RhymeCatalog holds the calling rhymes of every number, several variants
+// each. It is read from a JSON object of number -> list of rhymes.
+type RhymeCatalog struct {
+	rhymes map[int][]string
+	random *rand.Rand
+
+	lock sync.Mutex
+}
+
+func NewRhymeCatalog(rhymes map[int][]string) *RhymeCatalog {
+	return &RhymeCatalog{
+		rhymes: rhymes,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func LoadRhymeCatalog(path string) (*RhymeCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string][]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse rhymes %s: %w", path, err)
+	}
+	rhymes := make(map[int][]string)
+	for key, variants := range raw {
+		number, err := strconv.Atoi(key)
+		if err != nil || number < 1 || number > CLASSIC_NUMBER_SPACE {
+			return nil, fmt.Errorf("rhymes %s: %q is not a number from 1 to %d", path, key, CLASSIC_NUMBER_SPACE)
+		}
+		for _, rhyme := range variants {
+			if err := validateRhyme(rhyme); err != nil {
+				return nil, fmt.Errorf("rhymes %s: number %d: %w", path, number, err)
+			}
+			rhymes[number] = append(rhymes[number], strings.TrimSpace(rhyme))
+		}
+	}
+
+	return NewRhymeCatalog(rhymes), nil
+}
+
+func validateRhyme(rhyme string) error {
+	rhyme = strings.TrimSpace(rhyme)
+	if len(rhyme) == 0 {
+		return fmt.Errorf("empty rhyme")
+	}
+	if len([]rune(rhyme)) > MAX_RHYME_LENGTH {
+		return fmt.Errorf("rhyme longer than %d characters", MAX_RHYME_LENGTH)
+	}
+	return nil
+}
+
+// pick chooses a random rhyme of the number among the catalog and the
+// extra rhymes contributed by the group.
+func (catalog *RhymeCatalog) pick(number int, extra []string) (string, bool) {
+	variants := append(append([]string{}, catalog.rhymes[number]...), extra...)
+	if len(variants) == 0 {
+		return "", false
+	}
+
+	catalog.lock.Lock()
+	defer catalog.lock.Unlock()
+
+	return variants[catalog.random.Intn(len(variants))], true
+}
+
+func rhymeStyleLabel(language string, style string) string {
+	switch style {
+	case RHYME_STYLE_RHYME:
+		return T(language, "style.rhyme")
+	case RHYME_STYLE_BOTH:
+		return T(language, "style.both")
+	default:
+		return T(language, "style.plain")
+	}
+}
+
+func isRhymeStyle(style string) bool {
+	for _, s := range rhymeStyles {
+		if s == style {
+			return true
+		}
+	}
+	return false
+}
+
+func nextRhymeStyle(style string) string {
+	for i, s := range rhymeStyles {
+		if s == style {
+			return rhymeStyles[(i+1)%len(rhymeStyles)]
+		}
+	}
+	return RHYME_STYLE_PLAIN
+}
+
+// announcement is the text of a released number in the style of the lobby.
+func (handler *MessageHandler) announcement(game *Lobby, number int) string {
+	plain := T(handler.chatLanguage(game.ChatId), "announce.number", number)
+	if game.rhymeStyle == RHYME_STYLE_PLAIN || game.rhymeStyle == "" {
+		return plain
+	}
+
+	extra, err := handler.storage.LoadRhymes(game.ChatId, number)
+	if err != nil {
+		log.Errorf("load rhymes of chat %d error: %s", game.ChatId, err.Error())
+	}
+	rhyme, ok := handler.rhymes.pick(number, extra)
+	if !ok {
+		return plain
+	}
+	if game.rhymeStyle == RHYME_STYLE_RHYME {
+		return fmt.Sprintf("🎶 %s", rhyme)
+	}
+
+	return fmt.Sprintf("%s\n🎶 %s", plain, rhyme)
+}
+
+// addRhyme lets chat administrators teach the bot a rhyme of the group:
+// "/addrhyme 31 Ba mươi mốt, tuổi thanh xuân".
+func (handler *MessageHandler) addRhyme(update *tgbotapi.Update) (string, error) {
+	chatId := update.Message.Chat.ID
+	if !handler.admins.IsAdmin(chatId, update.Message.From.ID) {
+		return "", PermissionError("rhyme.admin_only")
+	}
+
+	args := strings.SplitN(strings.TrimSpace(update.Message.CommandArguments()), " ", 2)
+	if len(args) != 2 {
+		return "", UserError("rhyme.usage", CMD_ADD_RHYME)
+	}
+	number, err := strconv.Atoi(args[0])
+	if err != nil || number < 1 || number > CLASSIC_NUMBER_SPACE {
+		return "", UserError("rhyme.bad_number", CLASSIC_NUMBER_SPACE)
+	}
+	rhyme := strings.TrimSpace(args[1])
+	if err := validateRhyme(rhyme); err != nil {
+		return "", UserError("rhyme.bad_text", MAX_RHYME_LENGTH)
+	}
+
+	if err := handler.storage.AddRhyme(chatId, number, rhyme); err != nil {
+		return "", InternalError(err)
+	}
+
+	return T(handler.chatLanguage(chatId), "rhyme.added", number, rhyme), nil
+}
@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+func TestLoadRhymeCatalog(t *testing.T) {
+	catalog, err := LoadRhymeCatalog("../config/rhymes.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.rhymes[31]) == 0 {
+		t.Fatal("expected a rhyme for 31")
+	}
+
+	invalids := []string{
+		`{"0": ["Số không"]}`,
+		`{"91": ["Chín mốt"]}`,
+		`{"ba": ["Số ba"]}`,
+		`{"3": ["  "]}`,
+		`[]`,
+	}
+	for _, content := range invalids {
+		path := filepath.Join(t.TempDir(), "rhymes.json")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadRhymeCatalog(path); err == nil {
+			t.Errorf("expected %s to be rejected", content)
+		}
+	}
+}
+
+func TestAnnouncementStyles(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	handler.rhymes = NewRhymeCatalog(map[int][]string{31: {"Ba mươi mốt, tuổi thanh xuân"}})
+	game := &Lobby{ChatId: -1003}
+
+	expected := map[string]string{
+		RHYME_STYLE_PLAIN: "Số 31",
+		RHYME_STYLE_RHYME: "🎶 Ba mươi mốt, tuổi thanh xuân",
+		RHYME_STYLE_BOTH:  "Số 31\n🎶 Ba mươi mốt, tuổi thanh xuân",
+	}
+	for style, text := range expected {
+		game.rhymeStyle = style
+		if got := handler.announcement(game, 31); got != text {
+			t.Errorf("style %s: expected %q, got %q", style, text, got)
+		}
+	}
+
+	// numbers without a rhyme are called plainly in every style
+	game.rhymeStyle = RHYME_STYLE_RHYME
+	if got := handler.announcement(game, 32); got != "Số 32" {
+		t.Errorf("expected the plain number without a rhyme, got %q", got)
+	}
+}
+
+func TestAddRhyme(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1004)
+	admin := telegramtest.User(10, "group_admin")
+	member := telegramtest.User(20, "member")
+
+	server.Handle("getChatAdministrators", func(req telegramtest.Request) (interface{}, error) {
+		return []tgbotapi.ChatMember{{User: admin, Status: "administrator"}}, nil
+	})
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, member, 1, "/addrhyme 7 Bảy bảy, con gà lên chuồng"))
+	if rhymes, _ := handler.storage.LoadRhymes(group.ID, 7); len(rhymes) != 0 {
+		t.Fatal("a member added a rhyme")
+	}
+	Dispatch(handler, telegramtest.MessageUpdate(group, admin, 2, "/addrhyme 91 Chín mốt"))
+	Dispatch(handler, telegramtest.MessageUpdate(group, admin, 3, "/addrhyme 7"))
+	Dispatch(handler, telegramtest.MessageUpdate(group, admin, 4, "/addrhyme 7 Bảy bảy, con gà lên chuồng"))
+
+	rhymes, err := handler.storage.LoadRhymes(group.ID, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rhymes) != 1 || rhymes[0] != "Bảy bảy, con gà lên chuồng" {
+		t.Fatalf("unexpected rhymes %v", rhymes)
+	}
+
+	// the rhymes of a group are only sung in that group
+	game := &Lobby{ChatId: group.ID, rhymeStyle: RHYME_STYLE_BOTH}
+	if got := handler.announcement(game, 7); !strings.HasSuffix(got, "con gà lên chuồng") {
+		t.Fatalf("expected the group rhyme, got %q", got)
+	}
+	game.ChatId = -1005
+	if got := handler.announcement(game, 7); got != "Số 7" {
+		t.Fatalf("expected another group to call the plain number, got %q", got)
+	}
+}
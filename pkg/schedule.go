@@ -0,0 +1,376 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// the time zones are looked up by name, also on hosts without tzdata
+	_ "time/tzdata"
+
+	"github.com/apex/log"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	DEFAULT_TIMEZONE = "Asia/Ho_Chi_Minh"
+
+	// SCHEDULE_WINDOW is how long a scheduled lobby takes registrations
+	// unless the schedule says otherwise.
+	SCHEDULE_WINDOW     = 10 * time.Minute
+	MIN_SCHEDULE_WINDOW = time.Minute
+	MAX_SCHEDULE_WINDOW = 2 * time.Hour
+
+	MAX_SCHEDULES_PER_CHAT = 10
+
+	// SCHEDULE_CHECK_INTERVAL is how often the due schedules are looked up
+	SCHEDULE_CHECK_INTERVAL = 15 * time.Second
+	// SCHEDULE_GRACE is how late a lobby is still opened, e.g. after a
+	// restart. Older occurrences are skipped.
+	SCHEDULE_GRACE = 10 * time.Minute
+
+	SCHEDULE_DATE_LAYOUT  = "2006-01-02"
+	SCHEDULE_CLOCK_LAYOUT = "15:04"
+)
+
+// Schedule opens a lobby on its own, every day or once. The Window and
+// MinPlayers are the countdown and the minimum of players of the lobby
+// unless its settings give their own.
+type Schedule struct {
+	Id       uint64
+	ChatId   int64
+	HostId   int64
+	HostName string
+	// Daily opens the lobby every day at Clock, else once on Date
+	Daily      bool
+	Date       string
+	Clock      string
+	Timezone   string
+	Window     time.Duration
+	MinPlayers int
+	// Settings are the /newgame arguments of the lobby
+	Settings string
+	// Next is when the lobby opens next
+	Next time.Time
+}
+
+// ParseSchedule reads "daily 20:00" or "2026-12-31 21:00" followed by
+// "tz=Asia/Ho_Chi_Minh window=10m min=3" and the /newgame settings.
+func ParseSchedule(args string, now time.Time) (Schedule, error) {
+	schedule := Schedule{Timezone: DEFAULT_TIMEZONE, Window: SCHEDULE_WINDOW}
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		return schedule, UserError("schedule.usage", CMD_SCHEDULE, CMD_SCHEDULE, CMD_NEW_GAME)
+	}
+
+	if strings.ToLower(fields[0]) == "daily" {
+		schedule.Daily = true
+	} else if date, err := time.Parse(SCHEDULE_DATE_LAYOUT, fields[0]); err == nil {
+		schedule.Date = date.Format(SCHEDULE_DATE_LAYOUT)
+	} else {
+		return schedule, UserError("schedule.bad_date", fields[0])
+	}
+	clock, err := time.Parse(SCHEDULE_CLOCK_LAYOUT, fields[1])
+	if err != nil {
+		return schedule, UserError("schedule.bad_time", fields[1])
+	}
+	schedule.Clock = clock.Format(SCHEDULE_CLOCK_LAYOUT)
+
+	var settings []string
+	for _, arg := range fields[2:] {
+		pair := strings.SplitN(arg, "=", 2)
+		if len(pair) != 2 {
+			return schedule, UserError("settings.bad_argument", arg)
+		}
+		switch strings.ToLower(pair[0]) {
+		case "tz":
+			if _, err := time.LoadLocation(pair[1]); err != nil || len(pair[1]) == 0 {
+				return schedule, UserError("schedule.bad_timezone", pair[1])
+			}
+			schedule.Timezone = pair[1]
+		case "window":
+			window, err := time.ParseDuration(pair[1])
+			if err != nil || window < MIN_SCHEDULE_WINDOW || window > MAX_SCHEDULE_WINDOW {
+				return schedule, UserError("schedule.bad_window", shortDuration(MIN_SCHEDULE_WINDOW), shortDuration(MAX_SCHEDULE_WINDOW))
+			}
+			schedule.Window = window
+		case "min":
+			players, err := strconv.Atoi(pair[1])
+			if err != nil || players < 1 || players > MAX_LOBBY_PLAYERS {
+				return schedule, UserError("schedule.bad_min", MAX_LOBBY_PLAYERS)
+			}
+			schedule.MinPlayers = players
+		default:
+			settings = append(settings, arg)
+		}
+	}
+	schedule.Settings = strings.Join(settings, " ")
+	if _, err := ParseGameSettings(schedule.Settings); err != nil {
+		return schedule, err
+	}
+
+	next, ok := schedule.next(now)
+	if !ok {
+		return schedule, UserError("schedule.past", schedule.Date, schedule.Clock)
+	}
+	schedule.Next = next
+
+	return schedule, nil
+}
+
+// next is the first opening after the given time, false when a one-off
+// schedule is over.
+func (schedule Schedule) next(after time.Time) (time.Time, bool) {
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if !schedule.Daily {
+		next, err := time.ParseInLocation(SCHEDULE_DATE_LAYOUT+" "+SCHEDULE_CLOCK_LAYOUT, schedule.Date+" "+schedule.Clock, location)
+		return next, err == nil && next.After(after)
+	}
+
+	clock, err := time.Parse(SCHEDULE_CLOCK_LAYOUT, schedule.Clock)
+	if err != nil {
+		return time.Time{}, false
+	}
+	local := after.In(location)
+	next := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, location)
+	if !next.After(after) {
+		next = time.Date(local.Year(), local.Month(), local.Day()+1, clock.Hour(), clock.Minute(), 0, 0, location)
+	}
+
+	return next, true
+}
+
+func (schedule Schedule) Text(language string) string {
+	when := T(language, "schedule.daily", schedule.Clock)
+	if !schedule.Daily {
+		when = T(language, "schedule.once", schedule.Date, schedule.Clock)
+	}
+	next := schedule.Next
+	if location, err := time.LoadLocation(schedule.Timezone); err == nil {
+		next = next.In(location)
+	}
+
+	text := T(language, "schedule.line", schedule.Id, when, schedule.Timezone, next.Format("02/01/2006 15:04"), shortDuration(schedule.Window))
+	if schedule.MinPlayers > 0 {
+		text += T(language, "schedule.line_min", schedule.MinPlayers)
+	}
+	if len(schedule.Settings) > 0 {
+		text += "\n   " + schedule.Settings
+	}
+
+	return text
+}
+
+// shortDuration writes whole minutes and hours without the zero units.
+func shortDuration(duration time.Duration) string {
+	switch {
+	case duration%time.Hour == 0:
+		return fmt.Sprintf("%dh", duration/time.Hour)
+	case duration%time.Minute == 0:
+		return fmt.Sprintf("%dm", duration/time.Minute)
+	}
+	return duration.String()
+}
+
+// RunSchedules opens the lobbies of the due schedules until quit is
+// closed.
+func (handler *MessageHandler) RunSchedules(interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		handler.runSchedules(time.Now())
+
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (handler *MessageHandler) runSchedules(now time.Time) {
+	handler.scheduleLock.Lock()
+	defer handler.scheduleLock.Unlock()
+
+	schedules, err := handler.storage.LoadSchedules()
+	if err != nil {
+		log.Errorf("load schedules error: %s", err.Error())
+		return
+	}
+
+	for _, schedule := range schedules {
+		if schedule.Next.After(now) {
+			continue
+		}
+		if now.Sub(schedule.Next) > SCHEDULE_GRACE {
+			log.Warnf("schedule %d of chat %d missed its lobby of %s", schedule.Id, schedule.ChatId, schedule.Next)
+		} else {
+			handler.openScheduled(schedule, now)
+		}
+
+		next, ok := schedule.next(now)
+		if !ok {
+			if err := handler.storage.DeleteSchedule(schedule.Id); err != nil {
+				log.Errorf("delete schedule %d error: %s", schedule.Id, err.Error())
+			}
+			continue
+		}
+		schedule.Next = next
+		if err := handler.storage.SaveSchedule(&schedule); err != nil {
+			log.Errorf("save schedule %d error: %s", schedule.Id, err.Error())
+		}
+	}
+}
+
+// openScheduled opens the lobby of the schedule for its host, unless the
+// chat is still playing.
+func (handler *MessageHandler) openScheduled(schedule Schedule, now time.Time) {
+	settings, err := ParseGameSettings(schedule.Settings)
+	if err != nil {
+		log.Errorf("settings of schedule %d error: %s", schedule.Id, err.Error())
+		return
+	}
+
+	if settings.MinPlayers == 0 {
+		settings.MinPlayers = schedule.MinPlayers
+	}
+	if settings.Countdown == 0 {
+		settings.Countdown = schedule.Window
+	}
+	lobby := handler.newLobby(schedule.ChatId, &tgbotapi.User{ID: schedule.HostId, UserName: schedule.HostName}, settings)
+	lobby.startAt = now.Add(settings.Countdown)
+	game, created := handler.openLobby(lobby)
+	if !created {
+		msg := tgbotapi.NewMessage(schedule.ChatId, T(handler.chatLanguage(schedule.ChatId), "schedule.skipped", schedule.Id))
+		game.lock.Lock()
+		msg.ReplyToMessageID = game.GameId
+		game.lock.Unlock()
+		handler.sendMessage(msg)
+		return
+	}
+	log.Infof("opened lobby of chat %d for schedule %d", game.ChatId, schedule.Id)
+}
+
+// schedule saves a lobby the bot opens on its own, see ParseSchedule. Only
+// chat administrators schedule games.
+func (handler *MessageHandler) schedule(update *tgbotapi.Update) (string, error) {
+	chatId, from := update.Message.Chat.ID, update.Message.From
+	if update.Message.Chat.IsPrivate() {
+		return "", UserError("schedule.group_only", CMD_SCHEDULE)
+	}
+	if !handler.admins.IsAdmin(chatId, from.ID) {
+		return "", PermissionError("schedule.admin_only")
+	}
+
+	schedule, err := ParseSchedule(update.Message.CommandArguments(), time.Now())
+	if err != nil {
+		return "", err
+	}
+	schedule.ChatId = chatId
+	schedule.HostId = from.ID
+	schedule.HostName = from.UserName
+
+	handler.scheduleLock.Lock()
+	defer handler.scheduleLock.Unlock()
+
+	schedules, err := handler.chatSchedules(chatId)
+	if err != nil {
+		return "", InternalError(err)
+	}
+	if len(schedules) >= MAX_SCHEDULES_PER_CHAT {
+		return "", UserError("schedule.limit", MAX_SCHEDULES_PER_CHAT, CMD_SCHEDULES)
+	}
+	if err := handler.storage.SaveSchedule(&schedule); err != nil {
+		return "", InternalError(err)
+	}
+
+	language := handler.chatLanguage(chatId)
+	return T(language, "schedule.created", schedule.Text(language), CMD_SCHEDULES), nil
+}
+
+func (handler *MessageHandler) chatSchedules(chatId int64) ([]Schedule, error) {
+	schedules, err := handler.storage.LoadSchedules()
+	if err != nil {
+		return nil, err
+	}
+
+	var chat []Schedule
+	for _, schedule := range schedules {
+		if schedule.ChatId == chatId {
+			chat = append(chat, schedule)
+		}
+	}
+	return chat, nil
+}
+
+// listSchedules writes the schedules of the chat with a cancel button for
+// each of them.
+func (handler *MessageHandler) listSchedules(chatId int64) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	schedules, err := handler.chatSchedules(chatId)
+	if err != nil {
+		return "", nil, InternalError(err)
+	}
+
+	language := handler.chatLanguage(chatId)
+	if len(schedules) == 0 {
+		return T(language, "schedule.empty", CMD_SCHEDULE), nil, nil
+	}
+
+	lines := []string{T(language, "schedule.list_title")}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, schedule := range schedules {
+		lines = append(lines, schedule.Text(language))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			T(language, "button.unschedule", schedule.Id),
+			fmt.Sprintf("%s;%d", QUERY_DATA_UNSCHEDULE, schedule.Id),
+		)))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	return strings.Join(lines, "\n"), &keyboard, nil
+}
+
+// unschedule cancels the schedule of the pressed button and updates the
+// list.
+func (handler *MessageHandler) unschedule(update *tgbotapi.Update) error {
+	message := update.CallbackQuery.Message
+	if !handler.admins.IsAdmin(message.Chat.ID, update.CallbackQuery.From.ID) {
+		return PermissionError("schedule.admin_only")
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(update.CallbackQuery.Data, QUERY_DATA_UNSCHEDULE+";"), 10, 64)
+	if err != nil {
+		return InternalError(fmt.Errorf("bad schedule query %q", update.CallbackQuery.Data))
+	}
+
+	handler.scheduleLock.Lock()
+	defer handler.scheduleLock.Unlock()
+
+	schedules, err := handler.chatSchedules(message.Chat.ID)
+	if err != nil {
+		return InternalError(err)
+	}
+	found := false
+	for _, schedule := range schedules {
+		found = found || schedule.Id == id
+	}
+	if !found {
+		return UserError("schedule.not_found", id)
+	}
+	if err := handler.storage.DeleteSchedule(id); err != nil {
+		return InternalError(err)
+	}
+
+	text, keyboard, err := handler.listSchedules(message.Chat.ID)
+	if err != nil {
+		return err
+	}
+	edit := tgbotapi.NewEditMessageText(message.Chat.ID, message.MessageID, text)
+	edit.ReplyMarkup = keyboard
+	handler.editMessage(edit)
+
+	return nil
+}
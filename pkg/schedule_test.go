@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+func TestParseSchedule(t *testing.T) {
+	saigon, err := time.LoadLocation(DEFAULT_TIMEZONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 1, 21, 30, 0, 0, saigon)
+
+	schedule, err := ParseSchedule("daily 20:00 window=15m min=3 interval=5s price=0", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 20:00 is over today, the next lobby is tomorrow
+	if !schedule.Daily || schedule.Window != 15*time.Minute || schedule.MinPlayers != 3 ||
+		schedule.Settings != "interval=5s price=0" || !schedule.Next.Equal(time.Date(2026, 3, 2, 20, 0, 0, 0, saigon)) {
+		t.Fatalf("unexpected schedule %+v", schedule)
+	}
+
+	schedule, err = ParseSchedule("2026-12-31 21:00 tz=Europe/Paris", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paris, _ := time.LoadLocation("Europe/Paris")
+	if schedule.Daily || schedule.Window != SCHEDULE_WINDOW || !schedule.Next.Equal(time.Date(2026, 12, 31, 21, 0, 0, 0, paris)) {
+		t.Fatalf("unexpected one-off schedule %+v", schedule)
+	}
+	if _, ok := schedule.next(schedule.Next); ok {
+		t.Fatal("one-off schedule opened twice")
+	}
+
+	invalid := map[string]string{
+		"daily":                       "schedule.usage",
+		"tomorrow 20:00":              "schedule.bad_date",
+		"daily 25:00":                 "schedule.bad_time",
+		"daily 20:00 tz=Mars/Olympus": "schedule.bad_timezone",
+		"daily 20:00 window=10s":      "schedule.bad_window",
+		"daily 20:00 min=0":           "schedule.bad_min",
+		"daily 20:00 speed=3":         "settings.unknown",
+		"2026-02-01 20:00":            "schedule.past",
+	}
+	for args, key := range invalid {
+		_, err := ParseSchedule(args, now)
+		if botErr, ok := err.(*BotError); !ok || botErr.Key != key {
+			t.Errorf("%q: expected %s, got %v", args, key, err)
+		}
+	}
+}
+
+func TestScheduledGame(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1040)
+	admin := telegramtest.User(10, "group_admin")
+	players := []*tgbotapi.User{telegramtest.User(11, "player_teo"), telegramtest.User(12, "player_ti")}
+	server.Handle("getChatAdministrators", func(req telegramtest.Request) (interface{}, error) {
+		return []tgbotapi.ChatMember{{User: admin, Status: "administrator"}}, nil
+	})
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, players[0], 1, "/schedule daily 20:00"))
+	Dispatch(handler, telegramtest.MessageUpdate(group, admin, 2, "/schedule daily 20:00 min=2 interval=5s"))
+	schedules, err := handler.storage.LoadSchedules()
+	if err != nil || len(schedules) != 1 || schedules[0].HostId != admin.ID || schedules[0].MinPlayers != 2 {
+		t.Fatalf("unexpected schedules %+v (%v)", schedules, err)
+	}
+	schedule := schedules[0]
+
+	// nothing is due yet
+	handler.runSchedules(schedule.Next.Add(-time.Minute))
+	if handler.lobbies.Get(group.ID) != nil {
+		t.Fatal("lobby opened before its time")
+	}
+	handler.runSchedules(schedule.Next)
+	game := handler.lobbies.Get(group.ID)
+	if game == nil || game.HostId != admin.ID || game.minPlayers != 2 || !game.startAt.Equal(schedule.Next.Add(SCHEDULE_WINDOW)) {
+		t.Fatalf("unexpected scheduled lobby %+v", game)
+	}
+	schedules, _ = handler.storage.LoadSchedules()
+	if len(schedules) != 1 || !schedules[0].Next.Equal(schedule.Next.AddDate(0, 0, 1)) {
+		t.Fatalf("daily schedule did not move to the next day: %+v", schedules)
+	}
+
+	// the draw starts once enough players registered
+	Dispatch(handler, telegramtest.CallbackUpdate(group, players[0], game.GameId, QUERY_DATA_REGISTER))
+	game.lock.Lock()
+	status := game.lifecycle.status()
+	game.lock.Unlock()
+	if status != LOBBY {
+		t.Fatal("lobby started before the minimum of players")
+	}
+	Dispatch(handler, telegramtest.CallbackUpdate(group, players[1], game.GameId, QUERY_DATA_REGISTER))
+	game.lock.Lock()
+	status = game.lifecycle.status()
+	game.lifecycle.stop()
+	game.lock.Unlock()
+	if status != STARTED {
+		t.Fatal("lobby did not start with the minimum of players")
+	}
+
+	// a schedule is skipped while the chat plays
+	server.Reset()
+	handler.runSchedules(schedule.Next.AddDate(0, 0, 1))
+	if handler.lobbies.Get(group.ID) != game || countTexts(server.Sent(group.ID), group.ID, T(DEFAULT_LANGUAGE, "schedule.skipped", schedule.Id)) != 1 {
+		t.Fatal("running game was not kept")
+	}
+
+	server.Reset()
+	Dispatch(handler, telegramtest.MessageUpdate(group, players[0], 3, "/schedules"))
+	sent := server.Sent(group.ID)
+	if len(sent) != 1 || !strings.Contains(sent[0].Text(), fmt.Sprintf("#%d", schedule.Id)) {
+		t.Fatalf("unexpected list %+v", sent)
+	}
+	markup, ok := sent[0].ReplyMarkup()
+	if !ok || len(markup.InlineKeyboard) != 1 {
+		t.Fatal("list has no cancel button")
+	}
+	cancel := *markup.InlineKeyboard[0][0].CallbackData
+
+	Dispatch(handler, telegramtest.CallbackUpdate(group, players[0], 4, cancel))
+	if schedules, _ := handler.storage.LoadSchedules(); len(schedules) != 1 {
+		t.Fatal("player cancelled a schedule")
+	}
+	Dispatch(handler, telegramtest.CallbackUpdate(group, admin, 4, cancel))
+	if schedules, _ := handler.storage.LoadSchedules(); len(schedules) != 0 {
+		t.Fatal("schedule was not cancelled")
+	}
+	if countTexts(server.Requests("editMessageText"), group.ID, T(DEFAULT_LANGUAGE, "schedule.empty", CMD_SCHEDULE)) != 1 {
+		t.Fatal("list was not updated")
+	}
+}
+
+func TestRegistrationWindow(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1041)
+	host := telegramtest.User(10, "lotovn_host")
+
+	game := handler.newLobby(group.ID, host, DefaultGameSettings())
+	game.startAt = time.Now()
+	if _, created := handler.openLobby(game); !created || game.GameId == 0 {
+		t.Fatal("lobby was not opened")
+	}
+
+	// nobody registered in time, the lobby closes
+	closed := server.WaitFor(5*time.Second, func(requests []telegramtest.Request) bool {
+		return handler.lobbies.Get(group.ID) == nil
+	})
+	if !closed || countTexts(server.Sent(group.ID), group.ID, T(DEFAULT_LANGUAGE, "lobby.cancelled", game.GameId, 0, 1)) != 1 {
+		t.Fatal("empty lobby was not closed")
+	}
+}
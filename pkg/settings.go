@@ -0,0 +1,307 @@
+package pkg
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MIN_INTERVAL keeps the draw within the 20 messages a minute of a
+	// group: every number is announced, with Hò calls and lobby edits on
+	// top, and a faster draw would pile up in the outbound queue.
+	MIN_INTERVAL = 5 * time.Second
+	MAX_INTERVAL = time.Minute
+
+	MAX_TICKETS_PER_PLAYER = 4
+
+	DEFAULT_TICKET_PRICE = 10
+	MAX_TICKET_PRICE     = 1000
+
+	MAX_LOBBY_PLAYERS = 100
+
+	// a lobby with a countdown starts or is cancelled when it runs out
+	MIN_COUNTDOWN  = 30 * time.Second
+	MAX_COUNTDOWN  = 2 * time.Hour
+	COUNTDOWN_STEP = 30
+)
+
+// lobbySettings may still change after somebody registered, the others
+// change the tickets.
+var lobbySettings = map[string]bool{
+	"interval": true, "tickets": true, "style": true, "voice": true,
+	"minplayers": true, "maxplayers": true, "countdown": true,
+}
+
+// GameSettings configures a lobby. It is set by the arguments of /newgame
+// and can be tuned from the settings menu until somebody registers.
+type GameSettings struct {
+	Interval time.Duration
+	Ticket   TicketConifg
+	// MaxTickets is how many tickets a player may buy in the lobby
+	MaxTickets int
+	// TicketPrice is paid in coins for every ticket into the prize pool,
+	// 0 plays for fun
+	TicketPrice int64
+	// RhymeStyle is how released numbers are called, see RHYME_STYLE_*
+	RhymeStyle string
+	// Voice also reads released numbers out loud with the voice pack
+	Voice bool
+	// MinPlayers starts the draw as soon as that many players registered,
+	// a lobby still short of them when the Countdown runs out is cancelled.
+	// MaxPlayers closes the registration, 0 is unlimited.
+	MinPlayers int
+	MaxPlayers int
+	Countdown  time.Duration
+}
+
+func DefaultGameSettings() GameSettings {
+	return GameSettings{
+		Interval: time.Second * 10,
+		Ticket: TicketConifg{
+			MaxNumer:       CLASSIC_NUMBER_SPACE,
+			MaxRow:         ROW_SIZE,
+			MaxCol:         COLUMN_SIZE,
+			MaxNumberOfRow: NUMBER_PER_ROW,
+			Layout:         LAYOUT_TRADITIONAL,
+		},
+		MaxTickets:  1,
+		TicketPrice: DEFAULT_TICKET_PRICE,
+		RhymeStyle:  RHYME_STYLE_BOTH,
+	}
+}
+
+// ParseGameSettings reads "key=value" arguments on top of the default
+// settings, e.g. "interval=5s max=90 rows=9 cols=9 perrow=5 tickets=2 price=10 style=rhyme voice=on
+// minplayers=3 maxplayers=20 countdown=5m".
+// The columns follow the number space, so giving only one of max and cols
+// is enough.
+func ParseGameSettings(args string) (GameSettings, error) {
+	settings := DefaultGameSettings()
+	maxNumber, cols := 0, 0
+	layout := ""
+
+	for _, arg := range strings.Fields(args) {
+		pair := strings.SplitN(arg, "=", 2)
+		if len(pair) != 2 {
+			return settings, UserError("settings.bad_argument", arg)
+		}
+		key, value := strings.ToLower(pair[0]), pair[1]
+
+		if key == "interval" {
+			interval, err := time.ParseDuration(value)
+			if err != nil {
+				return settings, UserError("settings.bad_interval", value)
+			}
+			settings.Interval = interval
+			continue
+		}
+		if key == "countdown" {
+			countdown, err := time.ParseDuration(value)
+			if err != nil {
+				return settings, UserError("settings.bad_countdown", value)
+			}
+			settings.Countdown = countdown
+			continue
+		}
+		if key == "layout" {
+			layout = strings.ToLower(value)
+			continue
+		}
+		if key == "style" {
+			settings.RhymeStyle = strings.ToLower(value)
+			continue
+		}
+		if key == "voice" {
+			switch strings.ToLower(value) {
+			case "on":
+				settings.Voice = true
+			case "off":
+				settings.Voice = false
+			default:
+				return settings, UserError("settings.bad_voice", value)
+			}
+			continue
+		}
+
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return settings, UserError("settings.not_a_number", value, key)
+		}
+		switch key {
+		case "max":
+			maxNumber = number
+		case "rows":
+			settings.Ticket.MaxRow = number
+		case "cols":
+			cols = number
+		case "perrow":
+			settings.Ticket.MaxNumberOfRow = number
+		case "tickets":
+			settings.MaxTickets = number
+		case "price":
+			settings.TicketPrice = int64(number)
+		case "minplayers":
+			settings.MinPlayers = number
+		case "maxplayers":
+			settings.MaxPlayers = number
+		default:
+			return settings, UserError("settings.unknown", key)
+		}
+	}
+
+	switch {
+	case maxNumber > 0:
+		settings.Ticket = settings.Ticket.withSpace(NumberSpace{Max: maxNumber})
+		if cols > 0 && cols != settings.Ticket.MaxCol {
+			return settings, UserError("settings.columns_mismatch", maxNumber, settings.Ticket.MaxCol, cols)
+		}
+	case cols > 0:
+		settings.Ticket = settings.Ticket.withSpace(NumberSpace{Max: cols * 10})
+	}
+
+	if len(layout) > 0 {
+		settings.Ticket.Layout = layout
+	} else {
+		settings.Ticket = settings.Ticket.fitLayout()
+	}
+
+	return settings, settings.validate()
+}
+
+func (settings GameSettings) validate() error {
+	if settings.Interval < MIN_INTERVAL || settings.Interval > MAX_INTERVAL {
+		return UserError("settings.interval_range", MIN_INTERVAL, MAX_INTERVAL)
+	}
+	if settings.MaxTickets < 1 || settings.MaxTickets > MAX_TICKETS_PER_PLAYER {
+		return UserError("settings.tickets_range", MAX_TICKETS_PER_PLAYER)
+	}
+	if settings.TicketPrice < 0 || settings.TicketPrice > MAX_TICKET_PRICE {
+		return UserError("settings.price_range", MAX_TICKET_PRICE)
+	}
+	if !isRhymeStyle(settings.RhymeStyle) {
+		return UserError("settings.bad_style", settings.RhymeStyle, strings.Join(rhymeStyles, ", "))
+	}
+	if settings.MinPlayers < 0 || settings.MinPlayers > MAX_LOBBY_PLAYERS {
+		return UserError("settings.min_players_range", MAX_LOBBY_PLAYERS)
+	}
+	if settings.MaxPlayers < 0 || settings.MaxPlayers > MAX_LOBBY_PLAYERS ||
+		(settings.MaxPlayers > 0 && settings.MaxPlayers < settings.MinPlayers) {
+		lowest := settings.MinPlayers
+		if lowest < 1 {
+			lowest = 1
+		}
+		return UserError("settings.max_players_range", lowest, MAX_LOBBY_PLAYERS)
+	}
+	if settings.Countdown != 0 && (settings.Countdown < MIN_COUNTDOWN || settings.Countdown > MAX_COUNTDOWN) {
+		return UserError("settings.countdown_range", MIN_COUNTDOWN, shortDuration(MAX_COUNTDOWN))
+	}
+
+	return settings.Ticket.validate()
+}
+
+// adjust applies a step of the settings menu and validates the result.
+func (settings GameSettings) adjust(key string, step int) (GameSettings, error) {
+	switch key {
+	case "interval":
+		settings.Interval += time.Duration(step) * time.Second
+	case "max":
+		settings.Ticket = settings.Ticket.withSpace(NumberSpace{Max: settings.Ticket.MaxNumer + step})
+	case "rows":
+		settings.Ticket.MaxRow += step
+	case "cols":
+		settings.Ticket = settings.Ticket.withSpace(NumberSpace{Max: (settings.Ticket.MaxCol + step) * 10})
+	case "perrow":
+		settings.Ticket.MaxNumberOfRow += step
+	case "tickets":
+		settings.MaxTickets += step
+	case "price":
+		settings.TicketPrice += int64(step)
+	case "minplayers":
+		settings.MinPlayers += step
+	case "maxplayers":
+		settings.MaxPlayers += step
+	case "countdown":
+		settings.Countdown += time.Duration(step) * time.Second
+		// stepping down from the shortest countdown turns it off
+		if settings.Countdown > 0 && settings.Countdown < MIN_COUNTDOWN {
+			settings.Countdown = 0
+		}
+	case "style":
+		settings.RhymeStyle = nextRhymeStyle(settings.RhymeStyle)
+	case "voice":
+		settings.Voice = !settings.Voice
+	case "layout":
+		if settings.Ticket.isTraditional() {
+			settings.Ticket.Layout = LAYOUT_RANDOM
+		} else {
+			// the traditional ticket only comes in the classic size
+			settings.Ticket = DefaultGameSettings().Ticket
+		}
+	default:
+		return settings, UserError("settings.unknown", key)
+	}
+	settings.Ticket = settings.Ticket.fitLayout()
+
+	return settings, settings.validate()
+}
+
+func (settings GameSettings) String() string {
+	return settings.Text(DEFAULT_LANGUAGE)
+}
+
+// Text describes the settings in the language.
+func (settings GameSettings) Text(language string) string {
+	text := T(language, "settings.summary",
+		settings.Interval,
+		settings.Ticket.MaxNumer,
+		layoutLabel(language, settings.Ticket),
+		settings.Ticket.MaxRow,
+		settings.Ticket.MaxCol,
+		settings.Ticket.MaxNumberOfRow,
+		settings.MaxTickets,
+		settings.TicketPrice,
+		rhymeStyleLabel(language, settings.RhymeStyle),
+		onOffLabel(language, settings.Voice),
+	)
+	if settings.MinPlayers > 0 || settings.MaxPlayers > 0 {
+		text += T(language, "settings.players", settings.MinPlayers, playersLabel(language, settings.MaxPlayers))
+	}
+	if settings.Countdown > 0 {
+		text += T(language, "settings.countdown", shortDuration(settings.Countdown))
+	}
+
+	return text
+}
+
+func countdownSetting(language string, countdown time.Duration) string {
+	if countdown == 0 {
+		return T(language, "label.off")
+	}
+
+	return shortDuration(countdown)
+}
+
+func playersLabel(language string, players int) string {
+	if players == 0 {
+		return T(language, "label.unlimited")
+	}
+
+	return strconv.Itoa(players)
+}
+
+func layoutLabel(language string, config TicketConifg) string {
+	if config.isTraditional() {
+		return T(language, "layout.traditional")
+	}
+
+	return T(language, "layout.random")
+}
+
+func onOffLabel(language string, on bool) string {
+	if on {
+		return T(language, "label.on")
+	}
+
+	return T(language, "label.off")
+}
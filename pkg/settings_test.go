@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseGameSettings(t *testing.T) {
+	settings, err := ParseGameSettings("interval=5s max=90 rows=9 cols=9 perrow=5 tickets=3 price=20 style=rhyme minplayers=2 maxplayers=8 countdown=5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := GameSettings{
+		Interval: time.Second * 5,
+		Ticket: TicketConifg{
+			MaxNumer:       90,
+			MaxRow:         9,
+			MaxCol:         9,
+			MaxNumberOfRow: 5,
+			Layout:         LAYOUT_TRADITIONAL,
+		},
+		MaxTickets:  3,
+		TicketPrice: 20,
+		RhymeStyle:  RHYME_STYLE_RHYME,
+		MinPlayers:  2,
+		MaxPlayers:  8,
+		Countdown:   5 * time.Minute,
+	}
+	if settings != expected {
+		t.Fatalf("expected %+v, got %+v", expected, settings)
+	}
+
+	invalids := []string{
+		"interval=fast",
+		"rows",
+		"color=red",
+		"cols=10",
+		"perrow=6 cols=5",
+		// 1-70 only fills 7 columns
+		"max=70 cols=8",
+		"interval=100ms",
+		"interval=3s",
+		"layout=traditional rows=8",
+		"layout=fancy",
+		"tickets=0",
+		"tickets=5",
+		"price=-1",
+		"price=1001",
+		"style=opera",
+		"minplayers=-1",
+		"minplayers=3 maxplayers=2",
+		"maxplayers=101",
+		"countdown=10s",
+		"countdown=3h",
+	}
+	for _, args := range invalids {
+		if _, err := ParseGameSettings(args); err == nil {
+			t.Errorf("expected %q to be rejected", args)
+		}
+	}
+	// parse errors name the setting which is wrong
+	for args, key := range map[string]string{"interval=soon": "settings.bad_interval", "countdown=soon": "settings.bad_countdown"} {
+		if _, err := ParseGameSettings(args); err == nil || err.(*BotError).Key != key {
+			t.Errorf("%q: expected %s, got %v", args, key, err)
+		}
+	}
+}
+
+func TestValidSettingsGenerateTickets(t *testing.T) {
+	settings, err := ParseGameSettings("max=70 rows=9 cols=7 perrow=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Ticket.Layout != LAYOUT_RANDOM {
+		t.Fatalf("expected a 1-70 game to use the random layout, got %q", settings.Ticket.Layout)
+	}
+
+	for i := 0; i < 100; i++ {
+		ticket := NewTicket(1, settings.Ticket)
+		for _, row := range ticket.board {
+			for _, v := range row {
+				if v > settings.Ticket.MaxNumer {
+					t.Fatalf("number %d is out of the pool 1-%d", v, settings.Ticket.MaxNumer)
+				}
+			}
+		}
+	}
+}
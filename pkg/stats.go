@@ -0,0 +1,212 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aquasecurity/table"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	TOP_SIZE = 10
+
+	PERIOD_WEEK  = "week"
+	PERIOD_MONTH = "month"
+	PERIOD_ALL   = "all"
+)
+
+// PlayerStats sums up the archived games of a player in a chat.
+type PlayerStats struct {
+	Id       int64
+	Username string
+	Games    int
+	Wins     int
+	Waits    int
+	// numbersToWin sums the numbers drawn until each win
+	numbersToWin int
+	// LongestWaitStreak is the longest run of consecutive games in which
+	// the player pressed 💣 Hò
+	LongestWaitStreak int
+	waitStreak        int
+}
+
+func (stats *PlayerStats) WinRate() float64 {
+	if stats.Games == 0 {
+		return 0
+	}
+	return float64(stats.Wins) * 100 / float64(stats.Games)
+}
+
+func (stats *PlayerStats) AverageNumbersToWin() float64 {
+	if stats.Wins == 0 {
+		return 0
+	}
+	return float64(stats.numbersToWin) / float64(stats.Wins)
+}
+
+// ComputeStats folds the archives, oldest first, into the stats of every
+// player.
+func ComputeStats(archives []GameArchive) map[int64]*PlayerStats {
+	players := make(map[int64]*PlayerStats)
+	for _, archive := range archives {
+		for _, player := range archive.Players {
+			stats := players[player.Id]
+			if stats == nil {
+				stats = &PlayerStats{Id: player.Id}
+				players[player.Id] = stats
+			}
+			// usernames change, show the latest one
+			stats.Username = player.Username
+			stats.Games++
+			stats.Waits += player.Wait
+			if player.Won {
+				stats.Wins++
+				stats.numbersToWin += player.WonAt
+			}
+
+			if player.Wait > 0 {
+				stats.waitStreak++
+			} else {
+				stats.waitStreak = 0
+			}
+			if stats.waitStreak > stats.LongestWaitStreak {
+				stats.LongestWaitStreak = stats.waitStreak
+			}
+		}
+	}
+
+	return players
+}
+
+// Leaderboard ranks the players by wins, then win rate, then games played.
+func Leaderboard(players map[int64]*PlayerStats) []*PlayerStats {
+	var ranking []*PlayerStats
+	for _, stats := range players {
+		ranking = append(ranking, stats)
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		a, b := ranking[i], ranking[j]
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if a.WinRate() != b.WinRate() {
+			return a.WinRate() > b.WinRate()
+		}
+		if a.Games != b.Games {
+			return a.Games > b.Games
+		}
+		return a.Username < b.Username
+	})
+
+	return ranking
+}
+
+func periodStart(language string, period string, now time.Time) (time.Time, string, error) {
+	switch period {
+	case PERIOD_WEEK:
+		return now.AddDate(0, 0, -7), T(language, "period.week"), nil
+	case PERIOD_MONTH:
+		return now.AddDate(0, 0, -30), T(language, "period.month"), nil
+	case PERIOD_ALL, "":
+		return time.Time{}, T(language, "period.all"), nil
+	default:
+		return now, "", UserError("top.usage", CMD_TOP, PERIOD_WEEK, PERIOD_MONTH, PERIOD_ALL)
+	}
+}
+
+// stats shows the statistics of the caller, of the author of the replied
+// message or of "@username".
+func (handler *MessageHandler) stats(update *tgbotapi.Update) (string, error) {
+	chatId := update.Message.Chat.ID
+	archives, err := handler.storage.LoadArchives(chatId, time.Time{})
+	if err != nil {
+		return "", InternalError(err)
+	}
+	players := ComputeStats(archives)
+
+	var stats *PlayerStats
+	username := strings.TrimPrefix(strings.TrimSpace(update.Message.CommandArguments()), "@")
+	if reply := update.Message.ReplyToMessage; reply != nil && reply.From != nil {
+		stats = players[reply.From.ID]
+		username = reply.From.UserName
+	} else if len(username) > 0 {
+		for _, player := range players {
+			if strings.EqualFold(player.Username, username) {
+				stats = player
+			}
+		}
+	} else {
+		stats = players[update.Message.From.ID]
+		username = update.Message.From.UserName
+	}
+	if stats == nil {
+		return "", UserError("stats.not_found", username)
+	}
+
+	language := handler.chatLanguage(chatId)
+	buf := new(bytes.Buffer)
+	tb := table.New(buf)
+	tb.SetHeaders(T(language, "stats.header.metric"), T(language, "stats.header.value"))
+	tb.AddRow(T(language, "stats.games"), fmt.Sprint(stats.Games))
+	tb.AddRow(T(language, "stats.wins"), fmt.Sprint(stats.Wins))
+	tb.AddRow(T(language, "stats.win_rate"), fmt.Sprintf("%.1f%%", stats.WinRate()))
+	tb.AddRow(T(language, "stats.numbers_to_win"), fmt.Sprintf("%.1f", stats.AverageNumbersToWin()))
+	tb.AddRow(T(language, "stats.waits"), fmt.Sprint(stats.Waits))
+	tb.AddRow(T(language, "stats.longest_streak"), TN(language, "stats.game_count", stats.LongestWaitStreak))
+	tb.Render()
+
+	return T(language, "stats.title", stats.Username, buf.String()), nil
+}
+
+// top shows the leaderboard of the chat for the week, the month or all
+// time.
+func (handler *MessageHandler) top(update *tgbotapi.Update) (string, error) {
+	chatId := update.Message.Chat.ID
+	language := handler.chatLanguage(chatId)
+	since, label, err := periodStart(language, strings.ToLower(strings.TrimSpace(update.Message.CommandArguments())), time.Now())
+	if err != nil {
+		return "", err
+	}
+	archives, err := handler.storage.LoadArchives(chatId, since)
+	if err != nil {
+		return "", InternalError(err)
+	}
+	if len(archives) == 0 {
+		return "", UserError("top.empty", label)
+	}
+
+	ranking := Leaderboard(ComputeStats(archives))
+	if len(ranking) > TOP_SIZE {
+		ranking = ranking[:TOP_SIZE]
+	}
+
+	buf := new(bytes.Buffer)
+	tb := table.New(buf)
+	tb.SetHeaders(
+		T(language, "table.index"),
+		T(language, "table.username"),
+		T(language, "top.header.games"),
+		T(language, "top.header.wins"),
+		T(language, "top.header.rate"),
+		T(language, "top.header.numbers"),
+		T(language, "top.header.waits"),
+	)
+	for i, stats := range ranking {
+		tb.AddRow(
+			fmt.Sprint(i+1),
+			stats.Username,
+			fmt.Sprint(stats.Games),
+			fmt.Sprint(stats.Wins),
+			fmt.Sprintf("%.0f%%", stats.WinRate()),
+			fmt.Sprintf("%.1f", stats.AverageNumbersToWin()),
+			fmt.Sprint(stats.Waits),
+		)
+	}
+	tb.Render()
+
+	return TN(language, "top.title", len(archives), label, buf.String()), nil
+}
@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestComputeStats(t *testing.T) {
+	archives := []GameArchive{
+		{GameId: 1, Players: []ArchivedPlayer{
+			{Id: 1, Username: "teo", Wait: 2, Won: true, WonAt: 40},
+			{Id: 2, Username: "ti", Wait: 1},
+		}},
+		{GameId: 2, Players: []ArchivedPlayer{
+			{Id: 1, Username: "teo", Wait: 1},
+			{Id: 2, Username: "ti", Wait: 0, Won: true, WonAt: 30},
+		}},
+		{GameId: 3, Players: []ArchivedPlayer{
+			{Id: 1, Username: "teo_new", Wait: 3, Won: true, WonAt: 50},
+			{Id: 2, Username: "ti", Wait: 2},
+		}},
+	}
+
+	players := ComputeStats(archives)
+	teo, ti := players[1], players[2]
+	if teo.Username != "teo_new" || teo.Games != 3 || teo.Wins != 2 || teo.Waits != 6 {
+		t.Fatalf("unexpected stats %+v", teo)
+	}
+	if teo.AverageNumbersToWin() != 45 || teo.LongestWaitStreak != 3 {
+		t.Fatalf("expected 45 numbers to win and a streak of 3, got %.1f and %d", teo.AverageNumbersToWin(), teo.LongestWaitStreak)
+	}
+	if ti.LongestWaitStreak != 1 || ti.Wins != 1 {
+		t.Fatalf("unexpected stats %+v", ti)
+	}
+
+	ranking := Leaderboard(players)
+	if ranking[0].Id != 1 || ranking[1].Id != 2 {
+		t.Fatalf("expected teo to lead, got %s", ranking[0].Username)
+	}
+}
+
+func TestBoltStorageArchives(t *testing.T) {
+	storage, err := NewBoltStorage(filepath.Join(t.TempDir(), "lotovn.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	now := time.Now()
+	for i, finishedAt := range []time.Time{now.AddDate(0, 0, -40), now.AddDate(0, 0, -3), now} {
+		if err := storage.ArchiveGame(GameArchive{ChatId: -100, GameId: i + 1, FinishedAt: finishedAt}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.ArchiveGame(GameArchive{ChatId: -1000, GameId: 9, FinishedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := storage.LoadArchives(-100, time.Time{})
+	if err != nil || len(all) != 3 || all[0].GameId != 1 || all[2].GameId != 3 {
+		t.Fatalf("unexpected archives %+v (%v)", all, err)
+	}
+	week, err := storage.LoadArchives(-100, now.AddDate(0, 0, -7))
+	if err != nil || len(week) != 2 {
+		t.Fatalf("expected 2 games this week, got %d (%v)", len(week), err)
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	now := time.Date(2026, 3, 31, 20, 0, 0, 0, time.UTC)
+	for period, days := range map[string]int{PERIOD_WEEK: 7, PERIOD_MONTH: 30} {
+		since, _, err := periodStart(DEFAULT_LANGUAGE, period, now)
+		if err != nil || !since.Equal(now.AddDate(0, 0, -days)) {
+			t.Errorf("%s: expected %d days back, got %s (%v)", period, days, since, err)
+		}
+	}
+	if _, _, err := periodStart(DEFAULT_LANGUAGE, "year", now); err == nil {
+		t.Error("unknown period was accepted")
+	}
+}
@@ -0,0 +1,238 @@
+package pkg
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Storage persists the lobbies so a restart of the bot does not wipe the
+// games which are still running.
+type Storage interface {
+	SaveLobby(record LobbyRecord) error
+	DeleteLobby(chatId int64) error
+	LoadLobbies() ([]LobbyRecord, error)
+
+	// PostTransaction applies the transaction atomically. It fails with
+	// ErrDuplicateTransaction when the id was posted before and with
+	// ErrInsufficientFunds when a non-system account would go below zero.
+	PostTransaction(transaction Transaction) error
+	Balance(account string) (int64, error)
+	// Transactions lists the transactions of the account, oldest first.
+	Transactions(account string) ([]Transaction, error)
+
+	// ArchiveGame keeps a finished game for the statistics.
+	ArchiveGame(archive GameArchive) error
+	// LoadArchives lists the games of the chat finished since the given
+	// time, oldest first.
+	LoadArchives(chatId int64, since time.Time) ([]GameArchive, error)
+
+	// AddRhyme keeps a calling rhyme a chat contributed for the number.
+	AddRhyme(chatId int64, number int, rhyme string) error
+	LoadRhymes(chatId int64, number int) ([]string, error)
+
+	// SaveLanguage keeps the language chosen by a chat or a user, see
+	// chatLanguageKey and userLanguageKey. LoadLanguage returns "" when
+	// none was chosen.
+	SaveLanguage(key string, language string) error
+	LoadLanguage(key string) (string, error)
+
+	// AppendEvent adds the event to the log of the game and numbers it,
+	// LoadEvents lists the log in order. DeleteEvents drops the log of a
+	// lobby which was closed before the draw.
+	AppendEvent(chatId int64, gameId int, event GameEvent) error
+	LoadEvents(chatId int64, gameId int) ([]GameEvent, error)
+	DeleteEvents(chatId int64, gameId int) error
+
+	// SaveSchedule keeps the schedule and numbers it when it is new.
+	// LoadSchedules lists the schedules of every chat by number.
+	SaveSchedule(schedule *Schedule) error
+	LoadSchedules() ([]Schedule, error)
+	DeleteSchedule(id uint64) error
+
+	Close() error
+}
+
+type LobbyRecord struct {
+	ChatId      int64
+	GameId      int
+	HostId      int64
+	HostName    string
+	AutoWait    bool
+	MaxTickets  int
+	TicketPrice int64
+	RhymeStyle  string
+	Voice       bool
+	MinPlayers  int
+	MaxPlayers  int
+	Countdown   time.Duration
+	StartAt     time.Time
+	Players     []PlayerRecord
+	Winners     []int64
+	Game        GameSnapshot
+}
+
+type PlayerRecord struct {
+	Id           int64
+	Username     string
+	Name         string
+	Wait         int
+	FalseBingo   int
+	Waiting      []int
+	Tickets      []TicketRecord
+	Paid         int64
+	WonAt        int
+	Daub         string
+	LanguageCode string
+}
+
+type TicketRecord struct {
+	Id        uuid.UUID
+	GameId    int
+	MessageId int
+	Config    TicketConifg
+	Board     [][]int
+	Daubed    []int
+}
+
+func (lobby *Lobby) record() LobbyRecord {
+	record := LobbyRecord{
+		ChatId:      lobby.ChatId,
+		GameId:      lobby.GameId,
+		HostId:      lobby.HostId,
+		HostName:    lobby.HostName,
+		AutoWait:    lobby.autoWait,
+		MaxTickets:  lobby.maxTickets,
+		TicketPrice: lobby.ticketPrice,
+		RhymeStyle:  lobby.rhymeStyle,
+		Voice:       lobby.voice,
+		MinPlayers:  lobby.minPlayers,
+		MaxPlayers:  lobby.maxPlayers,
+		Countdown:   lobby.countdown,
+		StartAt:     lobby.startAt,
+		Game:        lobby.lifecycle.snapshot(),
+	}
+
+	for _, player := range lobby.players {
+		record.Players = append(record.Players, player.record())
+	}
+	for _, winner := range lobby.winners {
+		record.Winners = append(record.Winners, winner.Id)
+	}
+
+	return record
+}
+
+func (player *Player) record() PlayerRecord {
+	record := PlayerRecord{
+		Id:           player.Id,
+		Username:     player.Username,
+		Name:         player.Name,
+		Wait:         player.Wait,
+		FalseBingo:   player.FalseBingo,
+		Waiting:      player.Waiting,
+		Paid:         player.Paid,
+		WonAt:        player.WonAt,
+		Daub:         player.Daub,
+		LanguageCode: player.LanguageCode,
+	}
+	for _, ticket := range player.Tickets {
+		record.Tickets = append(record.Tickets, ticket.record())
+	}
+
+	return record
+}
+
+func (ticket *Ticket) record() TicketRecord {
+	ticket.lock.RLock()
+	defer ticket.lock.RUnlock()
+
+	var board [][]int
+	for _, row := range ticket.board {
+		board = append(board, append([]int{}, row...))
+	}
+	var daubed []int
+	for number := range ticket.daubed {
+		daubed = append(daubed, number)
+	}
+	sort.Ints(daubed)
+
+	return TicketRecord{
+		Id:        ticket.Id,
+		GameId:    ticket.GameId,
+		MessageId: ticket.MessageId,
+		Config:    ticket.Config,
+		Board:     board,
+		Daubed:    daubed,
+	}
+}
+
+// RestoreLobby rebuilds a lobby, its players and their tickets from the
+// persisted record.
+func RestoreLobby(record LobbyRecord) *Lobby {
+	lobby := &Lobby{
+		ChatId:      record.ChatId,
+		GameId:      record.GameId,
+		HostId:      record.HostId,
+		HostName:    record.HostName,
+		autoWait:    record.AutoWait,
+		maxTickets:  record.MaxTickets,
+		ticketPrice: record.TicketPrice,
+		rhymeStyle:  record.RhymeStyle,
+		voice:       record.Voice,
+		minPlayers:  record.MinPlayers,
+		maxPlayers:  record.MaxPlayers,
+		countdown:   record.Countdown,
+		startAt:     record.StartAt,
+		players:     make(map[int64]*Player),
+		lifecycle:   RestoreGame(record.Game),
+	}
+	// lobbies saved before the rhymes called the bare numbers
+	if len(lobby.rhymeStyle) == 0 {
+		lobby.rhymeStyle = RHYME_STYLE_PLAIN
+	}
+
+	for _, p := range record.Players {
+		player := &Player{
+			Id:           p.Id,
+			Username:     p.Username,
+			Name:         p.Name,
+			Wait:         p.Wait,
+			FalseBingo:   p.FalseBingo,
+			Waiting:      p.Waiting,
+			Paid:         p.Paid,
+			WonAt:        p.WonAt,
+			Daub:         p.Daub,
+			LanguageCode: p.LanguageCode,
+		}
+		if len(player.Daub) == 0 {
+			player.Daub = DAUB_MANUAL
+		}
+		for _, t := range p.Tickets {
+			ticket := &Ticket{
+				Id:        t.Id,
+				GameId:    t.GameId,
+				MessageId: t.MessageId,
+				Config:    t.Config,
+				board:     t.Board,
+				daubed:    make(map[int]bool),
+			}
+			for _, number := range t.Daubed {
+				ticket.daubed[number] = true
+			}
+			player.Tickets = append(player.Tickets, ticket)
+		}
+		lobby.players[p.Id] = player
+	}
+	if lobby.maxTickets < 1 {
+		lobby.maxTickets = 1
+	}
+	for _, id := range record.Winners {
+		if winner := lobby.players[id]; winner != nil {
+			lobby.winners = append(lobby.winners, winner)
+		}
+	}
+
+	return lobby
+}
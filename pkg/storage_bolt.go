@@ -0,0 +1,423 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketLobbies = []byte("lobbies")
+	// bucketLedger keeps the transactions by id, bucketBalances the running
+	// balance of each account and bucketPostings "<account>/<seq>" -> id to
+	// list the transactions of an account in order.
+	bucketLedger   = []byte("ledger")
+	bucketBalances = []byte("balances")
+	bucketPostings = []byte("postings")
+	// bucketArchives keeps the finished games by
+	// "<chat id>/<finished at>/<game id>"
+	bucketArchives = []byte("archives")
+	// bucketRhymes keeps the rhymes of the chats by "<chatId>/<number>"
+	bucketRhymes = []byte("rhymes")
+	// bucketLanguages keeps the languages chosen with /lang
+	bucketLanguages = []byte("languages")
+	// bucketEvents keeps the event logs of the games by
+	// "<chatId>/<gameId>/<seq>"
+	bucketEvents = []byte("events")
+	// bucketSchedules keeps the scheduled games by number
+	bucketSchedules = []byte("schedules")
+)
+
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketLobbies, bucketLedger, bucketBalances, bucketPostings, bucketArchives, bucketRhymes, bucketLanguages, bucketEvents, bucketSchedules} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStorage{db: db}, nil
+}
+
+func (storage *BoltStorage) SaveLobby(record LobbyRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketLobbies).Put(chatKey(record.ChatId), data)
+	})
+}
+
+func (storage *BoltStorage) DeleteLobby(chatId int64) error {
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketLobbies).Delete(chatKey(chatId))
+	})
+}
+
+func (storage *BoltStorage) LoadLobbies() ([]LobbyRecord, error) {
+	var records []LobbyRecord
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketLobbies).ForEach(func(k, v []byte) error {
+			var record LobbyRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+
+	return records, err
+}
+
+func (storage *BoltStorage) PostTransaction(transaction Transaction) error {
+	if err := transaction.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(transaction)
+	if err != nil {
+		return err
+	}
+
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		ledger := tx.Bucket(bucketLedger)
+		if ledger.Get([]byte(transaction.Id)) != nil {
+			return ErrDuplicateTransaction
+		}
+
+		balances := tx.Bucket(bucketBalances)
+		postings := tx.Bucket(bucketPostings)
+		for _, entry := range transaction.Entries {
+			balance := readBalance(balances, entry.Account) + entry.Amount
+			if balance < 0 && !isSystemAccount(entry.Account) {
+				return ErrInsufficientFunds
+			}
+			if err := balances.Put([]byte(entry.Account), []byte(strconv.FormatInt(balance, 10))); err != nil {
+				return err
+			}
+
+			seq, err := postings.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := postings.Put(postingKey(entry.Account, seq), []byte(transaction.Id)); err != nil {
+				return err
+			}
+		}
+
+		return ledger.Put([]byte(transaction.Id), data)
+	})
+}
+
+func (storage *BoltStorage) Balance(account string) (int64, error) {
+	var balance int64
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		balance = readBalance(tx.Bucket(bucketBalances), account)
+		return nil
+	})
+
+	return balance, err
+}
+
+func (storage *BoltStorage) Transactions(account string) ([]Transaction, error) {
+	var transactions []Transaction
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		ledger := tx.Bucket(bucketLedger)
+		prefix := []byte(account + "/")
+
+		cursor := tx.Bucket(bucketPostings).Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			var transaction Transaction
+			if err := json.Unmarshal(ledger.Get(v), &transaction); err != nil {
+				return err
+			}
+			transactions = append(transactions, transaction)
+		}
+		return nil
+	})
+
+	return transactions, err
+}
+
+// Audit replays the whole ledger and checks it against the stored
+// balances.
+func (storage *BoltStorage) Audit() error {
+	return storage.db.View(func(tx *bolt.Tx) error {
+		replayed := make(map[string]int64)
+		err := tx.Bucket(bucketLedger).ForEach(func(k, v []byte) error {
+			var transaction Transaction
+			if err := json.Unmarshal(v, &transaction); err != nil {
+				return err
+			}
+			if err := transaction.validate(); err != nil {
+				return err
+			}
+			for _, entry := range transaction.Entries {
+				replayed[entry.Account] += entry.Amount
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		balances := tx.Bucket(bucketBalances)
+		err = balances.ForEach(func(k, v []byte) error {
+			if balance := readBalance(balances, string(k)); balance != replayed[string(k)] {
+				return fmt.Errorf("account %s has balance %d, ledger says %d", k, balance, replayed[string(k)])
+			}
+			delete(replayed, string(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for account := range replayed {
+			return fmt.Errorf("account %s has transactions but no balance", account)
+		}
+
+		return nil
+	})
+}
+
+func (storage *BoltStorage) ArchiveGame(archive GameArchive) error {
+	data, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketArchives).Put(archiveKey(archive.ChatId, archive.FinishedAt, archive.GameId), data)
+	})
+}
+
+func (storage *BoltStorage) LoadArchives(chatId int64, since time.Time) ([]GameArchive, error) {
+	var archives []GameArchive
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		prefix := append(chatKey(chatId), '/')
+
+		cursor := tx.Bucket(bucketArchives).Cursor()
+		for k, v := cursor.Seek(archiveKey(chatId, since, 0)); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			var archive GameArchive
+			if err := json.Unmarshal(v, &archive); err != nil {
+				return err
+			}
+			archives = append(archives, archive)
+		}
+		return nil
+	})
+
+	return archives, err
+}
+
+func (storage *BoltStorage) AddRhyme(chatId int64, number int, rhyme string) error {
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketRhymes)
+		key := rhymeKey(chatId, number)
+
+		var rhymes []string
+		if data := bucket.Get(key); data != nil {
+			if err := json.Unmarshal(data, &rhymes); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(append(rhymes, rhyme))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, data)
+	})
+}
+
+func (storage *BoltStorage) LoadRhymes(chatId int64, number int) ([]string, error) {
+	var rhymes []string
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketRhymes).Get(rhymeKey(chatId, number))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &rhymes)
+	})
+
+	return rhymes, err
+}
+
+func (storage *BoltStorage) SaveLanguage(key string, language string) error {
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketLanguages).Put([]byte(key), []byte(language))
+	})
+}
+
+func (storage *BoltStorage) LoadLanguage(key string) (string, error) {
+	var language string
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		language = string(tx.Bucket(bucketLanguages).Get([]byte(key)))
+		return nil
+	})
+
+	return language, err
+}
+
+func (storage *BoltStorage) AppendEvent(chatId int64, gameId int, event GameEvent) error {
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketEvents)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		event.Seq = seq
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(eventKey(chatId, gameId, seq), data)
+	})
+}
+
+func (storage *BoltStorage) LoadEvents(chatId int64, gameId int) ([]GameEvent, error) {
+	var events []GameEvent
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		prefix := eventPrefix(chatId, gameId)
+
+		cursor := tx.Bucket(bucketEvents).Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			var event GameEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+
+	return events, err
+}
+
+func (storage *BoltStorage) DeleteEvents(chatId int64, gameId int) error {
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		prefix := eventPrefix(chatId, gameId)
+
+		// deleting under a cursor skips keys, collect them first
+		var keys [][]byte
+		cursor := tx.Bucket(bucketEvents).Cursor()
+		for k, _ := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cursor.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, k := range keys {
+			if err := tx.Bucket(bucketEvents).Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (storage *BoltStorage) SaveSchedule(schedule *Schedule) error {
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketSchedules)
+		if schedule.Id == 0 {
+			id, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			schedule.Id = id
+		}
+
+		data, err := json.Marshal(schedule)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(scheduleKey(schedule.Id), data)
+	})
+}
+
+func (storage *BoltStorage) LoadSchedules() ([]Schedule, error) {
+	var schedules []Schedule
+	err := storage.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSchedules).ForEach(func(k, v []byte) error {
+			var schedule Schedule
+			if err := json.Unmarshal(v, &schedule); err != nil {
+				return err
+			}
+			schedules = append(schedules, schedule)
+			return nil
+		})
+	})
+
+	return schedules, err
+}
+
+func (storage *BoltStorage) DeleteSchedule(id uint64) error {
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSchedules).Delete(scheduleKey(id))
+	})
+}
+
+func (storage *BoltStorage) Close() error {
+	return storage.db.Close()
+}
+
+func chatKey(chatId int64) []byte {
+	return []byte(strconv.FormatInt(chatId, 10))
+}
+
+func readBalance(balances *bolt.Bucket, account string) int64 {
+	balance, _ := strconv.ParseInt(string(balances.Get([]byte(account))), 10, 64)
+	return balance
+}
+
+func postingKey(account string, seq uint64) []byte {
+	return []byte(fmt.Sprintf("%s/%020d", account, seq))
+}
+
+func archiveKey(chatId int64, finishedAt time.Time, gameId int) []byte {
+	unix := finishedAt.Unix()
+	if unix < 0 {
+		unix = 0
+	}
+	return []byte(fmt.Sprintf("%d/%020d/%d", chatId, unix, gameId))
+}
+
+func rhymeKey(chatId int64, number int) []byte {
+	return []byte(fmt.Sprintf("%d/%d", chatId, number))
+}
+
+func eventPrefix(chatId int64, gameId int) []byte {
+	return []byte(fmt.Sprintf("%d/%d/", chatId, gameId))
+}
+
+func eventKey(chatId int64, gameId int, seq uint64) []byte {
+	return append(eventPrefix(chatId, gameId), []byte(fmt.Sprintf("%020d", seq))...)
+}
+
+func scheduleKey(id uint64) []byte {
+	return []byte(fmt.Sprintf("%020d", id))
+}
@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBoltStorageRestoreLobby(t *testing.T) {
+	storage, err := NewBoltStorage(filepath.Join(t.TempDir(), "lotovn.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	config := DefaultGameSettings().Ticket
+	game := NewGame(time.Second, config).(*Game)
+	game.Status = STARTED
+	game.seed.numbers = []int{3, 7, 9}
+	game.resultSeed.numbers = []int{1, 2}
+
+	ticket := NewTicket(42, config)
+	ticket.MessageId = 1001
+	player := &Player{Id: 7, Username: "lotovn", Wait: 2, Tickets: []*Ticket{ticket}}
+	lobby := &Lobby{
+		ChatId:    -100,
+		GameId:    42,
+		autoWait:  true,
+		players:   map[int64]*Player{player.Id: player},
+		winners:   []*Player{player},
+		lifecycle: game,
+		// registration options of a scheduled lobby
+		minPlayers: 3,
+		startAt:    time.Date(2026, 1, 1, 20, 10, 0, 0, time.UTC),
+	}
+
+	if err := storage.SaveLobby(lobby.record()); err != nil {
+		t.Fatal(err)
+	}
+	records, err := storage.LoadLobbies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 lobby, got %d", len(records))
+	}
+
+	restored := RestoreLobby(records[0])
+	if restored.lifecycle.status() != PAUSED {
+		t.Fatalf("expected restored game to be paused, got %d", restored.lifecycle.status())
+	}
+	if !reflect.DeepEqual(restored.lifecycle.result(), []int{1, 2}) {
+		t.Fatalf("unexpected result %v", restored.lifecycle.result())
+	}
+	restoredPlayer := restored.players[player.Id]
+	if restoredPlayer == nil || len(restoredPlayer.Tickets) != 1 || restoredPlayer.Tickets[0].MessageId != 1001 {
+		t.Fatalf("ticket message id was not restored: %+v", restoredPlayer)
+	}
+	if !reflect.DeepEqual(restoredPlayer.Tickets[0].board, ticket.board) {
+		t.Fatal("ticket board was not restored")
+	}
+	if !restored.isWinner(restoredPlayer) {
+		t.Fatal("winner was not restored")
+	}
+	if restored.minPlayers != 3 || !restored.startAt.Equal(lobby.startAt) {
+		t.Fatalf("registration options were not restored: %d %s", restored.minPlayers, restored.startAt)
+	}
+
+	if err := storage.DeleteLobby(lobby.ChatId); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := storage.LoadLobbies(); len(records) != 0 {
+		t.Fatalf("expected no lobby after delete, got %d", len(records))
+	}
+}
+
+func TestBoltStorageLedger(t *testing.T) {
+	storage, err := NewBoltStorage(filepath.Join(t.TempDir(), "lotovn.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	wallet, pot := walletAccount(-100, 7), potAccount(-100, 42)
+	if err := storage.PostTransaction(transfer("allowance", TX_ALLOWANCE, "", MINT_ACCOUNT, wallet, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.PostTransaction(transfer("allowance", TX_ALLOWANCE, "", MINT_ACCOUNT, wallet, 100)); err != ErrDuplicateTransaction {
+		t.Fatalf("expected duplicate transaction, got %v", err)
+	}
+	if err := storage.PostTransaction(transfer("ticket", TX_TICKET, "", wallet, pot, 101)); err != ErrInsufficientFunds {
+		t.Fatalf("expected insufficient funds, got %v", err)
+	}
+	if err := storage.PostTransaction(transfer("ticket", TX_TICKET, "", wallet, pot, 30)); err != nil {
+		t.Fatal(err)
+	}
+	unbalanced := Transaction{Id: "bad", Entries: []Entry{{Account: wallet, Amount: 5}, {Account: pot, Amount: -4}}}
+	if err := storage.PostTransaction(unbalanced); err == nil {
+		t.Fatal("expected unbalanced transaction to be rejected")
+	}
+
+	for account, expected := range map[string]int64{wallet: 70, pot: 30, MINT_ACCOUNT: -100} {
+		if balance, err := storage.Balance(account); err != nil || balance != expected {
+			t.Fatalf("expected %s to hold %d, got %d (%v)", account, expected, balance, err)
+		}
+	}
+	transactions, err := storage.Transactions(wallet)
+	if err != nil || len(transactions) != 2 || transactions[0].Id != "allowance" || transactions[1].Id != "ticket" {
+		t.Fatalf("unexpected wallet history %+v (%v)", transactions, err)
+	}
+	if err := storage.Audit(); err != nil {
+		t.Fatal(err)
+	}
+}
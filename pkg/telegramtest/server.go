@@ -0,0 +1,294 @@
+// Package telegramtest runs an in-process fake of the Telegram Bot API so
+// handlers can be exercised end to end without a real token.
+package telegramtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	TOKEN        = "123456:telegramtest"
+	BOT_ID       = 1
+	BOT_USERNAME = "lotovn_bot"
+)
+
+// Request is one Bot API call received by the fake server.
+type Request struct {
+	Method string
+	Params url.Values
+	// Files holds the uploaded files by form field, e.g. "photo".
+	Files map[string][]byte
+}
+
+func (req Request) ChatId() int64 {
+	chatId, _ := strconv.ParseInt(req.Params.Get("chat_id"), 10, 64)
+	return chatId
+}
+
+func (req Request) MessageId() int {
+	messageId, _ := strconv.Atoi(req.Params.Get("message_id"))
+	return messageId
+}
+
+// Text returns the message text, or the caption for media.
+func (req Request) Text() string {
+	if text := req.Params.Get("text"); len(text) > 0 {
+		return text
+	}
+	return req.Params.Get("caption")
+}
+
+// ReplyMarkup decodes the inline keyboard attached to the request.
+func (req Request) ReplyMarkup() (tgbotapi.InlineKeyboardMarkup, bool) {
+	var markup tgbotapi.InlineKeyboardMarkup
+	data := req.Params.Get("reply_markup")
+	if len(data) == 0 {
+		return markup, false
+	}
+	if err := json.Unmarshal([]byte(data), &markup); err != nil {
+		return markup, false
+	}
+	return markup, true
+}
+
+// MethodFunc answers a Bot API method. Returning a *tgbotapi.Error makes
+// the server reply with its code and retry_after.
+type MethodFunc func(req Request) (interface{}, error)
+
+type Server struct {
+	URL string
+
+	server    *httptest.Server
+	requests  []Request
+	methods   map[string]MethodFunc
+	messageId int
+	lock      sync.Mutex
+}
+
+func NewServer() *Server {
+	server := &Server{
+		methods: make(map[string]MethodFunc),
+	}
+	server.server = httptest.NewServer(http.HandlerFunc(server.serve))
+	server.URL = server.server.URL
+
+	return server
+}
+
+func (server *Server) Close() {
+	server.server.Close()
+}
+
+// Endpoint is the API endpoint format for tgbotapi.NewBotAPIWithAPIEndpoint.
+func (server *Server) Endpoint() string {
+	return server.URL + "/bot%s/%s"
+}
+
+// Bot connects a real tgbotapi client to the fake server.
+func (server *Server) Bot() (*tgbotapi.BotAPI, error) {
+	return tgbotapi.NewBotAPIWithAPIEndpoint(TOKEN, server.Endpoint())
+}
+
+// Handle overrides the answer of a method, e.g. getChatAdministrators.
+func (server *Server) Handle(method string, fn MethodFunc) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.methods[method] = fn
+}
+
+// Requests returns the recorded calls, filtered by method when given.
+func (server *Server) Requests(methods ...string) []Request {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	var requests []Request
+	for _, req := range server.requests {
+		if len(methods) == 0 || contains(methods, req.Method) {
+			requests = append(requests, req)
+		}
+	}
+	return requests
+}
+
+// Sent returns the messages and photos sent to the chat.
+func (server *Server) Sent(chatId int64) []Request {
+	var sent []Request
+	for _, req := range server.Requests("sendMessage", "sendPhoto", "sendVoice") {
+		if req.ChatId() == chatId {
+			sent = append(sent, req)
+		}
+	}
+	return sent
+}
+
+// Reset forgets the recorded calls.
+func (server *Server) Reset() {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.requests = nil
+}
+
+// WaitFor polls the recorded calls until done returns true or the timeout
+// expires.
+func (server *Server) WaitFor(timeout time.Duration, done func(requests []Request) bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if done(server.Requests()) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (server *Server) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "bot") {
+		http.NotFound(w, r)
+		return
+	}
+
+	req, err := parseRequest(parts[1], r)
+	if err != nil {
+		writeResponse(w, nil, &tgbotapi.Error{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	server.lock.Lock()
+	server.requests = append(server.requests, req)
+	fn := server.methods[req.Method]
+	server.lock.Unlock()
+
+	if fn == nil {
+		fn = server.defaultMethod
+	}
+	result, err := fn(req)
+	writeResponse(w, result, err)
+}
+
+func (server *Server) nextMessageId() int {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	server.messageId++
+	return server.messageId
+}
+
+func (server *Server) defaultMethod(req Request) (interface{}, error) {
+	switch req.Method {
+	case "getMe":
+		return tgbotapi.User{ID: BOT_ID, IsBot: true, FirstName: "Lô Tô", UserName: BOT_USERNAME}, nil
+	case "sendMessage", "sendPhoto", "sendVoice", "sendDocument":
+		return tgbotapi.Message{
+			MessageID: server.nextMessageId(),
+			Chat:      &tgbotapi.Chat{ID: req.ChatId()},
+			Date:      int(time.Now().Unix()),
+			Text:      req.Params.Get("text"),
+			Caption:   req.Params.Get("caption"),
+		}, nil
+	case "editMessageText", "editMessageReplyMarkup", "editMessageCaption":
+		return tgbotapi.Message{
+			MessageID: req.MessageId(),
+			Chat:      &tgbotapi.Chat{ID: req.ChatId()},
+			Date:      int(time.Now().Unix()),
+			Text:      req.Params.Get("text"),
+		}, nil
+	case "getChatAdministrators":
+		return []tgbotapi.ChatMember{}, nil
+	default:
+		return true, nil
+	}
+}
+
+func parseRequest(method string, r *http.Request) (Request, error) {
+	req := Request{
+		Method: method,
+		Files:  make(map[string][]byte),
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return req, err
+		}
+		req.Params = url.Values(r.MultipartForm.Value)
+		for field, headers := range r.MultipartForm.File {
+			file, err := headers[0].Open()
+			if err != nil {
+				return req, err
+			}
+			data, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return req, err
+			}
+			req.Files[field] = data
+		}
+		return req, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return req, err
+	}
+	req.Params = r.PostForm
+
+	return req, nil
+}
+
+func writeResponse(w http.ResponseWriter, result interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err != nil {
+		code := http.StatusBadRequest
+		res := map[string]interface{}{
+			"ok":          false,
+			"description": err.Error(),
+		}
+		if apiErr, ok := err.(*tgbotapi.Error); ok {
+			if apiErr.Code != 0 {
+				code = apiErr.Code
+			}
+			res["description"] = apiErr.Message
+			if apiErr.RetryAfter > 0 {
+				res["parameters"] = map[string]interface{}{"retry_after": apiErr.RetryAfter}
+			}
+		}
+		res["error_code"] = code
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"ok":false,"error_code":500,"description":%q}`, err.Error())
+		return
+	}
+	fmt.Fprintf(w, `{"ok":true,"result":%s}`, data)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package telegramtest
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var updateId int64
+
+func nextUpdateId() int {
+	return int(atomic.AddInt64(&updateId, 1))
+}
+
+// User builds a Telegram user with a username, as the bot requires one.
+func User(id int64, username string) *tgbotapi.User {
+	return &tgbotapi.User{
+		ID:        id,
+		FirstName: username,
+		UserName:  username,
+	}
+}
+
+// Group builds a supergroup chat.
+func Group(id int64) *tgbotapi.Chat {
+	return &tgbotapi.Chat{ID: id, Type: "supergroup", Title: "Lô tô"}
+}
+
+// Private builds the private chat between the bot and the user.
+func Private(user *tgbotapi.User) *tgbotapi.Chat {
+	return &tgbotapi.Chat{ID: user.ID, Type: "private", UserName: user.UserName}
+}
+
+// MessageUpdate builds a text message update. Texts starting with "/" are
+// marked as bot commands like Telegram does.
+func MessageUpdate(chat *tgbotapi.Chat, from *tgbotapi.User, messageId int, text string) *tgbotapi.Update {
+	message := &tgbotapi.Message{
+		MessageID: messageId,
+		From:      from,
+		Chat:      chat,
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		length := strings.IndexByte(text, ' ')
+		if length < 0 {
+			length = len(text)
+		}
+		message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}}
+	}
+
+	return &tgbotapi.Update{UpdateID: nextUpdateId(), Message: message}
+}
+
+// CallbackUpdate builds the update sent when the user presses an inline
+// button of the message.
+func CallbackUpdate(chat *tgbotapi.Chat, from *tgbotapi.User, messageId int, data string) *tgbotapi.Update {
+	id := nextUpdateId()
+	return &tgbotapi.Update{
+		UpdateID: id,
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:   strconv.Itoa(id),
+			From: from,
+			Message: &tgbotapi.Message{
+				MessageID: messageId,
+				Chat:      chat,
+			},
+			Data: data,
+		},
+	}
+}
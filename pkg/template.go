@@ -2,22 +2,316 @@ package pkg
 
 import (
 	"bytes"
-	"html/template"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ted-vo/lotovn-telegram-bot/config"
 )
 
 const (
 	MARKDOWN string = "MarkdownV2"
 	HTML     string = "HTML"
+
+	TEMPLATE_LOBBY  = "game.html"
+	TEMPLATE_TICKET = "ticket.html"
+	TEMPLATE_BINGO  = "bingo.html"
+
+	// TEMPLATE_WATCH_INTERVAL is how often the override directory is
+	// checked for changed templates.
+	TEMPLATE_WATCH_INTERVAL = 2 * time.Second
+)
+
+// LobbyView is the data of the lobby message.
+type LobbyView struct {
+	GameId     int
+	Host       string
+	Pot        int64
+	Commitment string
+	Settings   string
+	// Countdown is the time left before the draw starts on its own
+	Countdown string
+	List      string
+}
+
+// TicketView is the data of the private ticket message.
+type TicketView struct {
+	GameId   int
+	TicketId uint32
+	Number   int
+}
+
+// BingoView is the data of the caption of a validated Kinh.
+type BingoView struct {
+	Username string
+	TicketId uint32
+	GameId   int
+	Rows     string
+}
+
+// templateSamples are rendered when the templates are loaded. Their texts
+// hold the characters Telegram needs escaped, so a template which forgets
+// to escape a value fails the validation instead of a message in a game.
+var templateSamples = map[string]interface{}{
+	TEMPLATE_LOBBY: LobbyView{
+		GameId:     1,
+		Host:       "<&host>",
+		Pot:        10,
+		Commitment: "<&commitment>",
+		Settings:   "<&settings>",
+		Countdown:  "<&countdown>",
+		List:       "<&list>",
+	},
+	TEMPLATE_TICKET: TicketView{GameId: 1, TicketId: 1, Number: 1},
+	TEMPLATE_BINGO:  BingoView{Username: "<&username>", TicketId: 1, GameId: 1, Rows: "1, 2"},
+}
+
+// telegramTags is the HTML subset of the Telegram Bot API, by tag name.
+var telegramTags = map[string]bool{
+	"b": true, "strong": true, "i": true, "em": true, "u": true, "ins": true,
+	"s": true, "strike": true, "del": true, "span": true, "tg-spoiler": true,
+	"a": true, "tg-emoji": true, "code": true, "pre": true, "blockquote": true,
+}
+
+var (
+	telegramEntity = regexp.MustCompile(`^&(lt|gt|amp|quot|#[0-9]+|#x[0-9a-fA-F]+);`)
+	htmlEscaper    = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
 )
 
-func Parse(fileName string, data interface{}) (string, error) {
-	t, err := template.ParseFiles(fileName)
+// escapeHTML escapes a text for the HTML parse mode of Telegram.
+func escapeHTML(text string) string {
+	return htmlEscaper.Replace(text)
+}
+
+// validateTelegramHTML checks that the text only uses the tags Telegram
+// supports, closes them in order and escapes every other "<" and "&".
+func validateTelegramHTML(text string) error {
+	var open []string
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '<':
+			end := strings.IndexByte(text[i:], '>')
+			if end < 0 {
+				return fmt.Errorf("unescaped < at byte %d", i)
+			}
+			tag := text[i+1 : i+end]
+			closing := strings.HasPrefix(tag, "/")
+			fields := strings.Fields(strings.TrimPrefix(tag, "/"))
+			if len(fields) == 0 {
+				return fmt.Errorf("empty tag at byte %d", i)
+			}
+			name := strings.ToLower(fields[0])
+			if !telegramTags[name] {
+				return fmt.Errorf("tag <%s> is not supported by Telegram", name)
+			}
+			if closing {
+				if len(open) == 0 || open[len(open)-1] != name {
+					return fmt.Errorf("unexpected </%s> at byte %d", name, i)
+				}
+				open = open[:len(open)-1]
+			} else {
+				open = append(open, name)
+			}
+			i += end + 1
+		case '&':
+			entity := telegramEntity.FindString(text[i:])
+			if len(entity) == 0 {
+				return fmt.Errorf("unescaped & at byte %d", i)
+			}
+			i += len(entity)
+		default:
+			i++
+		}
+	}
+	if len(open) > 0 {
+		return fmt.Errorf("unclosed <%s>", open[len(open)-1])
+	}
+
+	return nil
+}
+
+// Templates renders the messages written in Telegram HTML. The templates
+// ship embedded in the binary, the files of the override directory replace
+// them and are picked up again when they change. They are parsed once for
+// every language, where {{t "key" args...}} translates a text, and values
+// are escaped with {{.Value | escape}}.
+type Templates struct {
+	override string
+	parsed   map[string]map[string]*template.Template
+	modified time.Time
+
+	lock sync.RWMutex
+}
+
+// LoadTemplates parses and validates the templates, the override directory
+// is optional.
+func LoadTemplates(override string) (*Templates, error) {
+	templates := &Templates{override: override}
+	if err := templates.load(); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// load parses every template in every language and only replaces the
+// current ones when all of them are valid.
+func (templates *Templates) load() error {
+	modified, err := templates.lastModified()
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	parsed := make(map[string]map[string]*template.Template)
+	for _, language := range messages.Languages() {
+		parsed[language] = make(map[string]*template.Template)
+		for name, sample := range templateSamples {
+			source, err := templates.source(name)
+			if err != nil {
+				return err
+			}
+			tmpl, err := template.New(name).
+				Option("missingkey=error").
+				Funcs(templateFuncs(language)).
+				Parse(string(source))
+			if err != nil {
+				return err
+			}
+
+			buf := new(bytes.Buffer)
+			if err := tmpl.Execute(buf, sample); err != nil {
+				return fmt.Errorf("template %s in %s: %w", name, language, err)
+			}
+			// an empty file is usually one which is still being written
+			if len(strings.TrimSpace(buf.String())) == 0 {
+				return fmt.Errorf("template %s in %s renders nothing", name, language)
+			}
+			if err := validateTelegramHTML(buf.String()); err != nil {
+				return fmt.Errorf("template %s in %s: %w", name, language, err)
+			}
+			parsed[language][name] = tmpl
+		}
+	}
+
+	templates.lock.Lock()
+	defer templates.lock.Unlock()
+	templates.parsed = parsed
+	templates.modified = modified
+
+	return nil
+}
+
+func templateFuncs(language string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(key string, a ...interface{}) string {
+			return escapeHTML(T(language, key, a...))
+		},
+		"escape": escapeHTML,
+	}
+}
+
+// source reads the template from the override directory, else from the
+// embedded ones.
+func (templates *Templates) source(name string) ([]byte, error) {
+	if len(templates.override) > 0 {
+		source, err := os.ReadFile(filepath.Join(templates.override, name))
+		if err == nil {
+			return source, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return fs.ReadFile(config.Templates, name)
+}
+
+// lastModified is the latest change of a template in the override
+// directory.
+func (templates *Templates) lastModified() (time.Time, error) {
+	var modified time.Time
+	if len(templates.override) == 0 {
+		return modified, nil
+	}
+	for name := range templateSamples {
+		info, err := os.Stat(filepath.Join(templates.override, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return modified, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+
+	return modified, nil
+}
+
+// Watch reloads the templates when a file of the override directory
+// changes, until quit is closed. Broken templates are logged and the
+// previous ones stay in use.
+func (templates *Templates) Watch(interval time.Duration, quit <-chan struct{}) {
+	if len(templates.override) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			modified, err := templates.lastModified()
+			if err != nil {
+				log.Errorf("watch templates %s error: %s", templates.override, err.Error())
+				continue
+			}
+			templates.lock.RLock()
+			changed := !modified.Equal(templates.modified)
+			templates.lock.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := templates.load(); err != nil {
+				log.Errorf("reload templates %s error: %s", templates.override, err.Error())
+				// do not retry until the files change again
+				templates.lock.Lock()
+				templates.modified = modified
+				templates.lock.Unlock()
+				continue
+			}
+			log.Infof("Reloaded templates from %s", templates.override)
+		}
+	}
+}
+
+// Render executes the template in the language, falling back to the
+// default language.
+func (templates *Templates) Render(language string, name string, data interface{}) (string, error) {
+	templates.lock.RLock()
+	tmpl := templates.parsed[language][name]
+	if tmpl == nil {
+		tmpl = templates.parsed[DEFAULT_LANGUAGE][name]
+	}
+	templates.lock.RUnlock()
+	if tmpl == nil {
+		return "", fmt.Errorf("template %s does not exist", name)
 	}
 
 	buf := new(bytes.Buffer)
-	if err = t.Execute(buf, data); err != nil {
+	if err := tmpl.Execute(buf, data); err != nil {
 		return "", err
 	}
 	return buf.String(), nil
@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTelegramHTML(t *testing.T) {
+	valid := []string{
+		"GameId: <b>1</b>",
+		"<pre>\n1 &lt; 2 &amp;&amp; 3 &gt; 2\n</pre>",
+		`<a href="https://t.me">link</a> &quot;quoted&quot; &#128512;`,
+		"<b><i>nested</i></b>",
+	}
+	for _, text := range valid {
+		if err := validateTelegramHTML(text); err != nil {
+			t.Errorf("%q rejected: %s", text, err.Error())
+		}
+	}
+
+	invalid := []string{
+		"<div>block</div>",
+		"<b>unclosed",
+		"<b><i>crossed</b></i>",
+		"a < b",
+		"tom & jerry",
+		"&nbsp;",
+		"<>",
+	}
+	for _, text := range invalid {
+		if err := validateTelegramHTML(text); err == nil {
+			t.Errorf("%q accepted", text)
+		}
+	}
+}
+
+func TestTemplatesRender(t *testing.T) {
+	templates, err := LoadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := templates.Render("en", TEMPLATE_BINGO, BingoView{Username: "a<b>&c", TicketId: 7, GameId: 3, Rows: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "@a&lt;b&gt;&amp;c") || !strings.HasPrefix(text, T("en", "bingo.title")) {
+		t.Fatalf("unexpected bingo %q", text)
+	}
+	if err := validateTelegramHTML(text); err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown languages use the default one
+	text, err = templates.Render("xx", TEMPLATE_TICKET, TicketView{GameId: 1, TicketId: 2, Number: 1})
+	if err != nil || !strings.HasPrefix(text, T(DEFAULT_LANGUAGE, "ticket.title")) {
+		t.Fatalf("unexpected ticket %q, %v", text, err)
+	}
+	if _, err := templates.Render(DEFAULT_LANGUAGE, "missing.html", nil); err == nil {
+		t.Fatal("missing template rendered")
+	}
+}
+
+func TestTemplatesOverride(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, TEMPLATE_TICKET)
+	// the file is replaced at once, the watcher never reads half of it
+	write := func(source string, modified time.Time) {
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(tmp, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+
+	// broken overrides are refused at startup
+	write("<div>{{.Number}}</div>", now)
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Fatal("unsupported tag was accepted")
+	}
+	bingoDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bingoDir, TEMPLATE_BINGO), []byte("Kinh <b>{{.Username}}</b>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTemplates(bingoDir); err == nil {
+		t.Fatal("unescaped value was accepted")
+	}
+
+	write(" \n", now)
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Fatal("empty template was accepted")
+	}
+
+	write("Ticket <b>{{.Number}}</b>", now)
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := func() string {
+		text, err := templates.Render(DEFAULT_LANGUAGE, TEMPLATE_TICKET, TicketView{Number: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return text
+	}
+	if text := render(); text != "Ticket <b>2</b>" {
+		t.Fatalf("override was not used: %q", text)
+	}
+	// the other templates are still the embedded ones
+	if _, err := templates.Render(DEFAULT_LANGUAGE, TEMPLATE_LOBBY, LobbyView{}); err != nil {
+		t.Fatal(err)
+	}
+
+	quit := make(chan struct{})
+	defer close(quit)
+	go templates.Watch(10*time.Millisecond, quit)
+
+	write("Ticket no. <b>{{.Number}}</b>", now.Add(time.Second))
+	deadline := time.Now().Add(2 * time.Second)
+	for render() != "Ticket no. <b>2</b>" {
+		if time.Now().After(deadline) {
+			t.Fatalf("template was not reloaded: %q", render())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a broken edit keeps the previous template
+	write("Ticket <i>{{.Number}}", now.Add(2*time.Second))
+	time.Sleep(100 * time.Millisecond)
+	if text := render(); text != "Ticket no. <b>2</b>" {
+		t.Fatalf("broken template replaced the valid one: %q", text)
+	}
+	write("", now.Add(3*time.Second))
+	time.Sleep(100 * time.Millisecond)
+	if text := render(); text != "Ticket no. <b>2</b>" {
+		t.Fatalf("empty template replaced the valid one: %q", text)
+	}
+}
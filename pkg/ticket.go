@@ -1,14 +1,11 @@
 package pkg
 
 import (
-	"bytes"
-	"fmt"
 	"math/rand"
 	"sort"
 	"sync"
 	"time"
 
-	"github.com/aquasecurity/table"
 	"github.com/google/uuid"
 )
 
@@ -16,6 +13,12 @@ const (
 	ROW_SIZE       = 9
 	COLUMN_SIZE    = 9
 	NUMBER_PER_ROW = 5
+	BLOCK_SIZE     = 3
+
+	// LAYOUT_RANDOM scatters the numbers of every row over random columns,
+	// LAYOUT_TRADITIONAL follows the paper tickets, see ticket_traditional.go.
+	LAYOUT_RANDOM      = "random"
+	LAYOUT_TRADITIONAL = "traditional"
 )
 
 type Ticket struct {
@@ -24,6 +27,8 @@ type Ticket struct {
 	MessageId int
 	Config    TicketConifg
 	board     [][]int
+	// daubed are the numbers the player marked on the ticket
+	daubed map[int]bool
 
 	lock sync.RWMutex
 }
@@ -33,10 +38,70 @@ type TicketConifg struct {
 	MaxRow         int
 	MaxCol         int
 	MaxNumberOfRow int
+	Layout         string
 }
 
 type None struct{}
 
+func (config TicketConifg) Space() NumberSpace {
+	return NumberSpace{Max: config.MaxNumer}
+}
+
+// withSpace uses the number space for the ticket and its columns.
+func (config TicketConifg) withSpace(space NumberSpace) TicketConifg {
+	config.MaxNumer = space.Max
+	config.MaxCol = space.Columns()
+
+	return config
+}
+
+// validate makes sure a ticket can always be generated: the columns must
+// match the number space and every column needs a number for each row.
+func (config TicketConifg) validate() error {
+	space := config.Space()
+	if err := space.validate(); err != nil {
+		return err
+	}
+	if config.MaxCol != space.Columns() {
+		return UserError(
+			"ticket.columns_mismatch",
+			space.Max, space.Columns(), config.MaxCol,
+		)
+	}
+	if config.MaxRow < 1 {
+		return UserError("ticket.no_rows")
+	}
+	if config.MaxNumberOfRow < 1 || config.MaxNumberOfRow > config.MaxCol {
+		return UserError("ticket.per_row_range", config.MaxCol)
+	}
+	if config.isTraditional() {
+		if config.MaxNumer != CLASSIC_NUMBER_SPACE ||
+			config.MaxRow != ROW_SIZE ||
+			config.MaxCol != COLUMN_SIZE ||
+			config.MaxNumberOfRow != NUMBER_PER_ROW {
+			return UserError(
+				"ticket.traditional_size",
+				CLASSIC_NUMBER_SPACE, ROW_SIZE, COLUMN_SIZE, NUMBER_PER_ROW,
+			)
+		}
+		return nil
+	}
+	if config.Layout != "" && config.Layout != LAYOUT_RANDOM {
+		return UserError("ticket.unknown_layout", config.Layout)
+	}
+
+	for i := 0; i < config.MaxCol; i++ {
+		if min, max := space.ColumnRange(i); max-min+1 < config.MaxRow {
+			return UserError(
+				"ticket.column_too_short",
+				i+1, min, max, max-min+1, config.MaxRow,
+			)
+		}
+	}
+
+	return nil
+}
+
 func NewTicket(gameId int, config TicketConifg) *Ticket {
 	ticket := &Ticket{
 		Id:     uuid.New(),
@@ -51,11 +116,29 @@ func NewTicket(gameId int, config TicketConifg) *Ticket {
 		}
 	}
 
-	ticket.generateNumbers()
+	if ticket.Config.isTraditional() {
+		ticket.generateTraditionalNumbers()
+	} else {
+		ticket.generateNumbers()
+	}
 
 	return ticket
 }
 
+func (config TicketConifg) isTraditional() bool {
+	return config.Layout == LAYOUT_TRADITIONAL
+}
+
+// fitLayout falls back to the random layout once the size of the ticket
+// no longer matches the traditional one.
+func (config TicketConifg) fitLayout() TicketConifg {
+	if config.isTraditional() && config.validate() != nil {
+		config.Layout = LAYOUT_RANDOM
+	}
+
+	return config
+}
+
 func randomNumbersInRange(n int, min, max int) []int {
 	var indexs []int
 	rand.Seed(time.Now().UnixNano())
@@ -113,7 +196,7 @@ func (ticket *Ticket) generateNumbers() {
 	}
 
 	for i := 0; i < ticket.Config.MaxCol; i++ {
-		randomValues := getSeedByIndex(i)
+		randomValues := ticket.Config.Space().Column(i)
 
 		for j := 0; j < ticket.Config.MaxRow; j++ {
 			baseValue := ticket.board[j][i]
@@ -129,122 +212,69 @@ func (ticket *Ticket) generateNumbers() {
 	}
 }
 
-func getSeedByIndex(index int) []int {
-	var values []int
-	if index == 0 {
-		for i := 1; i <= 9; i++ {
-			values = append(values, i)
-		}
-	} else if index > 0 && index < 8 {
-		for i := index * 10; i <= index*10+9; i++ {
-			values = append(values, i)
+// completedRows returns the indexes of the rows whose numbers have all been
+// drawn. Rows without any number never count as completed.
+func (ticket *Ticket) completedRows(result []int) []int {
+	ticket.lock.RLock()
+	defer ticket.lock.RUnlock()
+
+	drawn := map[int]*None{}
+	for _, v := range result {
+		drawn[v] = &None{}
+	}
+
+	var rows []int
+	for i, row := range ticket.board {
+		count := 0
+		completed := true
+		for _, v := range row {
+			if v <= 0 {
+				continue
+			}
+			count++
+			if drawn[v] == nil {
+				completed = false
+				break
+			}
 		}
-	} else {
-		for i := 80; i <= 90; i++ {
-			values = append(values, i)
+		if completed && count > 0 {
+			rows = append(rows, i)
 		}
 	}
 
-	return values
+	return rows
 }
 
-func BeautyResult(numbers []int) string {
-	buf := new(bytes.Buffer)
-	tb := table.New(buf)
-	var one []string
-	var two []string
-	var three []string
-	var four []string
-	var five []string
-	var six []string
-	var seven []string
-	var eight []string
-	var nine []string
-
-	for _, v := range numbers {
-		if v < 10 {
-			one = append(one, fmt.Sprint(v))
-		} else if v > 9 && v < 20 {
-			two = append(two, fmt.Sprint(v))
-		} else if v > 19 && v < 30 {
-			three = append(three, fmt.Sprint(v))
-		} else if v > 29 && v < 40 {
-			four = append(four, fmt.Sprint(v))
-		} else if v > 39 && v < 50 {
-			five = append(five, fmt.Sprint(v))
-		} else if v > 49 && v < 60 {
-			six = append(six, fmt.Sprint(v))
-		} else if v > 59 && v < 70 {
-			seven = append(seven, fmt.Sprint(v))
-		} else if v > 69 && v < 80 {
-			eight = append(eight, fmt.Sprint(v))
-		} else if v > 79 && v <= 90 {
-			nine = append(nine, fmt.Sprint(v))
-		}
-	}
+// waitingNumbers returns the numbers which are the only one missing from a
+// row, i.e. the numbers the ticket is "Hò" for. A row only waits once one of
+// its numbers was called, so a row of a single number never does.
+func (ticket *Ticket) waitingNumbers(result []int) []int {
+	ticket.lock.RLock()
+	defer ticket.lock.RUnlock()
 
-	if len(one) > 0 {
-		sort.Slice(one, func(i, j int) bool { return i < j })
-		tb.AddRows(one)
-	}
-	if len(two) > 0 {
-		sort.Slice(two, func(i, j int) bool { return i < j })
-		tb.AddRows(two)
-	}
-	if len(three) > 0 {
-		sort.Slice(three, func(i, j int) bool { return i < j })
-		tb.AddRows(three)
-	}
-	if len(four) > 0 {
-		sort.Slice(four, func(i, j int) bool { return i < j })
-		tb.AddRows(four)
-	}
-	if len(five) > 0 {
-		sort.Slice(five, func(i, j int) bool { return i < j })
-		tb.AddRows(five)
-	}
-	if len(six) > 0 {
-		sort.Slice(six, func(i, j int) bool { return i < j })
-		tb.AddRows(six)
-	}
-	if len(seven) > 0 {
-		sort.Slice(seven, func(i, j int) bool { return i < j })
-		tb.AddRows(seven)
-	}
-	if len(eight) > 0 {
-		sort.Slice(eight, func(i, j int) bool { return i < j })
-		tb.AddRows(eight)
-	}
-	if len(nine) > 0 {
-		sort.Slice(nine, func(i, j int) bool { return i < j })
-		tb.AddRows(nine)
+	drawn := map[int]*None{}
+	for _, v := range result {
+		drawn[v] = &None{}
 	}
 
-	tb.Render()
-
-	return buf.String()
-}
-
-func BeautyTicket(ticket [][]int) string {
-	buf := new(bytes.Buffer)
-	tb := table.New(buf)
-	for _, colValues := range ticket {
-		var converts []string
-		for _, v := range colValues {
-			var value string
-			switch v {
-			case -1:
-				value = "*"
-			case 0:
-				value = ""
-			default:
-				value = fmt.Sprintf("%d", v)
+	var numbers []int
+	for _, row := range ticket.board {
+		missing, called := []int{}, 0
+		for _, v := range row {
+			if v == 0 {
+				continue
+			}
+			if drawn[v] == nil {
+				missing = append(missing, v)
+			} else {
+				called++
 			}
-			converts = append(converts, value)
 		}
-		tb.AddRows(converts)
+		if len(missing) == 1 && called > 0 {
+			numbers = append(numbers, missing[0])
+		}
 	}
-	tb.Render()
+	sort.Ints(numbers)
 
-	return buf.String()
+	return numbers
 }
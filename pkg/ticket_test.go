@@ -1 +1,132 @@
 package pkg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompletedRows(t *testing.T) {
+	ticket := &Ticket{
+		board: [][]int{
+			{1, 0, 23, 0},
+			{0, 15, 0, 37},
+			{0, 0, 0, 0},
+		},
+	}
+
+	if rows := ticket.completedRows([]int{1, 15}); len(rows) != 0 {
+		t.Fatalf("expected no completed row, got %v", rows)
+	}
+
+	rows := ticket.completedRows([]int{37, 1, 15, 23})
+	if !reflect.DeepEqual(rows, []int{0, 1}) {
+		t.Fatalf("expected rows [0 1], got %v", rows)
+	}
+}
+
+func TestWaitingNumbers(t *testing.T) {
+	ticket := &Ticket{
+		board: [][]int{
+			{1, 0, 23, 0},
+			{0, 15, 0, 37},
+			{4, 0, 0, 0},
+		},
+	}
+
+	// the row of 4 alone has nothing called yet
+	numbers := ticket.waitingNumbers([]int{1, 37})
+	if !reflect.DeepEqual(numbers, []int{15, 23}) {
+		t.Fatalf("expected [15 23], got %v", numbers)
+	}
+
+	if numbers := ticket.waitingNumbers([]int{1, 23, 15, 37, 4}); len(numbers) != 0 {
+		t.Fatalf("expected nothing to wait for, got %v", numbers)
+	}
+}
+
+func TestSingleNumberRowsNeverWait(t *testing.T) {
+	config := DefaultGameSettings().Ticket
+	config.MaxNumberOfRow = 1
+	config.Layout = LAYOUT_RANDOM
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	ticket := NewTicket(1, config)
+
+	if numbers := ticket.waitingNumbers(nil); len(numbers) != 0 {
+		t.Fatalf("expected nothing to wait for before the draw, got %v", numbers)
+	}
+	// every called number completes its row at once
+	var result []int
+	for _, row := range ticket.board {
+		called := len(result)
+		for _, v := range row {
+			if v > 0 {
+				result = append(result, v)
+			}
+		}
+		if len(result) != called+1 {
+			t.Fatalf("expected a single number in row %v", row)
+		}
+		if numbers := ticket.waitingNumbers(result); len(numbers) != 0 {
+			t.Fatalf("expected nothing to wait for after %v, got %v", result, numbers)
+		}
+	}
+}
+
+func TestTicketNumbersAreDrawable(t *testing.T) {
+	for _, max := range []int{90, 75, 70, 30} {
+		config := DefaultGameSettings().Ticket.withSpace(NumberSpace{Max: max})
+		config.MaxNumberOfRow = 3
+		config.Layout = LAYOUT_RANDOM
+		if err := config.validate(); err != nil {
+			t.Fatalf("max=%d: %s", max, err)
+		}
+
+		game := NewGame(time.Second, config).(*Game)
+		game.seed.init(config.Space().Numbers())
+		drawable := map[int]bool{}
+		for _, v := range game.seed.values() {
+			drawable[v] = true
+		}
+
+		space := config.Space()
+		for i := 0; i < 200; i++ {
+			ticket := NewTicket(1, config)
+			for _, row := range ticket.board {
+				for j, v := range row {
+					if v == 0 {
+						continue
+					}
+					if !drawable[v] {
+						t.Fatalf("max=%d: ticket number %d is never drawn", max, v)
+					}
+					if min, max := space.ColumnRange(j); v < min || v > max {
+						t.Fatalf("number %d is outside of column %d (%d-%d)", v, j, min, max)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestNumberSpaceColumns(t *testing.T) {
+	space := NumberSpace{Max: CLASSIC_NUMBER_SPACE}
+	if space.Columns() != 9 {
+		t.Fatalf("expected 9 columns, got %d", space.Columns())
+	}
+
+	count := 0
+	for i := 0; i < space.Columns(); i++ {
+		for _, v := range space.Column(i) {
+			if space.ColumnOf(v) != i {
+				t.Fatalf("number %d belongs to column %d, not %d", v, space.ColumnOf(v), i)
+			}
+			count++
+		}
+	}
+	if count != CLASSIC_NUMBER_SPACE {
+		t.Fatalf("columns hold %d numbers instead of %d", count, CLASSIC_NUMBER_SPACE)
+	}
+}
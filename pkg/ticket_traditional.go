@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The traditional ticket is printed as 3 blocks of 3 rows over the classic
+// 1-90 number space. Every row has NUMBER_PER_ROW numbers, every column
+// holds NUMBER_PER_ROW numbers too (45 numbers over 9 columns) sorted
+// ascending from top to bottom, and every column has at least one number
+// in each block.
+
+var blockSplits = [][]int{
+	{1, 1, 3}, {1, 3, 1}, {3, 1, 1},
+	{1, 2, 2}, {2, 1, 2}, {2, 2, 1},
+}
+
+var (
+	traditionalRand     = rand.New(rand.NewSource(time.Now().UnixNano()))
+	traditionalRandLock sync.Mutex
+)
+
+func (ticket *Ticket) generateTraditionalNumbers() {
+	ticket.lock.Lock()
+	defer ticket.lock.Unlock()
+
+	traditionalRandLock.Lock()
+	defer traditionalRandLock.Unlock()
+	random := traditionalRand
+
+	layout := traditionalLayout(random)
+	space := ticket.Config.Space()
+
+	for col := 0; col < COLUMN_SIZE; col++ {
+		values := space.Column(col)
+		random.Shuffle(len(values), func(i, j int) {
+			values[i], values[j] = values[j], values[i]
+		})
+		values = values[:NUMBER_PER_ROW]
+		sort.Ints(values)
+
+		k := 0
+		for row := 0; row < ROW_SIZE; row++ {
+			if layout[row][col] {
+				ticket.board[row][col] = values[k]
+				k++
+			}
+		}
+	}
+}
+
+// traditionalLayout picks the cells holding a number. The numbers of every
+// column are split over the blocks first, the splits are retried until
+// every block holds exactly the numbers of its rows.
+func traditionalLayout(random *rand.Rand) [][]bool {
+	blocks := ROW_SIZE / BLOCK_SIZE
+
+	for {
+		counts := make([][]int, blocks)
+		for b := range counts {
+			counts[b] = make([]int, COLUMN_SIZE)
+		}
+		for col := 0; col < COLUMN_SIZE; col++ {
+			split := blockSplits[random.Intn(len(blockSplits))]
+			for b := 0; b < blocks; b++ {
+				counts[b][col] = split[b]
+			}
+		}
+
+		var layout [][]bool
+		for b := 0; b < blocks; b++ {
+			block, ok := fillBlock(random, counts[b])
+			if !ok {
+				layout = nil
+				break
+			}
+			layout = append(layout, block...)
+		}
+		if layout != nil {
+			return layout
+		}
+	}
+}
+
+// fillBlock places the column counts into the rows of a block, every row
+// ending with NUMBER_PER_ROW numbers. Columns are placed from the fullest
+// one into the rows with the most room left, which always succeeds when
+// the counts add up.
+func fillBlock(random *rand.Rand, counts []int) ([][]bool, bool) {
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	if total != BLOCK_SIZE*NUMBER_PER_ROW {
+		return nil, false
+	}
+
+	columns := random.Perm(len(counts))
+	sort.SliceStable(columns, func(i, j int) bool {
+		return counts[columns[i]] > counts[columns[j]]
+	})
+
+	block := make([][]bool, BLOCK_SIZE)
+	room := make([]int, BLOCK_SIZE)
+	for i := range block {
+		block[i] = make([]bool, len(counts))
+		room[i] = NUMBER_PER_ROW
+	}
+
+	for _, col := range columns {
+		rows := random.Perm(BLOCK_SIZE)
+		sort.SliceStable(rows, func(i, j int) bool {
+			return room[rows[i]] > room[rows[j]]
+		})
+		for _, row := range rows[:counts[col]] {
+			if room[row] == 0 {
+				return nil, false
+			}
+			block[row][col] = true
+			room[row]--
+		}
+	}
+
+	return block, true
+}
@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func TestTraditionalTicketProperties(t *testing.T) {
+	config := DefaultGameSettings().Ticket
+	if !config.isTraditional() {
+		t.Fatal("expected the default ticket to be traditional")
+	}
+	space := config.Space()
+
+	for n := 0; n < 5000; n++ {
+		ticket := NewTicket(1, config)
+		seen := map[int]bool{}
+
+		for i, row := range ticket.board {
+			count := 0
+			for _, v := range row {
+				if v != 0 {
+					count++
+				}
+			}
+			if count != NUMBER_PER_ROW {
+				t.Fatalf("row %d has %d numbers: %v", i, count, ticket.board)
+			}
+		}
+
+		for j := 0; j < COLUMN_SIZE; j++ {
+			min, max := space.ColumnRange(j)
+			count, previous := 0, 0
+			blockCounts := make([]int, ROW_SIZE/BLOCK_SIZE)
+			for i := 0; i < ROW_SIZE; i++ {
+				v := ticket.board[i][j]
+				if v == 0 {
+					continue
+				}
+				if v < min || v > max {
+					t.Fatalf("number %d is outside of column %d (%d-%d)", v, j, min, max)
+				}
+				if v <= previous {
+					t.Fatalf("column %d is not ascending: %v", j, ticket.board)
+				}
+				if seen[v] {
+					t.Fatalf("number %d is repeated", v)
+				}
+				seen[v] = true
+				previous = v
+				count++
+				blockCounts[i/BLOCK_SIZE]++
+			}
+			if count != NUMBER_PER_ROW {
+				t.Fatalf("column %d has %d numbers instead of %d", j, count, NUMBER_PER_ROW)
+			}
+			for b, blockCount := range blockCounts {
+				if blockCount == 0 {
+					t.Fatalf("column %d is empty in block %d: %v", j, b, ticket.board)
+				}
+			}
+		}
+	}
+}
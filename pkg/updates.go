@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// UpdateQueue dispatches the updates of every chat in order, one at a time,
+// while the chats are handled concurrently. A chat which waits for its rate
+// limit or its lobby lock does not hold back the others, and the replies of
+// a chat keep the order of its updates in the outbound queue.
+type UpdateQueue struct {
+	handler Handler
+	// pending are the updates waiting for the worker of their chat, a chat
+	// has a worker as long as it has an entry
+	pending map[int64][]*tgbotapi.Update
+	workers sync.WaitGroup
+
+	lock sync.Mutex
+}
+
+func NewUpdateQueue(handler Handler) *UpdateQueue {
+	return &UpdateQueue{
+		handler: handler,
+		pending: make(map[int64][]*tgbotapi.Update),
+	}
+}
+
+// Push queues the update behind the other updates of its chat.
+func (queue *UpdateQueue) Push(update *tgbotapi.Update) {
+	chatId := updateChat(update)
+
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	if updates, working := queue.pending[chatId]; working {
+		queue.pending[chatId] = append(updates, update)
+		return
+	}
+	queue.pending[chatId] = nil
+	queue.workers.Add(1)
+	go queue.work(chatId, update)
+}
+
+// Wait blocks until every queued update was dispatched.
+func (queue *UpdateQueue) Wait() {
+	queue.workers.Wait()
+}
+
+// work dispatches the updates of the chat until none is left.
+func (queue *UpdateQueue) work(chatId int64, update *tgbotapi.Update) {
+	defer queue.workers.Done()
+
+	for {
+		Dispatch(queue.handler, update)
+
+		queue.lock.Lock()
+		updates := queue.pending[chatId]
+		if len(updates) == 0 {
+			delete(queue.pending, chatId)
+			queue.lock.Unlock()
+			return
+		}
+		update = updates[0]
+		queue.pending[chatId] = updates[1:]
+		queue.lock.Unlock()
+	}
+}
+
+// updateChat is the chat an update belongs to, the user for updates without
+// a chat.
+func updateChat(update *tgbotapi.Update) int64 {
+	if chat := update.FromChat(); chat != nil {
+		return chat.ID
+	}
+	if user := update.SentFrom(); user != nil {
+		return user.ID
+	}
+	return 0
+}
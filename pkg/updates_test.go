@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+// blockingHandler holds the updates of a chat until it is released.
+type blockingHandler struct {
+	recordingHandler
+	chatId  int64
+	release chan struct{}
+}
+
+func (handler *blockingHandler) Command(update *tgbotapi.Update) error {
+	if update.Message.Chat.ID == handler.chatId {
+		<-handler.release
+	}
+	return handler.record("command", update)
+}
+
+func TestUpdateQueue(t *testing.T) {
+	slow, fast := telegramtest.Group(-1060), telegramtest.Group(-1061)
+	user := telegramtest.User(11, "player_teo")
+	handler := &blockingHandler{chatId: slow.ID, release: make(chan struct{})}
+	queue := NewUpdateQueue(handler)
+
+	for i := 1; i <= 20; i++ {
+		queue.Push(telegramtest.MessageUpdate(slow, user, i, "/newgame"))
+		queue.Push(telegramtest.MessageUpdate(fast, user, i, "/newgame"))
+	}
+
+	// a chat waiting for its turn does not hold back the others
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		handler.lock.Lock()
+		done := len(handler.updates) == 20
+		handler.lock.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("updates of the other chat were held back")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(handler.release)
+	queue.Wait()
+
+	// the updates of every chat keep their order
+	next := map[int64]int{slow.ID: 1, fast.ID: 1}
+	for _, update := range handler.updates {
+		chatId := update.Message.Chat.ID
+		if update.Message.MessageID != next[chatId] {
+			t.Fatalf("chat %d got message %d, want %d", chatId, update.Message.MessageID, next[chatId])
+		}
+		next[chatId]++
+	}
+	if next[slow.ID] != 21 || next[fast.ID] != 21 {
+		t.Fatalf("updates were lost: %v", next)
+	}
+}
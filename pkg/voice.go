@@ -0,0 +1,293 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	VOICE_MANIFEST = "manifest.json"
+	MAX_CLIP_SIZE  = 1 << 20
+
+	// OPUS_SAMPLE_RATE is the rate of the Ogg Opus granule positions
+	OPUS_SAMPLE_RATE = 48000
+)
+
+var (
+	opusHeadMagic = []byte("OpusHead")
+	opusTagsMagic = []byte("OpusTags")
+)
+
+// VoiceManifest describes a voice pack. It is the manifest.json of the
+// pack directory and points to OGG/Opus clips relative to it:
+//
+//	{
+//	  "name": "Giọng miền Nam",
+//	  "numbers": {"1": "numbers/01.ogg", ..., "90": "numbers/90.ogg"},
+//	  "rhymes": {"31": ["rhymes/31.ogg"]}
+//	}
+//
+// Every number from 1 to 90 needs a clip, the rhyme clips are optional and
+// several variants of a number are picked at random.
+type VoiceManifest struct {
+	Name    string              `json:"name"`
+	Numbers map[string]string   `json:"numbers"`
+	Rhymes  map[string][]string `json:"rhymes"`
+}
+
+// VoicePack holds the clips of a voice pack, validated when it is loaded so
+// a broken pack is noticed at startup instead of in the middle of a game.
+type VoicePack struct {
+	Name     string
+	numbers  map[int]*opusClip
+	rhymes   map[int][]*opusClip
+	channels int
+	random   *rand.Rand
+
+	lock sync.Mutex
+}
+
+// opusClip is a single logical Ogg Opus stream split into its header pages
+// (OpusHead and OpusTags) and its audio pages.
+type opusClip struct {
+	headers  []oggPage
+	audio    []oggPage
+	channels int
+	preSkip  int
+	// samples is the granule position of the last audio page
+	samples uint64
+}
+
+func LoadVoicePack(dir string) (*VoicePack, error) {
+	data, err := os.ReadFile(filepath.Join(dir, VOICE_MANIFEST))
+	if err != nil {
+		return nil, err
+	}
+	var manifest VoiceManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", VOICE_MANIFEST, err)
+	}
+
+	pack := &VoicePack{
+		Name:    manifest.Name,
+		numbers: make(map[int]*opusClip),
+		rhymes:  make(map[int][]*opusClip),
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for key, path := range manifest.Numbers {
+		number, err := voiceNumber(key)
+		if err != nil {
+			return nil, err
+		}
+		if pack.numbers[number], err = pack.loadClip(dir, path); err != nil {
+			return nil, err
+		}
+	}
+	for number := 1; number <= CLASSIC_NUMBER_SPACE; number++ {
+		if pack.numbers[number] == nil {
+			return nil, fmt.Errorf("voice pack %s has no clip for number %d", dir, number)
+		}
+	}
+	for key, paths := range manifest.Rhymes {
+		number, err := voiceNumber(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			clip, err := pack.loadClip(dir, path)
+			if err != nil {
+				return nil, err
+			}
+			pack.rhymes[number] = append(pack.rhymes[number], clip)
+		}
+	}
+
+	return pack, nil
+}
+
+func voiceNumber(key string) (int, error) {
+	number, err := strconv.Atoi(key)
+	if err != nil || number < 1 || number > CLASSIC_NUMBER_SPACE {
+		return 0, fmt.Errorf("%q is not a number from 1 to %d", key, CLASSIC_NUMBER_SPACE)
+	}
+	return number, nil
+}
+
+// loadClip reads a clip of the pack. All clips must have the same channel
+// count so they can be joined into one stream.
+func (pack *VoicePack) loadClip(dir string, path string) (*opusClip, error) {
+	clean := filepath.Clean(path)
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("clip %s is outside of the voice pack", path)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, clean))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MAX_CLIP_SIZE {
+		return nil, fmt.Errorf("clip %s is larger than %d bytes", path, MAX_CLIP_SIZE)
+	}
+
+	clip, err := parseOpusClip(data)
+	if err != nil {
+		return nil, fmt.Errorf("clip %s: %w", path, err)
+	}
+	if pack.channels == 0 {
+		pack.channels = clip.channels
+	}
+	if clip.channels != pack.channels {
+		return nil, fmt.Errorf("clip %s has %d channels, the pack has %d", path, clip.channels, pack.channels)
+	}
+
+	return clip, nil
+}
+
+// parseOpusClip validates an Ogg Opus file (RFC 7845): a single logical
+// stream starting with the OpusHead page, followed by the OpusTags packet
+// which ends its page, and some audio.
+func parseOpusClip(data []byte) (*opusClip, error) {
+	pages, err := parseOggPages(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) < 3 {
+		return nil, errors.New("not an Ogg Opus file with audio")
+	}
+
+	head := pages[0]
+	if head.HeaderType&OGG_BOS == 0 || len(head.Segments) != 1 || !bytes.HasPrefix(head.Body, opusHeadMagic) || len(head.Body) < 19 {
+		return nil, errors.New("missing OpusHead")
+	}
+	if version := head.Body[8]; version>>4 != 0 {
+		return nil, fmt.Errorf("unsupported Opus version %d", version)
+	}
+	clip := &opusClip{
+		channels: int(head.Body[9]),
+		preSkip:  int(binary.LittleEndian.Uint16(head.Body[10:12])),
+	}
+	if clip.channels < 1 || clip.channels > 2 || head.Body[18] != 0 {
+		return nil, errors.New("only mono and stereo Opus is supported")
+	}
+
+	if !bytes.HasPrefix(pages[1].Body, opusTagsMagic) {
+		return nil, errors.New("missing OpusTags")
+	}
+	tags := 1
+	for !pages[tags].endsPacket() {
+		tags++
+		if tags == len(pages) {
+			return nil, errors.New("unterminated OpusTags")
+		}
+	}
+	clip.headers = pages[:tags+1]
+	clip.audio = pages[tags+1:]
+	if len(clip.audio) == 0 {
+		return nil, errors.New("no audio")
+	}
+
+	for _, page := range pages {
+		if page.Serial != head.Serial {
+			return nil, errors.New("more than one logical stream")
+		}
+		if page.Granule != OGG_NO_GRANULE && page.Granule > clip.samples {
+			clip.samples = page.Granule
+		}
+	}
+
+	return clip, nil
+}
+
+// concatOpus joins the clips into one logical stream: the headers of the
+// first clip are kept, the audio pages of the others get its serial, the
+// next page sequence numbers and granule positions shifted by the samples
+// before them. The pre-skip of the first clip is in its header, the one of
+// every later clip is taken off the granule positions, so the priming
+// samples are neither counted in the duration nor played at the end of the
+// stream. The checksums are computed again for every page.
+func concatOpus(clips []*opusClip) ([]byte, time.Duration) {
+	first := clips[0]
+	buf := new(bytes.Buffer)
+	sequence := uint32(0)
+	write := func(page oggPage) {
+		page.Serial = first.headers[0].Serial
+		page.Sequence = sequence
+		sequence++
+		buf.Write(page.bytes())
+	}
+
+	for _, page := range first.headers {
+		write(page)
+	}
+	offset := uint64(0)
+	for i, clip := range clips {
+		skip := uint64(0)
+		if i > 0 {
+			skip = uint64(clip.preSkip)
+		}
+		for j, page := range clip.audio {
+			page.HeaderType &^= OGG_BOS | OGG_EOS
+			if i == len(clips)-1 && j == len(clip.audio)-1 {
+				page.HeaderType |= OGG_EOS
+			}
+			if page.Granule != OGG_NO_GRANULE {
+				if page.Granule < skip {
+					page.Granule = skip
+				}
+				page.Granule += offset - skip
+			}
+			write(page)
+		}
+		offset += clip.samples - skip
+	}
+
+	samples := offset - uint64(first.preSkip)
+	return buf.Bytes(), time.Duration(samples) * time.Second / OPUS_SAMPLE_RATE
+}
+
+// announcement joins the clip of the number with a rhyme clip, depending on
+// the rhyme style of the lobby.
+func (pack *VoicePack) announcement(number int, style string) ([]byte, time.Duration) {
+	numberClip := pack.numbers[number]
+	clips := []*opusClip{numberClip}
+
+	if style != RHYME_STYLE_PLAIN {
+		if rhymes := pack.rhymes[number]; len(rhymes) > 0 {
+			pack.lock.Lock()
+			rhyme := rhymes[pack.random.Intn(len(rhymes))]
+			pack.lock.Unlock()
+
+			if style == RHYME_STYLE_RHYME {
+				clips = []*opusClip{rhyme}
+			} else {
+				clips = append(clips, rhyme)
+			}
+		}
+	}
+
+	return concatOpus(clips)
+}
+
+// sendVoice reads the released number out loud in lobbies which turned the
+// voice on.
+func (handler *MessageHandler) sendVoice(game *Lobby, number int) {
+	if !game.voice || handler.voices == nil || handler.voices.numbers[number] == nil {
+		return
+	}
+
+	data, duration := handler.voices.announcement(number, game.rhymeStyle)
+	voice := tgbotapi.NewVoice(game.ChatId, tgbotapi.FileBytes{Name: fmt.Sprintf("so-%d.ogg", number), Bytes: data})
+	voice.Duration = int((duration + time.Second - 1) / time.Second)
+	handler.post(voice)
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+// opusTestClip builds an Ogg Opus file with the given number of 20ms audio
+// packets, one per page. The packets are not real Opus frames, the pack
+// only looks at the Ogg framing.
+func opusTestClip(serial uint32, channels byte, packets int) []byte {
+	head := append([]byte("OpusHead"), 1, channels, 0, 0, 0x80, 0xbb, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint16(head[10:12], 312)
+	pages := []oggPage{
+		{HeaderType: OGG_BOS, Segments: []byte{byte(len(head))}, Body: head},
+		{Segments: []byte{12}, Body: []byte("OpusTagslotovn")[:12]},
+	}
+	for i := 0; i < packets; i++ {
+		pages = append(pages, oggPage{
+			Granule:  uint64(312 + 960*(i+1)),
+			Segments: []byte{3},
+			Body:     []byte{0xfc, byte(i), 0xff},
+		})
+	}
+	pages[len(pages)-1].HeaderType |= OGG_EOS
+
+	var data []byte
+	for i, page := range pages {
+		page.Serial = serial
+		page.Sequence = uint32(i)
+		data = append(data, page.bytes()...)
+	}
+	return data
+}
+
+func writeTestVoicePack(t *testing.T, edit func(manifest *VoiceManifest, dir string)) string {
+	dir := t.TempDir()
+	manifest := VoiceManifest{Name: "test", Numbers: map[string]string{}, Rhymes: map[string][]string{}}
+	for number := 1; number <= CLASSIC_NUMBER_SPACE; number++ {
+		name := fmt.Sprintf("%02d.ogg", number)
+		if err := os.WriteFile(filepath.Join(dir, name), opusTestClip(uint32(number), 1, 2), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		manifest.Numbers[fmt.Sprint(number)] = name
+	}
+	if err := os.WriteFile(filepath.Join(dir, "rhyme-31.ogg"), opusTestClip(3131, 1, 5), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest.Rhymes["31"] = []string{"rhyme-31.ogg"}
+	if edit != nil {
+		edit(&manifest, dir)
+	}
+
+	data, _ := json.Marshal(manifest)
+	if err := os.WriteFile(filepath.Join(dir, VOICE_MANIFEST), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestVoiceAnnouncement(t *testing.T) {
+	pack, err := LoadVoicePack(writeTestVoicePack(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, duration := pack.announcement(31, RHYME_STYLE_BOTH)
+	// the priming samples of every clip are left out
+	if expected := 140 * time.Millisecond; duration != expected {
+		t.Fatalf("expected 7 packets of 20ms without the pre-skips, got %s", duration)
+	}
+	pages, err := parseOggPages(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2+2+5 {
+		t.Fatalf("expected the headers once and 7 audio pages, got %d pages", len(pages))
+	}
+	granule := uint64(0)
+	for i, page := range pages {
+		if page.Serial != 31 || page.Sequence != uint32(i) {
+			t.Fatalf("page %d has serial %d and sequence %d", i, page.Serial, page.Sequence)
+		}
+		if (page.HeaderType&OGG_BOS != 0) != (i == 0) || (page.HeaderType&OGG_EOS != 0) != (i == len(pages)-1) {
+			t.Fatalf("page %d has header type %d", i, page.HeaderType)
+		}
+		if page.Granule < granule {
+			t.Fatalf("granule of page %d goes back", i)
+		}
+		granule = page.Granule
+	}
+	// the last granule counts the pre-skip of the header and the audio
+	if granule != 312+7*960 {
+		t.Fatalf("expected the stream to end at granule %d, got %d", 312+7*960, granule)
+	}
+
+	if _, duration := pack.announcement(31, RHYME_STYLE_PLAIN); duration != 40*time.Millisecond {
+		t.Fatalf("expected only the number, got %s", duration)
+	}
+	if _, duration := pack.announcement(31, RHYME_STYLE_RHYME); duration != 100*time.Millisecond {
+		t.Fatalf("expected only the rhyme, got %s", duration)
+	}
+}
+
+func TestInvalidVoicePacks(t *testing.T) {
+	invalids := map[string]func(manifest *VoiceManifest, dir string){
+		"missing number": func(manifest *VoiceManifest, dir string) {
+			delete(manifest.Numbers, "90")
+		},
+		"number out of range": func(manifest *VoiceManifest, dir string) {
+			manifest.Numbers["91"] = "01.ogg"
+		},
+		"clip outside the pack": func(manifest *VoiceManifest, dir string) {
+			manifest.Rhymes["1"] = []string{"../01.ogg"}
+		},
+		"bad checksum": func(manifest *VoiceManifest, dir string) {
+			data := opusTestClip(7, 1, 2)
+			data[len(data)-1] ^= 0xff
+			os.WriteFile(filepath.Join(dir, "07.ogg"), data, 0o644)
+		},
+		"not opus": func(manifest *VoiceManifest, dir string) {
+			os.WriteFile(filepath.Join(dir, "07.ogg"), []byte("RIFF....WAVEfmt "), 0o644)
+		},
+		"channel mismatch": func(manifest *VoiceManifest, dir string) {
+			os.WriteFile(filepath.Join(dir, "rhyme-31.ogg"), opusTestClip(31, 2, 2), 0o644)
+		},
+	}
+	for name, edit := range invalids {
+		if _, err := LoadVoicePack(writeTestVoicePack(t, edit)); err == nil {
+			t.Errorf("expected the pack with %s to be rejected", name)
+		}
+	}
+}
+
+func TestSendVoice(t *testing.T) {
+	handler, server := newTestHandler(t)
+	pack, err := LoadVoicePack(writeTestVoicePack(t, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := telegramtest.Group(-1006)
+	host := telegramtest.User(10, "lotovn_host")
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame voice=on"))
+	game := handler.lobbies.Get(group.ID)
+	if game.voice {
+		t.Fatal("voice turned on without a voice pack")
+	}
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_SETTING+";voice;0"))
+	if game.voice || lastAnswer(t, server).Params.Get("show_alert") != "true" {
+		t.Fatal("expected an alert when the bot has no voice pack")
+	}
+
+	handler.voices = pack
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_SETTING+";voice;0"))
+	if !game.voice {
+		t.Fatal("host could not turn the voice on")
+	}
+
+	server.Reset()
+	game.lock.Lock()
+	game.lifecycle.start()
+	game.lock.Unlock()
+	number := <-game.lifecycle.releaseChanel()
+	handler.release(game, number)
+	game.lifecycle.stop()
+
+	voices := server.Requests("sendVoice")
+	if len(voices) != 1 {
+		t.Fatalf("expected a voice message, got %d", len(voices))
+	}
+	if _, err := parseOggPages(voices[0].Files["voice"]); err != nil {
+		t.Fatalf("voice message is not an Ogg file: %s", err)
+	}
+	if voices[0].Params.Get("duration") != "1" {
+		t.Fatalf("expected the duration rounded up to a second, got %s", voices[0].Params.Get("duration"))
+	}
+}
@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aquasecurity/table"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// DAILY_ALLOWANCE is credited to every wallet once per day, the day
+	// follows the Vietnamese clock.
+	DAILY_ALLOWANCE = 100
+	MAX_TOPUP       = 100000
+	// BALANCE_HISTORY is how many transactions /balance shows
+	BALANCE_HISTORY = 5
+)
+
+var economyTimezone = time.FixedZone("ICT", 7*60*60)
+
+// claimAllowance credits the daily allowance unless the wallet already got
+// it today.
+func (handler *MessageHandler) claimAllowance(chatId int64, userId int64) error {
+	day := time.Now().In(economyTimezone).Format("2006-01-02")
+	err := handler.storage.PostTransaction(transfer(
+		fmt.Sprintf("%s:%d:%d:%s", TX_ALLOWANCE, chatId, userId, day),
+		TX_ALLOWANCE,
+		T(handler.chatLanguage(chatId), "memo.allowance"),
+		MINT_ACCOUNT,
+		walletAccount(chatId, userId),
+		DAILY_ALLOWANCE,
+	))
+	if errors.Is(err, ErrDuplicateTransaction) {
+		return nil
+	}
+
+	return err
+}
+
+// chargeTicket moves the ticket price from the wallet of the player into
+// the prize pool of the lobby. The ticket id keeps a new attempt at the same
+// index apart from one which was refunded.
+func (handler *MessageHandler) chargeTicket(game *Lobby, player *Player, index int, ticket *Ticket) error {
+	if game.ticketPrice == 0 {
+		return nil
+	}
+	if err := handler.claimAllowance(game.ChatId, player.Id); err != nil {
+		return InternalError(err)
+	}
+
+	wallet := walletAccount(game.ChatId, player.Id)
+	err := handler.storage.PostTransaction(transfer(
+		fmt.Sprintf("%s:%d:%d:%d:%d:%s", TX_TICKET, game.ChatId, game.GameId, player.Id, index, ticket.Id),
+		TX_TICKET,
+		T(handler.chatLanguage(game.ChatId), "memo.ticket", index+1, game.GameId),
+		wallet,
+		potAccount(game.ChatId, game.GameId),
+		game.ticketPrice,
+	))
+	if errors.Is(err, ErrInsufficientFunds) {
+		balance, _ := handler.storage.Balance(wallet)
+		return UserError(
+			"wallet.insufficient",
+			player.Username, game.ticketPrice, balance, CMD_TOPUP,
+		)
+	}
+	if err != nil {
+		return InternalError(err)
+	}
+	player.Paid += game.ticketPrice
+
+	return nil
+}
+
+// refundTicket gives the price of a ticket which could not be delivered
+// back to the player.
+func (handler *MessageHandler) refundTicket(game *Lobby, player *Player, index int, ticket *Ticket) error {
+	if game.ticketPrice == 0 {
+		return nil
+	}
+
+	err := handler.storage.PostTransaction(transfer(
+		fmt.Sprintf("%s:%d:%d:%d:%d:%s", TX_REFUND, game.ChatId, game.GameId, player.Id, index, ticket.Id),
+		TX_REFUND,
+		T(handler.chatLanguage(game.ChatId), "memo.ticket_refund", index+1, game.GameId),
+		potAccount(game.ChatId, game.GameId),
+		walletAccount(game.ChatId, player.Id),
+		game.ticketPrice,
+	))
+	if err != nil {
+		return err
+	}
+	player.Paid -= game.ticketPrice
+
+	return nil
+}
+
+// pot is the prize pool of the lobby.
+func (game *Lobby) pot() int64 {
+	var pot int64
+	for _, player := range game.players {
+		pot += player.Paid
+	}
+	return pot
+}
+
+// settlePot pays the prize pool out when the game ends. Validated winners
+// split it evenly, the first winner keeps the odd coins. Without a winner
+// every player gets their stake back. It returns the announcement.
+func (handler *MessageHandler) settlePot(game *Lobby) (string, error) {
+	pot := potAccount(game.ChatId, game.GameId)
+	amount, err := handler.storage.Balance(pot)
+	if err != nil || amount == 0 {
+		return "", err
+	}
+
+	transaction := Transaction{
+		Id:      fmt.Sprintf("%s:%d:%d", TX_PRIZE, game.ChatId, game.GameId),
+		Kind:    TX_PRIZE,
+		Time:    time.Now(),
+		Entries: []Entry{{Account: pot, Amount: -amount}},
+	}
+
+	language := handler.chatLanguage(game.ChatId)
+	var lines []string
+	if len(game.winners) > 0 {
+		transaction.Memo = T(language, "memo.prize", game.GameId)
+		share := amount / int64(len(game.winners))
+		for i, winner := range game.winners {
+			prize := share
+			if i == 0 {
+				prize += amount % int64(len(game.winners))
+			}
+			transaction.Entries = append(transaction.Entries, Entry{Account: walletAccount(game.ChatId, winner.Id), Amount: prize})
+			lines = append(lines, T(language, "pot.share", winner.Username, prize))
+		}
+		return T(language, "pot.paid", amount, strings.Join(lines, ", ")), handler.storage.PostTransaction(transaction)
+	}
+
+	transaction.Kind = TX_REFUND
+	transaction.Memo = T(language, "memo.pot_refund", game.GameId)
+	var refunded int64
+	for _, player := range game.players {
+		if player.Paid == 0 {
+			continue
+		}
+		transaction.Entries = append(transaction.Entries, Entry{Account: walletAccount(game.ChatId, player.Id), Amount: player.Paid})
+		refunded += player.Paid
+	}
+	if refunded != amount {
+		return "", fmt.Errorf("pot %s holds %d but players paid %d", pot, amount, refunded)
+	}
+
+	return T(language, "pot.refunded", amount), handler.storage.PostTransaction(transaction)
+}
+
+func (handler *MessageHandler) balance(update *tgbotapi.Update) (string, error) {
+	if update.Message.Chat.IsPrivate() {
+		return "", UserError("wallet.group_only", CMD_BALANCE)
+	}
+
+	chatId, user := update.Message.Chat.ID, update.Message.From
+	if err := handler.claimAllowance(chatId, user.ID); err != nil {
+		return "", InternalError(err)
+	}
+	wallet := walletAccount(chatId, user.ID)
+	balance, err := handler.storage.Balance(wallet)
+	if err != nil {
+		return "", InternalError(err)
+	}
+	transactions, err := handler.storage.Transactions(wallet)
+	if err != nil {
+		return "", InternalError(err)
+	}
+	if len(transactions) > BALANCE_HISTORY {
+		transactions = transactions[len(transactions)-BALANCE_HISTORY:]
+	}
+
+	language := handler.chatLanguage(chatId)
+	buf := new(bytes.Buffer)
+	tb := table.New(buf)
+	tb.SetHeaders(T(language, "wallet.header.date"), T(language, "wallet.header.memo"), T(language, "wallet.header.coins"))
+	for i := len(transactions) - 1; i >= 0; i-- {
+		transaction := transactions[i]
+		var amount int64
+		for _, entry := range transaction.Entries {
+			if entry.Account == wallet {
+				amount += entry.Amount
+			}
+		}
+		tb.AddRow(
+			transaction.Time.In(economyTimezone).Format("02/01 15:04"),
+			transaction.Memo,
+			fmt.Sprintf("%+d", amount),
+		)
+	}
+	tb.Render()
+
+	return T(language, "wallet.balance", user.UserName, balance, buf.String()), nil
+}
+
+// topup lets chat administrators mint coins into a wallet:
+// "/topup @username 100", or "/topup 100" as a reply.
+func (handler *MessageHandler) topup(update *tgbotapi.Update) (string, error) {
+	chatId, from := update.Message.Chat.ID, update.Message.From
+	if !handler.admins.IsAdmin(chatId, from.ID) {
+		return "", PermissionError("topup.admin_only")
+	}
+
+	args := strings.Fields(update.Message.CommandArguments())
+	if len(args) == 0 {
+		return "", UserError("topup.usage", CMD_TOPUP, CMD_TOPUP)
+	}
+	amount, err := strconv.ParseInt(args[len(args)-1], 10, 64)
+	if err != nil || amount <= 0 || amount > MAX_TOPUP {
+		return "", UserError("topup.amount_range", MAX_TOPUP)
+	}
+
+	username := ""
+	if len(args) > 1 {
+		username = args[0]
+	}
+	game := handler.lobbies.Get(chatId)
+	if game != nil {
+		game.lock.Lock()
+		defer game.lock.Unlock()
+	}
+	userId, name, found := mentionedUser(update.Message, game, username)
+	if !found {
+		return "", UserError("topup.not_found", username, CMD_TOPUP, amount)
+	}
+
+	language := handler.chatLanguage(chatId)
+	err = handler.storage.PostTransaction(transfer(
+		fmt.Sprintf("%s:%d:%d:%d", TX_TOPUP, chatId, update.Message.MessageID, userId),
+		TX_TOPUP,
+		T(language, "memo.topup", from.UserName),
+		MINT_ACCOUNT,
+		walletAccount(chatId, userId),
+		amount,
+	))
+	if err != nil {
+		return "", InternalError(err)
+	}
+
+	return T(language, "topup.done", amount, name), nil
+}
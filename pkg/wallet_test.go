@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ted-vo/lotovn-telegram-bot/pkg/telegramtest"
+)
+
+func balanceOf(t *testing.T, handler *MessageHandler, chatId int64, userId int64) int64 {
+	t.Helper()
+
+	balance, err := handler.storage.Balance(walletAccount(chatId, userId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+func TestPrizePool(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1004)
+	host := telegramtest.User(10, "lotovn_host")
+	players := []*tgbotapi.User{
+		telegramtest.User(11, "player_teo"),
+		telegramtest.User(12, "player_ti"),
+		telegramtest.User(13, "player_tun"),
+	}
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame max=40 rows=3 perrow=2 price=30 tickets=4"))
+	game := handler.lobbies.Get(group.ID)
+	for _, player := range players {
+		Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	}
+	// the daily allowance covers 3 tickets, not 4
+	for i := 0; i < 3; i++ {
+		Dispatch(handler, telegramtest.CallbackUpdate(group, players[2], game.GameId, QUERY_DATA_BUY))
+	}
+	if tickets := len(game.players[players[2].ID].Tickets); tickets != 3 {
+		t.Fatalf("expected 3 tickets paid by the allowance, got %d", tickets)
+	}
+	if answer := lastAnswer(t, server); !strings.Contains(answer.Text(), "Không đủ xu") {
+		t.Fatalf("expected insufficient funds alert, got %q", answer.Text())
+	}
+	if pot, _ := handler.storage.Balance(potAccount(group.ID, game.GameId)); pot != 150 || game.pot() != 150 {
+		t.Fatalf("expected a pot of 150, got %d/%d", pot, game.pot())
+	}
+
+	game.lock.Lock()
+	game.lifecycle = NewGame(time.Millisecond, game.lifecycle.ticketConfig())
+	game.lock.Unlock()
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_START))
+	server.WaitFor(5*time.Second, func(requests []telegramtest.Request) bool {
+		return countTexts(requests, group.ID, "Số ") == 40
+	})
+
+	// two winners split the pot
+	for _, player := range players[:2] {
+		bingo := findButton(ticketButtons(t, server, player.ID), QUERY_DATA_BINGO)
+		Dispatch(handler, telegramtest.CallbackUpdate(telegramtest.Private(player), player, 2, bingo))
+	}
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_STOP))
+
+	expected := map[int64]int64{players[0].ID: 70 + 75, players[1].ID: 70 + 75, players[2].ID: 10}
+	for userId, balance := range expected {
+		if actual := balanceOf(t, handler, group.ID, userId); actual != balance {
+			t.Fatalf("expected player %d to hold %d, got %d", userId, balance, actual)
+		}
+	}
+	if countTexts(server.Requests(), group.ID, "💰 Hũ thưởng 150 xu") != 1 {
+		t.Fatal("prize was not announced")
+	}
+	if err := handler.storage.(*BoltStorage).Audit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPotRefundWithoutWinner(t *testing.T) {
+	handler, _ := newTestHandler(t)
+	group := telegramtest.Group(-1005)
+	host := telegramtest.User(10, "lotovn_host")
+	player := telegramtest.User(11, "player_teo")
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame price=40"))
+	game := handler.lobbies.Get(group.ID)
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	if balance := balanceOf(t, handler, group.ID, player.ID); balance != 60 {
+		t.Fatalf("expected 60 coins left, got %d", balance)
+	}
+
+	Dispatch(handler, telegramtest.CallbackUpdate(group, host, game.GameId, QUERY_DATA_STOP))
+	if balance := balanceOf(t, handler, group.ID, player.ID); balance != DAILY_ALLOWANCE {
+		t.Fatalf("expected the stake back, got %d", balance)
+	}
+}
+
+func TestRegisterAfterBlockedTicket(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1007)
+	host := telegramtest.User(10, "lotovn_host")
+	player := telegramtest.User(11, "player_teo")
+
+	blocked := true
+	server.Handle("sendMessage", func(req telegramtest.Request) (interface{}, error) {
+		if blocked && req.ChatId() == player.ID {
+			return nil, &tgbotapi.Error{Code: http.StatusForbidden, Message: "Forbidden: bot can't initiate conversation with a user"}
+		}
+		return tgbotapi.Message{MessageID: 100, Chat: &tgbotapi.Chat{ID: req.ChatId()}}, nil
+	})
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, host, 1, "/newgame price=30"))
+	game := handler.lobbies.Get(group.ID)
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	if game.players[player.ID] != nil || balanceOf(t, handler, group.ID, player.ID) != DAILY_ALLOWANCE {
+		t.Fatal("undelivered ticket was kept or not refunded")
+	}
+
+	// the player started the bot and registers again
+	blocked = false
+	Dispatch(handler, telegramtest.CallbackUpdate(group, player, game.GameId, QUERY_DATA_REGISTER))
+	if game.players[player.ID] == nil || len(game.players[player.ID].Tickets) != 1 {
+		t.Fatalf("player could not register again: %v", lastAnswer(t, server).Params)
+	}
+	if balance := balanceOf(t, handler, group.ID, player.ID); balance != DAILY_ALLOWANCE-30 {
+		t.Fatalf("expected 70 coins left, got %d", balance)
+	}
+	if err := handler.storage.(*BoltStorage).Audit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBalanceAndTopup(t *testing.T) {
+	handler, server := newTestHandler(t)
+	group := telegramtest.Group(-1006)
+	admin := telegramtest.User(20, "group_admin")
+	player := telegramtest.User(11, "player_teo")
+	server.Handle("getChatAdministrators", func(req telegramtest.Request) (interface{}, error) {
+		return []tgbotapi.ChatMember{{User: admin, Status: "administrator"}}, nil
+	})
+
+	Dispatch(handler, telegramtest.MessageUpdate(group, player, 1, "/balance"))
+	if countTexts(server.Sent(group.ID), group.ID, "💰 @player_teo có <b>100</b> xu") != 1 {
+		t.Fatal("balance with the daily allowance was not shown")
+	}
+	// the allowance is only paid once a day
+	Dispatch(handler, telegramtest.MessageUpdate(group, player, 2, "/balance"))
+	if countTexts(server.Sent(group.ID), group.ID, "💰 @player_teo có <b>100</b> xu") != 2 {
+		t.Fatal("allowance was paid twice")
+	}
+
+	topup := telegramtest.MessageUpdate(group, player, 3, "/topup 500")
+	topup.Message.ReplyToMessage = &tgbotapi.Message{MessageID: 2, From: player, Chat: group}
+	Dispatch(handler, topup)
+	if balance := balanceOf(t, handler, group.ID, player.ID); balance != 100 {
+		t.Fatalf("player topped up their own wallet: %d", balance)
+	}
+
+	topup = telegramtest.MessageUpdate(group, admin, 4, "/topup 500")
+	topup.Message.ReplyToMessage = &tgbotapi.Message{MessageID: 2, From: player, Chat: group}
+	Dispatch(handler, topup)
+	if balance := balanceOf(t, handler, group.ID, player.ID); balance != 600 {
+		t.Fatalf("expected 600 coins after the top-up, got %d", balance)
+	}
+	if err := handler.storage.(*BoltStorage).Audit(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	WEBHOOK_PATH       = "/webhook/"
+	HEALTHZ_PATH       = "/healthz"
+	WEBHOOK_MAX_BODY   = 1 << 20
+	WEBHOOK_READ_LIMIT = 10 * time.Second
+)
+
+type WebhookConfig struct {
+	ListenAddr string
+	Secret     string
+	CertFile   string
+	KeyFile    string
+}
+
+func (config WebhookConfig) validate() error {
+	if len(config.ListenAddr) == 0 {
+		return fmt.Errorf("webhook listen address is required")
+	}
+	if len(config.Secret) == 0 || strings.Contains(config.Secret, "/") {
+		return fmt.Errorf("webhook secret is required and must not contain '/'")
+	}
+	if (len(config.CertFile) == 0) != (len(config.KeyFile) == 0) {
+		return fmt.Errorf("webhook TLS needs both cert and key files")
+	}
+
+	return nil
+}
+
+// Path is where Telegram posts updates; the secret keeps strangers from
+// injecting fake updates.
+func (config WebhookConfig) Path() string {
+	return WEBHOOK_PATH + config.Secret
+}
+
+func (config WebhookConfig) useTLS() bool {
+	return len(config.CertFile) > 0
+}
+
+// WebhookServer receives updates pushed by Telegram and feeds them to the
+// same Handler used by long polling.
+type WebhookServer struct {
+	handler Handler
+	config  WebhookConfig
+	mux     *http.ServeMux
+	server  *http.Server
+}
+
+func NewWebhookServer(handler Handler, config WebhookConfig) (*WebhookServer, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	webhook := &WebhookServer{
+		handler: handler,
+		config:  config,
+		mux:     http.NewServeMux(),
+	}
+	webhook.mux.HandleFunc(HEALTHZ_PATH, webhook.healthz)
+	webhook.mux.HandleFunc(config.Path(), webhook.update)
+	webhook.server = &http.Server{
+		Addr:              config.ListenAddr,
+		Handler:           webhook.mux,
+		ReadHeaderTimeout: WEBHOOK_READ_LIMIT,
+	}
+
+	return webhook, nil
+}
+
+// Handle serves another endpoint under the secret path of the webhook, e.g.
+// the queue depth of the dispatcher, so it is not open to strangers.
+func (webhook *WebhookServer) Handle(pattern string, handler http.Handler) {
+	webhook.mux.Handle(webhook.config.Path()+pattern, handler)
+}
+
+func (webhook *WebhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	webhook.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe blocks until the server is shut down.
+func (webhook *WebhookServer) ListenAndServe() error {
+	log.Infof("Webhook listening on %s", webhook.config.ListenAddr)
+
+	var err error
+	if webhook.config.useTLS() {
+		err = webhook.server.ListenAndServeTLS(webhook.config.CertFile, webhook.config.KeyFile)
+	} else {
+		err = webhook.server.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+func (webhook *WebhookServer) Shutdown(ctx context.Context) error {
+	return webhook.server.Shutdown(ctx)
+}
+
+func (webhook *WebhookServer) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, "ok")
+}
+
+func (webhook *WebhookServer) update(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, WEBHOOK_MAX_BODY)).Decode(&update); err != nil {
+		log.Errorf("decode webhook update error: %s", err.Error())
+		http.Error(w, "bad update", http.StatusBadRequest)
+		return
+	}
+
+	Dispatch(webhook.handler, &update)
+	w.WriteHeader(http.StatusOK)
+}
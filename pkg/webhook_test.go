@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type recordingHandler struct {
+	lock    sync.Mutex
+	calls   []string
+	updates []*tgbotapi.Update
+}
+
+func (handler *recordingHandler) record(call string, update *tgbotapi.Update) error {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	handler.calls = append(handler.calls, call)
+	handler.updates = append(handler.updates, update)
+	return nil
+}
+
+func (handler *recordingHandler) Command(update *tgbotapi.Update) error {
+	return handler.record("command", update)
+}
+
+func (handler *recordingHandler) Keyboard(update *tgbotapi.Update) error {
+	return handler.record("keyboard", update)
+}
+
+func (handler *recordingHandler) InlineKeyboard(update *tgbotapi.Update) error {
+	return handler.record("inline", update)
+}
+
+func (handler *recordingHandler) Restore() error {
+	return nil
+}
+
+func (handler *recordingHandler) RunSchedules(interval time.Duration, quit <-chan struct{}) {}
+
+func TestWebhookServer(t *testing.T) {
+	handler := &recordingHandler{}
+	webhook, err := NewWebhookServer(handler, WebhookConfig{ListenAddr: ":0", Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	post := func(path string, fixture string) int {
+		body, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(server.URL+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if code := post("/webhook/s3cret", "update_command.json"); code != http.StatusOK {
+		t.Fatalf("command update: expected 200, got %d", code)
+	}
+	if code := post("/webhook/s3cret", "update_callback.json"); code != http.StatusOK {
+		t.Fatalf("callback update: expected 200, got %d", code)
+	}
+	if code := post("/webhook/wrong", "update_command.json"); code != http.StatusNotFound {
+		t.Fatalf("wrong secret: expected 404, got %d", code)
+	}
+
+	res, err := http.Post(server.URL+"/webhook/s3cret", "application/json", bytes.NewBufferString("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("broken json: expected 400, got %d", res.StatusCode)
+	}
+
+	res, err = http.Get(server.URL + "/webhook/s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("get update: expected 405, got %d", res.StatusCode)
+	}
+
+	if len(handler.calls) != 2 || handler.calls[0] != "command" || handler.calls[1] != "inline" {
+		t.Fatalf("unexpected dispatch %v", handler.calls)
+	}
+	if command := handler.updates[0].Message.Command(); command != CMD_NEW_GAME {
+		t.Fatalf("expected /%s, got /%s", CMD_NEW_GAME, command)
+	}
+	if data := handler.updates[1].CallbackQuery.Data; data != QUERY_DATA_REGISTER {
+		t.Fatalf("expected %s, got %s", QUERY_DATA_REGISTER, data)
+	}
+}
+
+func TestWebhookHealthz(t *testing.T) {
+	webhook, err := NewWebhookServer(&recordingHandler{}, WebhookConfig{ListenAddr: ":0", Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + HEALTHZ_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("unexpected healthz %d %q", res.StatusCode, body)
+	}
+}
+
+func TestWebhookHandleBehindSecret(t *testing.T) {
+	webhook, err := NewWebhookServer(&recordingHandler{}, WebhookConfig{ListenAddr: ":0", Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook.Handle(QUEUE_PATH, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "{}")
+	}))
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	for path, code := range map[string]int{
+		QUEUE_PATH:                     http.StatusNotFound,
+		"/webhook/s3cret" + QUEUE_PATH: http.StatusOK,
+	} {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Errorf("%s: expected %d, got %d", path, code, res.StatusCode)
+		}
+	}
+}
+
+func TestWebhookConfigValidate(t *testing.T) {
+	invalid := []WebhookConfig{
+		{Secret: "s3cret"},
+		{ListenAddr: ":8080"},
+		{ListenAddr: ":8080", Secret: "a/b"},
+		{ListenAddr: ":8080", Secret: "s3cret", CertFile: "cert.pem"},
+	}
+	for _, config := range invalid {
+		if _, err := NewWebhookServer(&recordingHandler{}, config); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}